@@ -0,0 +1,198 @@
+package logf
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// AsyncPolicy decides what an AsyncWriter does when its buffer is full.
+type AsyncPolicy int
+
+const (
+	// BlockOnFull blocks the write until there is room in the buffer.
+	BlockOnFull AsyncPolicy = iota
+	// DropOnFull discards the line if the buffer is full.
+	DropOnFull
+)
+
+const defaultAsyncBufSize = 1024
+
+// ErrWriterClosed is returned on writes to a closed writer.
+var ErrWriterClosed = errors.New("writer is closed")
+
+// AsyncWriterOpts represents the config options for an AsyncWriter.
+type AsyncWriterOpts struct {
+	// BufSize is the number of lines that can be queued. Defaults to 1024.
+	BufSize int
+
+	// Policy when the queue is full. Defaults to BlockOnFull.
+	Policy AsyncPolicy
+
+	// DroppedCallback, if set, is called every time a line is dropped
+	// with the total number of lines dropped so far.
+	DroppedCallback func(n int)
+}
+
+// AsyncWriter is an io.Writer that queues writes and writes them
+// to the underlying io.Writer in a background goroutine, keeping
+// the I/O off the logging path.
+type AsyncWriter struct {
+	w    io.Writer
+	opts AsyncWriterOpts
+
+	queue chan asyncItem
+	done  chan struct{}
+
+	// mu guards closed and sends on queue against Close.
+	mu     sync.RWMutex
+	closed bool
+
+	errMu sync.Mutex
+	err   error
+
+	dropped int64
+}
+
+// asyncItem is either a line to write or, if flushed is set,
+// a marker that is signalled once every line before it is written.
+type asyncItem struct {
+	b       []byte
+	flushed chan struct{}
+}
+
+// NewAsyncWriter returns an AsyncWriter that writes to w in the background.
+// Close should be called to write out queued lines before the program exits.
+func NewAsyncWriter(w io.Writer, opts AsyncWriterOpts) *AsyncWriter {
+	if opts.BufSize <= 0 {
+		opts.BufSize = defaultAsyncBufSize
+	}
+
+	a := &AsyncWriter{
+		w:     w,
+		opts:  opts,
+		queue: make(chan asyncItem, opts.BufSize),
+		done:  make(chan struct{}),
+	}
+	go a.drain()
+
+	return a
+}
+
+// Write queues a copy of p to be written. Write errors of the underlying
+// writer are returned by the next Flush or Close.
+func (a *AsyncWriter) Write(p []byte) (int, error) {
+	// The logger reuses its buffers, so the line has to be copied.
+	item := asyncItem{b: append([]byte(nil), p...)}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		return 0, ErrWriterClosed
+	}
+
+	if a.opts.Policy == DropOnFull {
+		select {
+		case a.queue <- item:
+		default:
+			n := atomic.AddInt64(&a.dropped, 1)
+			if a.opts.DroppedCallback != nil {
+				a.opts.DroppedCallback(int(n))
+			}
+		}
+		return len(p), nil
+	}
+
+	a.queue <- item
+	return len(p), nil
+}
+
+// Flush blocks until every line queued before the call is written and
+// returns the first write error since the last Flush or Close.
+func (a *AsyncWriter) Flush() error {
+	a.mu.RLock()
+	if a.closed {
+		a.mu.RUnlock()
+		return ErrWriterClosed
+	}
+
+	flushed := make(chan struct{})
+	a.queue <- asyncItem{flushed: flushed}
+	a.mu.RUnlock()
+
+	<-flushed
+	return a.takeErr()
+}
+
+// Close writes out all queued lines and stops the background goroutine.
+// It returns the first write error since the last Flush.
+func (a *AsyncWriter) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return ErrWriterClosed
+	}
+	a.closed = true
+	close(a.queue)
+	a.mu.Unlock()
+
+	<-a.done
+	return a.takeErr()
+}
+
+// DroppedTotal returns the number of lines dropped with DropOnFull so far.
+func (a *AsyncWriter) DroppedTotal() int64 {
+	return atomic.LoadInt64(&a.dropped)
+}
+
+// ChanWriter is an AsyncWriter that queues up to a fixed number of
+// lines in a channel, for callers that only need to set the capacity
+// and whether lines are dropped when it is full.
+type ChanWriter struct {
+	*AsyncWriter
+}
+
+// NewChanWriter returns a ChanWriter that writes to w in the background,
+// queueing up to capacity lines. If dropOnFull is set, lines that don't
+// fit in the queue are dropped, and counted by DroppedTotal, instead of
+// blocking. Close should be called to write out queued lines before the
+// program exits.
+func NewChanWriter(w io.Writer, capacity int, dropOnFull bool) *ChanWriter {
+	opts := AsyncWriterOpts{BufSize: capacity}
+	if dropOnFull {
+		opts.Policy = DropOnFull
+	}
+
+	return &ChanWriter{NewAsyncWriter(w, opts)}
+}
+
+// drain writes queued lines to the underlying writer until the queue is closed.
+func (a *AsyncWriter) drain() {
+	defer close(a.done)
+
+	for item := range a.queue {
+		if item.flushed != nil {
+			close(item.flushed)
+			continue
+		}
+
+		if _, err := a.w.Write(item.b); err != nil {
+			a.errMu.Lock()
+			if a.err == nil {
+				a.err = err
+			}
+			a.errMu.Unlock()
+		}
+	}
+}
+
+// takeErr returns and clears the recorded write error.
+func (a *AsyncWriter) takeErr() error {
+	a.errMu.Lock()
+	err := a.err
+	a.err = nil
+	a.errMu.Unlock()
+	return err
+}
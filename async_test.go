@@ -0,0 +1,124 @@
+package logf
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// slowWriter blocks every write until release is closed.
+type slowWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	release chan struct{}
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *slowWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestAsyncWriter(t *testing.T) {
+	buf := &slowWriter{release: make(chan struct{})}
+	close(buf.release)
+
+	w := NewAsyncWriter(buf, AsyncWriterOpts{})
+	l := New(Opts{Writer: w})
+	for i := 0; i < 100; i++ {
+		l.Info("hello world", "index", i)
+	}
+
+	require.NoError(t, w.Flush())
+	require.Equal(t, 100, strings.Count(buf.String(), "\n"))
+	require.Contains(t, buf.String(), "index=99")
+
+	l.Info("last line")
+	require.NoError(t, w.Close())
+	require.Contains(t, buf.String(), `message="last line"`)
+
+	_, err := w.Write([]byte("after close"))
+	require.Equal(t, ErrWriterClosed, err)
+	require.Equal(t, ErrWriterClosed, w.Flush())
+	require.Equal(t, ErrWriterClosed, w.Close())
+}
+
+func TestAsyncWriterDropOnFull(t *testing.T) {
+	buf := &slowWriter{release: make(chan struct{})}
+
+	var dropped []int
+	w := NewAsyncWriter(buf, AsyncWriterOpts{
+		BufSize:         2,
+		Policy:          DropOnFull,
+		DroppedCallback: func(n int) { dropped = append(dropped, n) },
+	})
+
+	// The first line is picked up by the drain goroutine which blocks
+	// on the slow writer, the next two fill up the queue and the rest drop.
+	w.Write([]byte("1\n"))
+	require.Eventually(t, func() bool { return len(w.queue) == 0 }, time.Second, time.Millisecond)
+	for i := 2; i <= 5; i++ {
+		n, err := w.Write([]byte(strconv.Itoa(i) + "\n"))
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+	}
+	require.Equal(t, []int{1, 2}, dropped)
+
+	close(buf.release)
+	require.NoError(t, w.Close())
+	require.Equal(t, "1\n2\n3\n", buf.String())
+}
+
+func TestChanWriter(t *testing.T) {
+	buf := &slowWriter{release: make(chan struct{})}
+	w := NewChanWriter(buf, 2, true)
+
+	w.Write([]byte("1\n"))
+	require.Eventually(t, func() bool { return len(w.queue) == 0 }, time.Second, time.Millisecond)
+	for i := 2; i <= 6; i++ {
+		w.Write([]byte(strconv.Itoa(i) + "\n"))
+	}
+	require.Equal(t, int64(3), w.DroppedTotal())
+
+	close(buf.release)
+	require.NoError(t, w.Close())
+	require.Equal(t, "1\n2\n3\n", buf.String())
+	require.Equal(t, int64(3), w.DroppedTotal())
+
+	_, err := w.Write([]byte("after close"))
+	require.Equal(t, ErrWriterClosed, err)
+
+	// Without dropOnFull, writes block until there is room.
+	buf = &slowWriter{release: make(chan struct{})}
+	close(buf.release)
+	w = NewChanWriter(buf, 1, false)
+	l := New(Opts{Writer: w})
+	for i := 0; i < 100; i++ {
+		l.Info("hello world", "index", i)
+	}
+	require.NoError(t, w.Close())
+	require.Equal(t, 100, strings.Count(buf.String(), "\n"))
+	require.Zero(t, w.DroppedTotal())
+}
+
+func TestAsyncWriterError(t *testing.T) {
+	w := NewAsyncWriter(&errWriter{}, AsyncWriterOpts{})
+	w.Write([]byte("hello"))
+	require.EqualError(t, w.Flush(), "dummy error")
+	require.NoError(t, w.Flush())
+
+	w.Write([]byte("hello"))
+	require.EqualError(t, w.Close(), "dummy error")
+}
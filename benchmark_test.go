@@ -3,7 +3,12 @@ package logf_test
 import (
 	"errors"
 	"io"
+	"math/big"
+	"math/rand"
+	"runtime"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/zerodha/logf"
 )
@@ -41,6 +46,29 @@ func BenchmarkOneFieldWithDefaultFields(b *testing.B) {
 	})
 }
 
+func BenchmarkNoFieldWithDefaultFields(b *testing.B) {
+	logger := logf.New(logf.Opts{Writer: io.Discard, DefaultFields: []interface{}{"component", "logf", "pid", 1234}})
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			logger.Info("hello world")
+		}
+	})
+}
+
+func BenchmarkDynamicDefaultFields(b *testing.B) {
+	logger := logf.New(logf.Opts{Writer: io.Discard, DefaultFields: []interface{}{
+		"component", "logf", "goroutines", func() interface{} { return runtime.NumGoroutine() }}})
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			logger.Info("hello world", "stack", "testing")
+		}
+	})
+}
+
 func BenchmarkThreeFields(b *testing.B) {
 	logger := logf.New(logf.Opts{Writer: io.Discard})
 	b.ReportAllocs()
@@ -55,6 +83,36 @@ func BenchmarkThreeFields(b *testing.B) {
 	})
 }
 
+func BenchmarkOneField_Typed(b *testing.B) {
+	logger := logf.New(logf.Opts{Writer: io.Discard})
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(p *testing.PB) {
+		var i int
+		for p.Next() {
+			i++
+			logger.InfoA("hello world", logf.Int("count", i))
+		}
+	})
+}
+
+func BenchmarkThreeFields_Typed(b *testing.B) {
+	logger := logf.New(logf.Opts{Writer: io.Discard})
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(p *testing.PB) {
+		var i int
+		for p.Next() {
+			i++
+			logger.InfoA("request completed",
+				logf.String("component", "api"), logf.String("method", "GET"), logf.Int("bytes", i),
+			)
+		}
+	})
+}
+
 func BenchmarkErrorField(b *testing.B) {
 	logger := logf.New(logf.Opts{Writer: io.Discard})
 	b.ReportAllocs()
@@ -92,6 +150,29 @@ func BenchmarkHugePayload(b *testing.B) {
 	})
 }
 
+func BenchmarkHugePayload_InitialBufSize(b *testing.B) {
+	logger := logf.New(logf.Opts{Writer: io.Discard, InitialBufSize: 512})
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			logger.Info("fetched details",
+				"id", 11,
+				"title", "perfume Oil",
+				"description", "Mega Discount, Impression of A...",
+				"price", 13,
+				"discountPercentage", 8.4,
+				"rating", 4.26,
+				"stock", 65,
+				"brand", "Impression of Acqua Di Gio",
+				"category", "fragrances",
+				"thumbnail", "https://dummyjson.com/image/i/products/11/thumbnail.jpg",
+			)
+		}
+	})
+}
+
 func BenchmarkThreeFields_WithCaller(b *testing.B) {
 	logger := logf.New(logf.Opts{Writer: io.Discard, CallerSkipFrameCount: 3, EnableCaller: true})
 	b.ReportAllocs()
@@ -179,3 +260,417 @@ func BenchmarkHugePayload_WithColor(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkThreeFields_Msgpack(b *testing.B) {
+	logger := logf.New(logf.Opts{Writer: io.Discard, Format: logf.MsgpackFormat})
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			logger.Info("request completed",
+				"component", "api", "method", "GET", "bytes", 1<<18,
+			)
+		}
+	})
+}
+
+func BenchmarkDisabledLevel(b *testing.B) {
+	logger := logf.New(logf.Opts{Writer: io.Discard})
+	compute := func() string { return strconv.Itoa(rand.Int()) }
+
+	b.Run("unguarded", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logger.Debug("expensive", "data", compute())
+		}
+	})
+
+	b.Run("guarded", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if logger.IsEnabled(logf.DebugLevel) {
+				logger.Debug("expensive", "data", compute())
+			}
+		}
+	})
+
+	b.Run("string", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logger.Debug("expensive " + compute())
+		}
+	})
+
+	b.Run("func", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logger.DebugFunc(func() string { return "expensive " + compute() })
+		}
+	})
+}
+
+func BenchmarkDiscard(b *testing.B) {
+	logger := logf.Discard()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			logger.Error("hello world", "component", "api", "count", 1)
+		}
+	})
+}
+
+func BenchmarkLazyField(b *testing.B) {
+	logger := logf.New(logf.Opts{Writer: io.Discard})
+	f := logf.LazyField(func() interface{} { return strconv.Itoa(rand.Int()) })
+
+	b.Run("disabled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logger.Debug("expensive", "data", f)
+		}
+	})
+
+	b.Run("enabled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logger.Info("expensive", "data", f)
+		}
+	})
+}
+
+func BenchmarkTimeField(b *testing.B) {
+	logger := logf.New(logf.Opts{Writer: io.Discard})
+	ts := time.Date(2022, 7, 7, 12, 9, 10, 0, time.UTC)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			logger.Info("hello world", "at", ts)
+		}
+	})
+}
+
+func BenchmarkDurationField(b *testing.B) {
+	logger := logf.New(logf.Opts{Writer: io.Discard})
+	d := 1234 * time.Microsecond
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			logger.Info("request completed", "took", d)
+		}
+	})
+}
+
+func BenchmarkUintField(b *testing.B) {
+	logger := logf.New(logf.Opts{Writer: io.Discard})
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			logger.Info("request completed", "bytes", uint64(1<<18))
+		}
+	})
+}
+
+func BenchmarkSliceField(b *testing.B) {
+	for _, f := range []logf.SliceFormat{logf.SliceJSON, logf.SliceComma} {
+		logger := logf.New(logf.Opts{Writer: io.Discard, SliceFormat: f})
+		name := "json"
+		if f == logf.SliceComma {
+			name = "comma"
+		}
+
+		b.Run(name+"/strings", func(b *testing.B) {
+			tags := []string{"alpha", "beta", "gamma"}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				logger.Info("request completed", "tags", tags)
+			}
+		})
+
+		b.Run(name+"/reflect", func(b *testing.B) {
+			ports := []uint16{80, 443, 8080}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				logger.Info("request completed", "ports", ports)
+			}
+		})
+	}
+}
+
+func BenchmarkComplexField(b *testing.B) {
+	logger := logf.New(logf.Opts{Writer: io.Discard})
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			logger.Info("hello world", "c", 3.5+2i)
+		}
+	})
+}
+
+func BenchmarkBytesField(b *testing.B) {
+	data := make([]byte, 64)
+	rand.Read(data)
+
+	for _, c := range []struct {
+		name string
+		enc  logf.BytesEncoding
+	}{{"raw", logf.BytesRaw}, {"hex", logf.BytesHex}, {"base64", logf.BytesBase64}} {
+		logger := logf.New(logf.Opts{Writer: io.Discard, BytesEncoding: c.enc})
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				logger.Info("hello world", "data", data)
+			}
+		})
+	}
+}
+
+type benchObject struct {
+	id   int
+	name string
+}
+
+func (o benchObject) MarshalLogObject(enc logf.ObjectEncoder) error {
+	enc.AddInt("id", int64(o.id))
+	enc.AddString("name", o.name)
+	return nil
+}
+
+func BenchmarkObjectField(b *testing.B) {
+	logger := logf.New(logf.Opts{Writer: io.Discard})
+	obj := &benchObject{id: 1, name: "alice"}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			logger.Info("hello world", "user", obj)
+		}
+	})
+}
+
+func BenchmarkStructField(b *testing.B) {
+	type user struct {
+		ID    int    `logf:"id"`
+		Name  string `logf:"name"`
+		Email string `logf:"email"`
+	}
+
+	logger := logf.New(logf.Opts{Writer: io.Discard})
+	u := user{ID: 1, Name: "alice", Email: "alice@example.com"}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			logger.Info("hello world", logf.Struct("user", u)...)
+		}
+	})
+}
+
+type benchUUID [16]byte
+
+func BenchmarkTypeEncoder(b *testing.B) {
+	logf.RegisterTypeEncoder(benchUUID{}, func(buf logf.Appender, v interface{}) {
+		u := v.(benchUUID)
+		buf.AppendHex(u[:])
+	})
+	defer logf.RegisterTypeEncoder(benchUUID{}, nil)
+
+	logger := logf.New(logf.Opts{Writer: io.Discard})
+	id := benchUUID{1, 2, 3, 4}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			logger.Info("hello world", "id", id)
+		}
+	})
+}
+
+func BenchmarkBigIntField(b *testing.B) {
+	logger := logf.New(logf.Opts{Writer: io.Discard})
+	n, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			logger.Info("hello world", "amount", n)
+		}
+	})
+}
+
+func BenchmarkHooks(b *testing.B) {
+	hook := logf.HookFunc(func(lvl logf.Level, msg string, fields []interface{}) ([]interface{}, bool) {
+		return fields, true
+	})
+
+	b.Run("none", func(b *testing.B) {
+		logger := logf.New(logf.Opts{Writer: io.Discard})
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			logger.Info("hello world", "component", "api")
+		}
+	})
+
+	b.Run("one", func(b *testing.B) {
+		logger := logf.New(logf.Opts{Writer: io.Discard, Hooks: []logf.Hook{hook}})
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			logger.Info("hello world", "component", "api")
+		}
+	})
+
+	b.Run("level", func(b *testing.B) {
+		logger := logf.New(logf.Opts{Writer: io.Discard, Hooks: []logf.Hook{logf.NewLevelHook(logf.ErrorLevel, logf.FatalLevel, hook)}})
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			logger.Info("hello world", "component", "api")
+		}
+	})
+}
+
+func BenchmarkSortFields(b *testing.B) {
+	b.Run("unsorted", func(b *testing.B) {
+		logger := logf.New(logf.Opts{Writer: io.Discard, DefaultFields: []interface{}{"service", "api"}})
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			logger.Info("hello world", "component", "api", "method", "GET", "status", 200)
+		}
+	})
+
+	b.Run("sorted", func(b *testing.B) {
+		logger := logf.New(logf.Opts{Writer: io.Discard, DefaultFields: []interface{}{"service", "api"}, SortFields: true})
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			logger.Info("hello world", "component", "api", "method", "GET", "status", 200)
+		}
+	})
+}
+
+func BenchmarkPointerField(b *testing.B) {
+	type user struct {
+		ID   int
+		Name string
+	}
+	u := &user{ID: 1, Name: "alice"}
+
+	for _, deref := range []bool{false, true} {
+		logger := logf.New(logf.Opts{Writer: io.Discard, DereferencePointers: deref})
+
+		b.Run("value/deref="+strconv.FormatBool(deref), func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				logger.Info("hello world", "user", *u)
+			}
+		})
+
+		b.Run("pointer/deref="+strconv.FormatBool(deref), func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				logger.Info("hello world", "user", u)
+			}
+		})
+	}
+}
+
+func BenchmarkDedupeKeys(b *testing.B) {
+	b.Run("off", func(b *testing.B) {
+		logger := logf.New(logf.Opts{Writer: io.Discard, DefaultFields: []interface{}{"component", "worker"}})
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			logger.Info("hello world", "component", "api", "method", "GET", "status", 200)
+		}
+	})
+
+	b.Run("on", func(b *testing.B) {
+		logger := logf.New(logf.Opts{Writer: io.Discard, DefaultFields: []interface{}{"component", "worker"}, DedupeKeys: true})
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			logger.Info("hello world", "component", "api", "method", "GET", "status", 200)
+		}
+	})
+}
+
+// BenchmarkSyncWriterContention measures the cost of the lock around the
+// writer when many goroutines log at once, eg: with -cpu 8.
+func BenchmarkSyncWriterContention(b *testing.B) {
+	logger := logf.New(logf.Opts{Writer: io.Discard})
+
+	b.Run("serial", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			logger.Info("hello world", "component", "api")
+		}
+	})
+
+	b.Run("goroutines=100", func(b *testing.B) {
+		// RunParallel starts parallelism * GOMAXPROCS goroutines.
+		procs := runtime.GOMAXPROCS(0)
+		b.SetParallelism((100 + procs - 1) / procs)
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(p *testing.PB) {
+			for p.Next() {
+				logger.Info("hello world", "component", "api")
+			}
+		})
+	})
+}
+
+// busyWriter simulates a writer under I/O pressure that takes a few
+// microseconds per write.
+type busyWriter struct{}
+
+func (busyWriter) Write(p []byte) (int, error) {
+	for start := time.Now(); time.Since(start) < 5*time.Microsecond; {
+	}
+	return len(p), nil
+}
+
+func BenchmarkChanWriter(b *testing.B) {
+	b.Run("sync", func(b *testing.B) {
+		logger := logf.New(logf.Opts{Writer: busyWriter{}})
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			logger.Info("hello world", "component", "api")
+		}
+	})
+
+	b.Run("chan", func(b *testing.B) {
+		w := logf.NewChanWriter(busyWriter{}, 1024, true)
+		defer w.Close()
+
+		logger := logf.New(logf.Opts{Writer: w})
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			logger.Info("hello world", "component", "api")
+		}
+	})
+}
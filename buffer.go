@@ -1,6 +1,9 @@
 package logf
 
 import (
+	"encoding/base64"
+	"math"
+	"math/big"
 	"strconv"
 	"sync"
 	"time"
@@ -10,19 +13,30 @@ import (
 // byteBufferPool is a pool of byteBuffer
 type byteBufferPool struct {
 	p sync.Pool
+
+	// size is the capacity of new buffers.
+	size int
+
+	// maxSize is the capacity above which buffers are dropped instead
+	// of being put back.
+	maxSize int
 }
 
 // Get returns a new instance of byteBuffer or gets from the object pool
 func (bbp *byteBufferPool) Get() *byteBuffer {
 	bbv := bbp.p.Get()
 	if bbv == nil {
-		return &byteBuffer{}
+		return &byteBuffer{B: make([]byte, 0, bbp.size)}
 	}
 	return bbv.(*byteBuffer)
 }
 
 // Put puts back the ByteBuffer into the object pool
 func (bbp *byteBufferPool) Put(bb *byteBuffer) {
+	// Don't hold on to the memory of an occasional huge line.
+	if bbp.maxSize > 0 && cap(bb.B) > bbp.maxSize {
+		return
+	}
 	bb.Reset()
 	bbp.p.Put(bb)
 }
@@ -32,6 +46,18 @@ type byteBuffer struct {
 	B []byte
 }
 
+// Grow grows the buffer's capacity, if necessary, to guarantee space
+// for another n bytes without reallocating.
+func (bb *byteBuffer) Grow(n int) {
+	if n <= cap(bb.B)-len(bb.B) {
+		return
+	}
+
+	b := make([]byte, len(bb.B), 2*cap(bb.B)+n)
+	copy(b, bb.B)
+	bb.B = b
+}
+
 // AppendByte appends a single byte to the buffer.
 func (bb *byteBuffer) AppendByte(b byte) {
 	bb.B = append(bb.B, b)
@@ -47,11 +73,40 @@ func (bb *byteBuffer) AppendInt(i int64) {
 	bb.B = strconv.AppendInt(bb.B, i, 10)
 }
 
+// AppendUint appends an unsigned integer to the underlying buffer (assuming base 10).
+func (bb *byteBuffer) AppendUint(i uint64) {
+	bb.B = strconv.AppendUint(bb.B, i, 10)
+}
+
+// AppendUintBase appends an unsigned integer to the underlying buffer
+// in the given base, eg: 16 for hex.
+func (bb *byteBuffer) AppendUintBase(i uint64, base int) {
+	bb.B = strconv.AppendUint(bb.B, i, base)
+}
+
 // AppendTime appends the time formatted using the specified layout.
 func (bb *byteBuffer) AppendTime(t time.Time, layout string) {
 	bb.B = t.AppendFormat(bb.B, layout)
 }
 
+// AppendBig appends the decimal form of a non-nil *big.Int, *big.Float
+// or *big.Rat. Floats are written with the fewest digits that represent
+// them exactly and rationals as a/b, or as integers if b is 1.
+func (bb *byteBuffer) AppendBig(v interface{}) {
+	switch n := v.(type) {
+	case *big.Int:
+		bb.B = n.Append(bb.B, 10)
+	case *big.Float:
+		bb.B = n.Append(bb.B, 'g', -1)
+	case *big.Rat:
+		bb.B = n.Num().Append(bb.B, 10)
+		if !n.IsInt() {
+			bb.B = append(bb.B, '/')
+			bb.B = n.Denom().Append(bb.B, 10)
+		}
+	}
+}
+
 // AppendBool appends a bool to the underlying buffer.
 func (bb *byteBuffer) AppendBool(v bool) {
 	bb.B = strconv.AppendBool(bb.B, v)
@@ -62,6 +117,155 @@ func (bb *byteBuffer) AppendFloat(f float64, bitSize int) {
 	bb.B = strconv.AppendFloat(bb.B, f, 'f', -1, bitSize)
 }
 
+// AppendHex appends the lowercase hex encoding of p to the underlying buffer.
+func (bb *byteBuffer) AppendHex(p []byte) {
+	bb.Grow(2 * len(p))
+	for _, c := range p {
+		bb.B = append(bb.B, hex[c>>4], hex[c&0xf])
+	}
+}
+
+// AppendBase64 appends the standard base64 encoding of p to the underlying buffer.
+func (bb *byteBuffer) AppendBase64(p []byte) {
+	n, size := len(bb.B), base64.StdEncoding.EncodedLen(len(p))
+	bb.Grow(size)
+	bb.B = bb.B[:n+size]
+	base64.StdEncoding.Encode(bb.B[n:], p)
+}
+
+// AppendComplex appends a complex number in the (a+bi) form
+// to the underlying buffer.
+func (bb *byteBuffer) AppendComplex(c complex128, bitSize int) {
+	bb.AppendByte('(')
+	bb.AppendFloat(real(c), bitSize/2)
+
+	// The imaginary part always has a sign. +Inf already has one.
+	im := imag(c)
+	if math.IsNaN(im) || (!math.Signbit(im) && !math.IsInf(im, 1)) {
+		bb.AppendByte('+')
+	}
+	bb.AppendFloat(im, bitSize/2)
+	bb.AppendString("i)")
+}
+
+// AppendDuration appends the duration in the same form as
+// time.Duration.String (eg: 1h2m0.5s, 1.234ms) without allocating.
+// Adapted from the Go standard library's time.Duration.String.
+func (bb *byteBuffer) AppendDuration(d time.Duration) {
+	// Largest time is 2540400h10m10.000000000s
+	var buf [32]byte
+	w := len(buf)
+
+	u := uint64(d)
+	neg := d < 0
+	if neg {
+		u = -u
+	}
+
+	if u < uint64(time.Second) {
+		// Special case: if duration is smaller than a second,
+		// use smaller units, like 1.2ms
+		var prec int
+		w--
+		buf[w] = 's'
+		w--
+		switch {
+		case u == 0:
+			bb.AppendString("0s")
+			return
+		case u < uint64(time.Microsecond):
+			// print nanoseconds
+			prec = 0
+			buf[w] = 'n'
+		case u < uint64(time.Millisecond):
+			// print microseconds
+			prec = 3
+			// U+00B5 'µ' micro sign == 0xC2 0xB5
+			w-- // Need room for two bytes.
+			copy(buf[w:], "µ")
+		default:
+			// print milliseconds
+			prec = 6
+			buf[w] = 'm'
+		}
+		w, u = fmtFrac(buf[:w], u, prec)
+		w = fmtInt(buf[:w], u)
+	} else {
+		w--
+		buf[w] = 's'
+
+		w, u = fmtFrac(buf[:w], u, 9)
+
+		// u is now integer seconds
+		w = fmtInt(buf[:w], u%60)
+		u /= 60
+
+		// u is now integer minutes
+		if u > 0 {
+			w--
+			buf[w] = 'm'
+			w = fmtInt(buf[:w], u%60)
+			u /= 60
+
+			// u is now integer hours
+			// Stop at hours because days can be different lengths.
+			if u > 0 {
+				w--
+				buf[w] = 'h'
+				w = fmtInt(buf[:w], u)
+			}
+		}
+	}
+
+	if neg {
+		w--
+		buf[w] = '-'
+	}
+
+	bb.B = append(bb.B, buf[w:]...)
+}
+
+// fmtFrac formats the fraction of v/10**prec (e.g., ".12345") into the
+// tail of buf, omitting trailing zeros. It omits the decimal
+// point too when the fraction is 0. It returns the index where the
+// output bytes begin and the value v/10**prec.
+func fmtFrac(buf []byte, v uint64, prec int) (nw int, nv uint64) {
+	// Omit trailing zeros up to and including decimal point.
+	w := len(buf)
+	print := false
+	for i := 0; i < prec; i++ {
+		digit := v % 10
+		print = print || digit != 0
+		if print {
+			w--
+			buf[w] = byte(digit) + '0'
+		}
+		v /= 10
+	}
+	if print {
+		w--
+		buf[w] = '.'
+	}
+	return w, v
+}
+
+// fmtInt formats v into the tail of buf.
+// It returns the index where the output begins.
+func fmtInt(buf []byte, v uint64) int {
+	w := len(buf)
+	if v == 0 {
+		w--
+		buf[w] = '0'
+	} else {
+		for v > 0 {
+			w--
+			buf[w] = byte(v%10) + '0'
+			v /= 10
+		}
+	}
+	return w
+}
+
 // Bytes returns a mutable reference to the underlying buffer.
 func (bb *byteBuffer) Bytes() []byte {
 	return bb.B
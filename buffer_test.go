@@ -0,0 +1,63 @@
+package logf
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferGrow(t *testing.T) {
+	var bb byteBuffer
+	bb.AppendString("hello")
+
+	bb.Grow(100)
+	require.GreaterOrEqual(t, cap(bb.B)-len(bb.B), 100)
+	require.Equal(t, "hello", string(bb.Bytes()))
+
+	// No reallocation if there's enough room.
+	p := &bb.B[0]
+	bb.Grow(10)
+	require.Same(t, p, &bb.B[0])
+}
+
+func TestBufferPool(t *testing.T) {
+	bbp := &byteBufferPool{size: 256, maxSize: 1024}
+	bb := bbp.Get()
+	require.Equal(t, 256, cap(bb.B))
+
+	// Huge buffers are dropped when they are put back, so the pool
+	// never hands out buffers larger than maxSize.
+	for i := 0; i < 100; i++ {
+		bb := bbp.Get()
+		bb.Grow(2048)
+		bbp.Put(bb)
+	}
+	for i := 0; i < 100; i++ {
+		require.LessOrEqual(t, cap(bbp.Get().B), 1024)
+	}
+}
+
+func TestBufferAppendUint(t *testing.T) {
+	for _, c := range []struct {
+		val  uint64
+		base int
+		want string
+	}{
+		{0, 10, "0"},
+		{math.MaxUint64, 10, "18446744073709551615"},
+		{0, 16, "0"},
+		{255, 16, "ff"},
+		{math.MaxUint64, 16, "ffffffffffffffff"},
+	} {
+		var bb byteBuffer
+		if c.base == 10 {
+			bb.AppendUint(c.val)
+			require.Equal(t, c.want, string(bb.Bytes()))
+			bb.Reset()
+		}
+
+		bb.AppendUintBase(c.val, c.base)
+		require.Equal(t, c.want, string(bb.Bytes()))
+	}
+}
@@ -0,0 +1,249 @@
+package logf
+
+import (
+	"encoding"
+	"fmt"
+	"math/big"
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+// writeCSVEntry writes a complete log entry as a CSV row into the buffer.
+func (l *Logger) writeCSVEntry(buf *byteBuffer, msg string, lvl Level, fn, file string, line int, fields []interface{}, typed []Field) {
+	// Columns are looked up by key across all the fields, so typed fields
+	// are expanded into key/value pairs.
+	defaults, ns := expandFields(l.DefaultFields, nil, "")
+	if list := l.levelFields(lvl); len(list) > 0 {
+		list, _ = expandFields(list, nil, "")
+		defaults = append(defaults[:len(defaults):len(defaults)], list...)
+	}
+	fields, _ = expandFields(fields, typed, ns)
+
+	start := len(buf.B)
+	buf.AppendTime(time.Now(), l.Opts.TimestampFormat)
+	quoteCSVCell(buf, start)
+
+	buf.AppendByte(',')
+	buf.AppendString(lvl.String())
+
+	buf.AppendByte(',')
+	start = len(buf.B)
+	buf.AppendString(msg)
+	quoteCSVCell(buf, start)
+
+	buf.AppendByte(',')
+	if l.Opts.EnableCaller {
+		start = len(buf.B)
+		buf.AppendString(file)
+		buf.AppendByte(':')
+		buf.AppendInt(int64(line))
+		quoteCSVCell(buf, start)
+	}
+
+	// The function name column is only present if enabled.
+	if l.Opts.EnableCallerFunc {
+		buf.AppendByte(',')
+		start = len(buf.B)
+		buf.AppendString(fn)
+		quoteCSVCell(buf, start)
+	}
+
+	// Without fixed columns, all fields go into a single JSON column.
+	if len(l.Opts.CSVColumns) == 0 {
+		buf.AppendByte(',')
+		start = len(buf.B)
+		l.writeJSONFields(buf, defaults, fields, nil)
+		quoteCSVCell(buf, start)
+		buf.AppendString(l.Opts.LineEnding)
+		return
+	}
+
+	for _, col := range l.Opts.CSVColumns {
+		buf.AppendByte(',')
+		if val, ok := l.lookupField(col, defaults, fields); ok {
+			start = len(buf.B)
+			o := l.values()
+			o.writeCSVValue(buf, val)
+			quoteCSVCell(buf, start)
+		}
+	}
+
+	if !l.Opts.CSVDropOverflow {
+		buf.AppendByte(',')
+		start = len(buf.B)
+		l.writeJSONFields(buf, defaults, fields, l.Opts.CSVColumns)
+		quoteCSVCell(buf, start)
+	}
+
+	buf.AppendString(l.Opts.LineEnding)
+}
+
+// lookupField returns the value of the last occurrence of key in the
+// default fields and the given fields, so per-call fields win.
+func (l *Logger) lookupField(key string, defaults, fields []interface{}) (interface{}, bool) {
+	for _, list := range [2][]interface{}{fields, defaults} {
+		for i := len(list) - 2; i >= 0; i -= 2 {
+			if fieldKey(list[i]) == key {
+				return l.fieldValue(key, list[i+1]), true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// writeCSVValue writes the plain text form of a field value into the buffer.
+// nil values produce an empty cell.
+func (o *valueOpts) writeCSVValue(buf *byteBuffer, val interface{}) {
+	switch v := val.(type) {
+	case nil:
+	case []byte:
+		if o.bytesEncoding != BytesRaw {
+			o.writeEncodedBytes(buf, v, false)
+			return
+		}
+		buf.B = append(buf.B, v...)
+	case string:
+		buf.AppendString(v)
+	case int:
+		buf.AppendInt(int64(v))
+	case int8:
+		buf.AppendInt(int64(v))
+	case int16:
+		buf.AppendInt(int64(v))
+	case int32:
+		buf.AppendInt(int64(v))
+	case int64:
+		buf.AppendInt(v)
+	case uint:
+		buf.AppendUint(uint64(v))
+	case uint8:
+		buf.AppendUint(uint64(v))
+	case uint16:
+		buf.AppendUint(uint64(v))
+	case uint32:
+		buf.AppendUint(uint64(v))
+	case uint64:
+		buf.AppendUint(v)
+	case uintptr:
+		o.writePointerValue(buf, v, false)
+	case unsafe.Pointer:
+		o.writePointerValue(buf, uintptr(v), false)
+	case float32:
+		buf.AppendFloat(float64(v), 32)
+	case float64:
+		buf.AppendFloat(v, 64)
+	case complex64:
+		buf.AppendComplex(complex128(v), 64)
+	case complex128:
+		buf.AppendComplex(v, 128)
+	case bool:
+		buf.AppendBool(v)
+	case time.Time:
+		if !v.IsZero() {
+			buf.AppendTime(v, o.fieldTimeFormat)
+		}
+	case time.Duration:
+		o.writeDurationValue(buf, v)
+	case *string, *int, *int32, *int64, *uint32, *uint64, *float32, *float64, *bool, *time.Time:
+		o.writeCSVValue(buf, derefBasic(v))
+	case *big.Int, *big.Float, *big.Rat:
+		if !isNilPointer(v) {
+			buf.AppendBig(v)
+		}
+	case ObjectMarshaler:
+		if !isNilPointer(v) {
+			o.writeJSONValue(buf, v, 0)
+		}
+	case error, fmt.Stringer, encoding.TextMarshaler:
+		if isNilPointer(v) {
+			return
+		}
+		if tmp, ok := typeEncoded(v); ok {
+			buf.B = append(buf.B, tmp.Bytes()...)
+			bufPool.Put(tmp)
+			return
+		}
+		buf.AppendString(o.textValue(v))
+	case []string, []int, []int64, []float64, []bool:
+		o.writeJSONValue(buf, v, 0)
+	default:
+		if tmp, ok := typeEncoded(val); ok {
+			buf.B = append(buf.B, tmp.Bytes()...)
+			bufPool.Put(tmp)
+			return
+		}
+		if b, ok := marshalJSON(val); ok {
+			buf.B = append(buf.B, b...)
+			return
+		}
+
+		switch rv := reflect.ValueOf(val); rv.Kind() {
+		case reflect.Map:
+			o.writeJSONValue(buf, val, 0)
+		case reflect.Ptr:
+			if !o.dereferencePointers {
+				buf.AppendString(o.sprintValue(val))
+				break
+			}
+			if rv.IsNil() {
+				break
+			}
+			if o.derefs >= maxNestingDepth {
+				buf.AppendString("...")
+				break
+			}
+			o.derefs++
+			o.writeCSVValue(buf, rv.Elem().Interface())
+			o.derefs--
+		case reflect.Slice, reflect.Array:
+			if !o.reflectFields {
+				buf.AppendString(o.sprintValue(val))
+				break
+			}
+			o.writeJSONValue(buf, val, 0)
+		default:
+			buf.AppendString(o.sprintValue(val))
+		}
+	}
+}
+
+// quoteCSVCell quotes the cell written to the buffer from start onwards
+// in place, as per RFC4180, if it contains a separator, quote or line break.
+func quoteCSVCell(buf *byteBuffer, start int) {
+	var (
+		quotes int
+		needs  bool
+	)
+	for _, c := range buf.B[start:] {
+		switch c {
+		case '"':
+			quotes++
+			needs = true
+		case ',', '\r', '\n':
+			needs = true
+		}
+	}
+	if !needs {
+		return
+	}
+
+	// Grow the buffer to fit the enclosing quotes and the doubled quotes,
+	// then shift the cell right to left into its final position.
+	end := len(buf.B)
+	buf.Grow(quotes + 2)
+	buf.B = buf.B[:end+quotes+2]
+
+	dst := len(buf.B) - 1
+	buf.B[dst] = '"'
+	for src := end - 1; src >= start; src-- {
+		dst--
+		buf.B[dst] = buf.B[src]
+		if buf.B[src] == '"' {
+			dst--
+			buf.B[dst] = '"'
+		}
+	}
+	buf.B[dst-1] = '"'
+}
@@ -0,0 +1,103 @@
+package logf
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readCSV(t *testing.T, b []byte) [][]string {
+	r := csv.NewReader(bytes.NewReader(b))
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	require.NoError(t, err)
+	return rows
+}
+
+func TestCSVFormat(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, Format: CSVFormat, DefaultFields: []interface{}{"component", "logf"}})
+
+	l.Info(`hello, "world"`, "count", 3, "error", errors.New("multi\nline"), "odd")
+	l.Warn("no fields")
+
+	rows := readCSV(t, buf.Bytes())
+	require.Len(t, rows, 2)
+
+	row := rows[0]
+	require.Len(t, row, 5)
+	require.NotEmpty(t, row[0])
+	require.Equal(t, "info", row[1])
+	require.Equal(t, `hello, "world"`, row[2])
+	require.Equal(t, "", row[3])
+
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(row[4]), &fields))
+	require.Equal(t, map[string]interface{}{
+		"component": "logf",
+		"count":     float64(3),
+		"error":     "multi\nline",
+		"!BADKEY":   "odd",
+	}, fields)
+
+	require.Equal(t, []string{rows[1][0], "warn", "no fields", "", `{"component":"logf"}`}, rows[1])
+}
+
+func TestCSVFormatWithColumns(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{
+		Writer:        buf,
+		Format:        CSVFormat,
+		EnableCaller:  true,
+		CSVColumns:    []string{"component", "user", "bytes"},
+		DefaultFields: []interface{}{"component", "logf"},
+	})
+
+	l.Info("hello", "bytes", 10, "extra", "a,b", "component", "api")
+	l.Info("hello", "user", nil)
+
+	rows := readCSV(t, buf.Bytes())
+	require.Len(t, rows, 2)
+	require.Contains(t, rows[0][3], "csv_test.go:")
+	require.Equal(t, []string{"api", "", "10", `{"extra":"a,b"}`}, rows[0][4:])
+	require.Equal(t, []string{"logf", "", "", `{}`}, rows[1][4:])
+
+	buf.Reset()
+	l = New(Opts{Writer: buf, Format: CSVFormat, CSVColumns: []string{"user"}, CSVDropOverflow: true})
+	l.Info("hello", "user", "karan", "extra", 1)
+	rows = readCSV(t, buf.Bytes())
+	require.Equal(t, []string{"hello", "", "karan"}, rows[0][2:])
+}
+
+func TestCSVFormatWithCallerFunc(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, Format: CSVFormat, EnableCaller: true, EnableCallerFunc: true})
+
+	l.Info("hello", "a", 1)
+
+	rows := readCSV(t, buf.Bytes())
+	require.Len(t, rows, 1)
+	require.Contains(t, rows[0][3], "csv_test.go:")
+	require.Equal(t, []string{"logf.TestCSVFormatWithCallerFunc", `{"a":1}`}, rows[0][4:])
+}
+
+func TestQuoteCSVCell(t *testing.T) {
+	for in, want := range map[string]string{
+		"":          "",
+		"plain":     "plain",
+		"a,b":       `"a,b"`,
+		`say "hi"`:  `"say ""hi"""`,
+		`"`:         `""""`,
+		"line\nnew": "\"line\nnew\"",
+	} {
+		buf := &byteBuffer{}
+		buf.AppendString("x,")
+		buf.AppendString(in)
+		quoteCSVCell(buf, 2)
+		require.Equal(t, "x,"+want, string(buf.Bytes()), in)
+	}
+}
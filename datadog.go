@@ -0,0 +1,49 @@
+package logf
+
+import (
+	"context"
+	"strconv"
+)
+
+const (
+	// Keys of the trace and span IDs that Datadog correlates lines
+	// with traces by.
+	datadogTraceIDKey = "dd.trace_id"
+	datadogSpanIDKey  = "dd.span_id"
+)
+
+// spanFromContext returns the IDs of the Datadog trace and span in ctx.
+// Without dd-trace-go there are none. It is set by SetDatadogSpanFunc.
+var spanFromContext = func(context.Context) (uint64, uint64, bool) {
+	return 0, 0, false
+}
+
+// SetDatadogSpanFunc sets the func that WithDatadogTrace looks up the IDs
+// of the Datadog trace and span in a context with. It is set by importing
+// github.com/zerodha/logf/ddtracelogf, which looks them up with dd-trace-go,
+// so that logf doesn't depend on it, eg:
+//
+//	import _ "github.com/zerodha/logf/ddtracelogf"
+//
+// It isn't safe to call concurrently with WithDatadogTrace.
+func SetDatadogSpanFunc(fn func(ctx context.Context) (traceID, spanID uint64, ok bool)) {
+	spanFromContext = fn
+}
+
+// WithDatadogTrace returns a copy of the logger with the IDs of the
+// Datadog trace and span in ctx added to its default fields as dd.trace_id
+// and dd.span_id, eg:
+//
+//	l.WithDatadogTrace(r.Context()).Info("request")
+//
+// The span is looked up with the func set by SetDatadogSpanFunc. Without
+// one, or if ctx has no span, the logger is returned as is.
+func (l Logger) WithDatadogTrace(ctx context.Context) Logger {
+	traceID, spanID, ok := spanFromContext(ctx)
+	if !ok {
+		return l
+	}
+
+	return l.With(datadogTraceIDKey, strconv.FormatUint(traceID, 10),
+		datadogSpanIDKey, strconv.FormatUint(spanID, 10))
+}
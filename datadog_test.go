@@ -0,0 +1,53 @@
+package logf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatadogFormat(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, Format: DatadogFormat, EnableCaller: true, EnableCallerFunc: true})
+
+	l.Error("hello", "user", 1, "status", "dup")
+
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &m))
+	require.Contains(t, m, "@timestamp")
+	require.Equal(t, "error", m["status"])
+	require.Equal(t, "hello", m["message"])
+	require.Contains(t, m["logger.file_name"], "datadog_test.go")
+	require.IsType(t, float64(0), m["logger.line"])
+	require.Equal(t, "logf.TestDatadogFormat", m["logger.method_name"])
+	require.Equal(t, float64(1), m["user"])
+	require.Equal(t, "dup", m["field_status"])
+	buf.Reset()
+
+	exit = func() {}
+	l = New(Opts{Writer: buf, Format: DatadogFormat})
+	l.Warn("a")
+	l.Fatal("b")
+	require.Regexp(t, `^\{"@timestamp":"[^"]+","status":"warning","message":"a"\}\n`+
+		`\{"@timestamp":"[^"]+","status":"critical","message":"b"\}\n$`, buf.String())
+}
+
+func TestLogWithDatadogTrace(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, Format: DatadogFormat, DefaultFields: []interface{}{"app", "api"}})
+
+	// Without a span, the logger is returned as is.
+	require.Equal(t, l.DefaultFields, l.WithDatadogTrace(context.Background()).DefaultFields)
+
+	defer SetDatadogSpanFunc(spanFromContext)
+	SetDatadogSpanFunc(func(context.Context) (uint64, uint64, bool) {
+		return 1<<63 + 1, 42, true
+	})
+
+	l.WithDatadogTrace(context.Background()).Info("hello")
+	require.Contains(t, buf.String(), `"app":"api","dd.trace_id":"9223372036854775809","dd.span_id":"42"}`)
+	require.Equal(t, []interface{}{"app", "api"}, l.DefaultFields)
+}
@@ -0,0 +1,32 @@
+// Package ddtracelogf looks up the Datadog trace and span that
+// logf.Logger.WithDatadogTrace adds to lines with dd-trace-go. Importing
+// it sets the lookup, eg:
+//
+//	import _ "github.com/zerodha/logf/ddtracelogf"
+//
+// It is a module of its own so that logf doesn't depend on dd-trace-go.
+package ddtracelogf
+
+import (
+	"context"
+
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/tracer"
+	"github.com/zerodha/logf"
+)
+
+func init() {
+	logf.SetDatadogSpanFunc(spanFromContext)
+}
+
+// spanFromContext returns the IDs of the trace and the span in ctx, if
+// any. The trace ID is its lower 64 bits, which Datadog correlates lines
+// by for 128-bit trace IDs.
+func spanFromContext(ctx context.Context) (uint64, uint64, bool) {
+	span, ok := tracer.SpanFromContext(ctx)
+	if !ok {
+		return 0, 0, false
+	}
+
+	sc := span.Context()
+	return sc.TraceIDLower(), sc.SpanID(), true
+}
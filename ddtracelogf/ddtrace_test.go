@@ -0,0 +1,34 @@
+package ddtracelogf
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/mocktracer"
+	"github.com/DataDog/dd-trace-go/v2/ddtrace/tracer"
+	"github.com/stretchr/testify/require"
+	"github.com/zerodha/logf"
+)
+
+func TestWithDatadogTrace(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	buf := &bytes.Buffer{}
+	l := logf.New(logf.Opts{Writer: buf, Format: logf.DatadogFormat})
+
+	// Without a span, there are no IDs.
+	l.WithDatadogTrace(context.Background()).Info("no span")
+	require.NotContains(t, buf.String(), "dd.trace_id")
+	buf.Reset()
+
+	span, ctx := tracer.StartSpanFromContext(context.Background(), "op")
+	defer span.Finish()
+
+	l.WithDatadogTrace(ctx).Info("hello")
+	sc := span.Context()
+	require.Contains(t, buf.String(), `"dd.trace_id":"`+strconv.FormatUint(sc.TraceIDLower(), 10)+`"`)
+	require.Contains(t, buf.String(), `"dd.span_id":"`+strconv.FormatUint(sc.SpanID(), 10)+`"`)
+}
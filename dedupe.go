@@ -0,0 +1,104 @@
+package logf
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxDedupeEntries is the number of distinct lines that are tracked for
+// deduplication at a time. Lines beyond it are logged as is.
+const maxDedupeEntries = 1024
+
+// deduper suppresses repeated lines of the same level and message within
+// a window.
+type deduper struct {
+	window time.Duration
+
+	// now returns the current time. It is replaced in tests.
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[dedupeKey]*dedupeEntry
+	lastSweep time.Time
+}
+
+type dedupeKey struct {
+	lvl Level
+	msg string
+}
+
+// dedupeEntry is the window of a line, which starts when it is logged.
+type dedupeEntry struct {
+	start time.Time
+	count int
+}
+
+// repeatedLine is a line that was suppressed count times in a window
+// that has expired.
+type repeatedLine struct {
+	lvl   Level
+	msg   string
+	count int
+}
+
+// message returns the message that the repeats are logged with.
+func (r repeatedLine) message() string {
+	return r.msg + " (repeated " + strconv.Itoa(r.count) + " times)"
+}
+
+// newDeduper returns a deduper for Opts.DedupeWindow, or nil if it isn't set.
+func newDeduper(window time.Duration) *deduper {
+	if window <= 0 {
+		return nil
+	}
+
+	return &deduper{
+		window:  window,
+		now:     time.Now,
+		entries: make(map[dedupeKey]*dedupeEntry),
+	}
+}
+
+// check returns false if a line is a repeat within its window and is to be
+// suppressed, along with the lines whose windows have expired with repeats
+// that have to be logged.
+func (d *deduper) check(lvl Level, msg string) ([]repeatedLine, bool) {
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var out []repeatedLine
+
+	// Expired windows are swept at most once a window.
+	if now.Sub(d.lastSweep) >= d.window {
+		for k, e := range d.entries {
+			if now.Sub(e.start) < d.window {
+				continue
+			}
+			if e.count > 0 {
+				out = append(out, repeatedLine{lvl: k.lvl, msg: k.msg, count: e.count})
+			}
+			delete(d.entries, k)
+		}
+		d.lastSweep = now
+	}
+
+	k := dedupeKey{lvl: lvl, msg: msg}
+	e, ok := d.entries[k]
+	switch {
+	case ok && now.Sub(e.start) < d.window:
+		e.count++
+		return out, false
+	case ok:
+		if e.count > 0 {
+			out = append(out, repeatedLine{lvl: lvl, msg: msg, count: e.count})
+		}
+		e.start, e.count = now, 0
+	case len(d.entries) < maxDedupeEntries:
+		d.entries[k] = &dedupeEntry{start: now}
+	}
+
+	return out, true
+}
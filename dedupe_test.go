@@ -0,0 +1,59 @@
+package logf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupe(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, DedupeWindow: time.Second})
+
+	now := time.Date(2022, 7, 7, 12, 0, 0, 0, time.UTC)
+	l.deduper.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		l.Error("db down")
+		l.Info("db down")
+	}
+	l.With("k", "v").Error("db down", "attempt", 6)
+	require.Equal(t, 1, strings.Count(buf.String(), "level=error"))
+	require.Equal(t, 1, strings.Count(buf.String(), "level=info"))
+	buf.Reset()
+
+	// Other lines are logged and the repeats are only logged once
+	// the window has expired.
+	now = now.Add(500 * time.Millisecond)
+	l.Info("other")
+	require.Contains(t, buf.String(), "message=other")
+	require.NotContains(t, buf.String(), "repeated")
+	buf.Reset()
+
+	now = now.Add(time.Second)
+	l.Warn("another")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	require.Contains(t, buf.String(), `level=error message="db down (repeated 5 times)"`)
+	require.Contains(t, buf.String(), `level=info message="db down (repeated 4 times)"`)
+	require.Contains(t, lines[2], "message=another")
+	buf.Reset()
+
+	// A line logged again after its window starts a new one.
+	l.Error("db down")
+	l.Error("db down")
+	require.Equal(t, 1, strings.Count(buf.String(), "db down"))
+	buf.Reset()
+
+	now = now.Add(2 * time.Second)
+	l.Error("db down")
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], `message="db down (repeated 1 times)"`)
+	require.Contains(t, lines[1], `message="db down"`)
+
+	require.Nil(t, New(Opts{}).deduper)
+}
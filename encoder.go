@@ -0,0 +1,96 @@
+package logf
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Appender is the buffer that a TypeEncoder writes a value into.
+type Appender interface {
+	AppendByte(b byte)
+	AppendString(s string)
+	AppendInt(i int64)
+	AppendUint(i uint64)
+	AppendFloat(f float64, bitSize int)
+	AppendBool(v bool)
+	AppendHex(p []byte)
+}
+
+// TypeEncoder writes the text form of a value of the type that it is
+// registered for into buf.
+type TypeEncoder func(buf Appender, v interface{})
+
+var (
+	// typeEncoders is the map[reflect.Type]TypeEncoder of registered
+	// encoders. It is replaced, never modified, on registration so that
+	// lookups don't need a lock.
+	typeEncoders atomic.Value
+
+	// typeEncodersMu serializes registrations.
+	typeEncodersMu sync.Mutex
+)
+
+// RegisterTypeEncoder registers fn to write field values of the concrete
+// type of v, eg: a UUID or a decimal, instead of them being written with
+// their String method or fmt. The text written by fn is written like a
+// string value in every format, eg: quoted if need be in logfmt. Values of
+// built-in types such as strings, numbers and time.Time always use the
+// built-in encoders. It is safe for concurrent use, but encoders should be
+// registered before logging starts so that every line is written the same.
+// A nil fn unregisters the encoder of the type. If fn panics, the value
+// is written as !PANIC: <recovered value>.
+func RegisterTypeEncoder(v interface{}, fn TypeEncoder) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return
+	}
+
+	typeEncodersMu.Lock()
+	defer typeEncodersMu.Unlock()
+
+	old, _ := typeEncoders.Load().(map[reflect.Type]TypeEncoder)
+	m := make(map[reflect.Type]TypeEncoder, len(old)+1)
+	for k, e := range old {
+		m[k] = e
+	}
+	if fn == nil {
+		delete(m, t)
+	} else {
+		m[t] = fn
+	}
+	typeEncoders.Store(m)
+}
+
+// typeEncoded returns a buffer from bufPool with val written into it by
+// its registered TypeEncoder, or false if there is none. The buffer has
+// to be put back into the pool.
+func typeEncoded(val interface{}) (*byteBuffer, bool) {
+	m, _ := typeEncoders.Load().(map[reflect.Type]TypeEncoder)
+	if len(m) == 0 {
+		return nil, false
+	}
+
+	fn, ok := m[reflect.TypeOf(val)]
+	if !ok {
+		return nil, false
+	}
+
+	tmp := bufPool.Get()
+	encodeType(fn, tmp, val)
+	return tmp, true
+}
+
+// encodeType calls fn to write val into buf. If fn panics, what it wrote
+// is replaced with !PANIC: <recovered value>.
+func encodeType(fn TypeEncoder, buf *byteBuffer, val interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf.Reset()
+			buf.AppendString(fmt.Sprintf("!PANIC: %v", r))
+		}
+	}()
+
+	fn(buf, val)
+}
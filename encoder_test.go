@@ -0,0 +1,80 @@
+package logf
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type encMoney struct {
+	units int64
+	cents uint8
+}
+
+type encEnum int
+
+func (e encEnum) String() string {
+	return "enum"
+}
+
+func TestRegisterTypeEncoder(t *testing.T) {
+	RegisterTypeEncoder(encMoney{}, func(buf Appender, v interface{}) {
+		m := v.(encMoney)
+		buf.AppendInt(m.units)
+		buf.AppendByte('.')
+		if m.cents < 10 {
+			buf.AppendByte('0')
+		}
+		buf.AppendUint(uint64(m.cents))
+	})
+	RegisterTypeEncoder(encEnum(0), func(buf Appender, v interface{}) {
+		buf.AppendString("enum ")
+		buf.AppendInt(int64(v.(encEnum)))
+	})
+	defer func() {
+		RegisterTypeEncoder(encMoney{}, nil)
+		RegisterTypeEncoder(encEnum(0), nil)
+	}()
+
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+
+	l.Info("hello world", "price", encMoney{12, 5}, "kind", encEnum(2), "m", map[string]interface{}{"price": encMoney{1, 50}})
+	require.Contains(t, buf.String(), `price=12.05 kind="enum 2" m="{\"price\":\"1.50\"}"`)
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Opts.CSVColumns = []string{"price"}
+	l.Info("hello world", "price", encMoney{12, 5}, "kind", encEnum(2))
+	require.Contains(t, buf.String(), `,12.05,"{""kind"":""enum 2""}"`)
+	buf.Reset()
+
+	l.Opts.Format = MsgpackFormat
+	l.Info("hello world", "price", encMoney{12, 5}, "kind", encEnum(2))
+	frames := decodeMsgpackFrames(t, buf.Bytes())
+	require.Equal(t, "12.05", frames[0]["price"])
+	require.Equal(t, "enum 2", frames[0]["kind"])
+	buf.Reset()
+
+	// Unregistered types are written as before.
+	RegisterTypeEncoder(encEnum(0), nil)
+	l.Opts.Format = LogfmtFormat
+	l.Info("hello world", "kind", encEnum(2))
+	require.Contains(t, buf.String(), `kind=enum`+"\n")
+}
+
+type encPanic struct{}
+
+func TestRegisterTypeEncoderPanic(t *testing.T) {
+	RegisterTypeEncoder(encPanic{}, func(buf Appender, v interface{}) {
+		buf.AppendString("partial")
+		panic("boom")
+	})
+	defer RegisterTypeEncoder(encPanic{}, nil)
+
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+	l.Info("hello world", "v", encPanic{}, "n", 1)
+	require.Contains(t, buf.String(), `v="!PANIC: boom" n=1`+"\n")
+}
@@ -6,7 +6,24 @@ import (
 	"github.com/zerodha/logf"
 )
 
+// UUID stands in for a UUID type such as github.com/google/uuid.UUID.
+type UUID [16]byte
+
 func main() {
+	// Write UUIDs in their canonical form without going through fmt.
+	logf.RegisterTypeEncoder(UUID{}, func(buf logf.Appender, v interface{}) {
+		u := v.(UUID)
+		buf.AppendHex(u[0:4])
+		buf.AppendByte('-')
+		buf.AppendHex(u[4:6])
+		buf.AppendByte('-')
+		buf.AppendHex(u[6:8])
+		buf.AppendByte('-')
+		buf.AppendHex(u[8:10])
+		buf.AppendByte('-')
+		buf.AppendHex(u[10:])
+	})
+
 	logger := logf.New(logf.Opts{
 		EnableColor:          true,
 		Level:                logf.DebugLevel,
@@ -23,6 +40,9 @@ func main() {
 	// Add extra keys to the log.
 	logger.Info("logging with some extra metadata", "component", "api", "user", "karan")
 
+	// Log a value with a registered encoder.
+	logger.Info("request received", "request_id", UUID{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00})
+
 	// Log with error key.
 	logger.Error("error fetching details", "error", "this is a dummy error")
 
@@ -0,0 +1,787 @@
+package logf
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fieldType is the type of the value of a Field.
+type fieldType uint8
+
+const (
+	anyType fieldType = iota
+	stringType
+	intType
+	floatType
+	boolType
+	errType
+	namespaceType
+)
+
+// Field is a typed key/value pair for DebugA, InfoA etc. Unlike the
+// ...interface{} fields, the values of String, Int, Float64 and Bool
+// fields are written without being boxed in an interface.
+type Field struct {
+	Key string
+
+	typ fieldType
+	str string
+	num int64
+	val interface{}
+}
+
+// String returns a Field with a string value.
+func String(key, val string) Field {
+	return Field{Key: key, typ: stringType, str: val}
+}
+
+// Int returns a Field with an int value.
+func Int(key string, val int) Field {
+	return Field{Key: key, typ: intType, num: int64(val)}
+}
+
+// Float64 returns a Field with a float64 value.
+func Float64(key string, val float64) Field {
+	return Field{Key: key, typ: floatType, num: int64(math.Float64bits(val))}
+}
+
+// Bool returns a Field with a bool value.
+func Bool(key string, val bool) Field {
+	f := Field{Key: key, typ: boolType}
+	if val {
+		f.num = 1
+	}
+	return f
+}
+
+// Err returns a Field that writes err with the key "error", followed by
+// its concrete type, eg: error_type=*net.OpError. If err is nil, nothing
+// is written, so it can be logged unconditionally, eg:
+//
+//	l.Error("request failed", logf.Err(err))
+//
+// Like any Field, it can be mixed with key/value pairs.
+func Err(err error) Field {
+	return Field{Key: "error", typ: errType, val: err}
+}
+
+// Any returns a Field with a value of any type, written like
+// the ...interface{} fields.
+func Any(key string, val interface{}) Field {
+	return Field{Key: key, typ: anyType, val: val}
+}
+
+// Namespace returns a Field that prefixes the keys of the fields that
+// follow it with name and a dot, eg:
+//
+//	l.Info("request", logf.Namespace("http"), "method", "GET", "status", 200)
+//
+// logs http.method=GET http.status=200. The namespace lasts until the next
+// Namespace or the end of the fields. A Namespace in DefaultFields (or
+// With) lasts into the fields of every line. An empty name ends the
+// namespace.
+func Namespace(name string) Field {
+	f := Field{Key: name, typ: namespaceType}
+	if name != "" {
+		f.str = name + "."
+	}
+	return f
+}
+
+// Map returns the entries of m as key/value pairs sorted by key, so that
+// the same map always produces the same output, eg:
+//
+//	l.Info("request", logf.Map(extra)...)
+//
+// A nil or empty map returns no pairs.
+func Map(m map[string]interface{}) []interface{} {
+	if len(m) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]interface{}, 0, 2*len(keys))
+	for _, k := range keys {
+		out = append(out, k, m[k])
+	}
+
+	return out
+}
+
+// Fields is Map, for callers that build the fields of a line as a map.
+// The pairs are a copy, so m can be modified once Fields returns.
+func Fields(m map[string]interface{}) []interface{} {
+	return Map(m)
+}
+
+// maxFlattenFields is the number of pairs that Flatten returns at most.
+const maxFlattenFields = 100
+
+// Flatten returns the entries of m, and of the maps nested in it, as
+// key/value pairs with their keys joined with dots and prefixed with
+// key, eg:
+//
+//	l.Info("config loaded", logf.Flatten("cfg", cfg)...)
+//
+// logs cfg.db.host=x cfg.db.port=5432 for {"db": {"host": "x", "port": 5432}}.
+// Nested maps are walked depth-first, with the keys of every map sorted,
+// as with Map. Maps nested deeper than 5 levels are written as values.
+// Past 100 pairs, the rest are dropped and their number is written as
+// _truncated_fields. Keys are joined as is, without escaping their dots, so
+// {"a.b": 1} and {"a": {"b": 1}} both log a.b=1. If key is empty, the
+// keys of m aren't prefixed.
+func Flatten(key string, m map[string]interface{}) []interface{} {
+	if len(m) == 0 {
+		return nil
+	}
+
+	out, dropped := flattenMap(make([]interface{}, 0, 2*len(m)), key, m, 0, 0)
+	if dropped > 0 {
+		out = append(out, truncatedFieldsKey, dropped)
+	}
+
+	return out
+}
+
+// flattenMap appends the entries of m nested depth levels deep to out as
+// pairs, with their keys prefixed with prefix, and returns out and the
+// number of pairs dropped so far.
+func flattenMap(out []interface{}, prefix string, m map[string]interface{}, depth, dropped int) ([]interface{}, int) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := m[k]
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+
+		if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 && depth+1 < maxNestingDepth {
+			out, dropped = flattenMap(out, k, nested, depth+1, dropped)
+			continue
+		}
+
+		if len(out) == 2*maxFlattenFields {
+			dropped++
+			continue
+		}
+		out = append(out, k, v)
+	}
+
+	return out, dropped
+}
+
+// expandMaps returns fields with the map[string]interface{} values in
+// key positions expanded into key/value pairs with Map. fields is returned
+// as is if there are none.
+func expandMaps(fields []interface{}) []interface{} {
+	var out []interface{}
+	for i := 0; i < len(fields); {
+		n := 2
+		if _, ok := fields[i].(Field); ok || i+1 == len(fields) {
+			n = 1
+		}
+
+		if m, ok := fields[i].(map[string]interface{}); ok {
+			if out == nil {
+				out = make([]interface{}, i, len(fields)+2*len(m))
+				copy(out, fields[:i])
+			}
+			out = append(out, Map(m)...)
+			i++
+			continue
+		}
+
+		if out != nil {
+			out = append(out, fields[i:i+n]...)
+		}
+		i += n
+	}
+
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+// value returns the value of the field as an interface{}.
+func (f Field) value() interface{} {
+	switch f.typ {
+	case stringType:
+		return f.str
+	case intType:
+		return int(f.num)
+	case floatType:
+		return math.Float64frombits(uint64(f.num))
+	case boolType:
+		return f.num == 1
+	default:
+		return f.val
+	}
+}
+
+// errorType returns the name of the concrete type of an error.
+func errorType(err interface{}) string {
+	return reflect.TypeOf(err).String()
+}
+
+// expandFields returns the key/value pairs in fields, with the Field
+// values in it and the typed fields expanded into key/value pairs and
+// the keys prefixed with their Namespace. ns is the prefix of the
+// namespace open before the fields and the prefix of the one open after
+// them is returned. A trailing value without a key is written with the
+// key !BADKEY. fields is returned as is if there is nothing to expand.
+func expandFields(fields []interface{}, typed []Field, ns string) ([]interface{}, string) {
+	expand := len(typed) > 0 || ns != ""
+	for _, f := range fields {
+		if _, ok := f.(Field); ok {
+			expand = true
+			break
+		}
+	}
+	if !expand {
+		return fixDanglingKey(fields), ns
+	}
+
+	out := make([]interface{}, 0, len(fields)+4*len(typed))
+	for i := 0; i < len(fields); {
+		if f, ok := fields[i].(Field); ok {
+			if f.typ == namespaceType {
+				ns = f.str
+			} else {
+				out = f.appendPairs(out, ns)
+			}
+			i++
+			continue
+		}
+		if i+1 == len(fields) {
+			out = append(out, badKey, fields[i])
+			break
+		}
+
+		key := fields[i]
+		if ns != "" {
+			key = ns + fieldKey(key)
+		}
+		out = append(out, key, fields[i+1])
+		i += 2
+	}
+	for _, f := range typed {
+		if f.typ == namespaceType {
+			ns = f.str
+			continue
+		}
+		out = f.appendPairs(out, ns)
+	}
+
+	return out, ns
+}
+
+// lastNamespace returns the namespace open at the end of fields, if any.
+func lastNamespace(fields []interface{}) string {
+	var ns string
+	for i := 0; i < len(fields); {
+		if f, ok := fields[i].(Field); ok {
+			if f.typ == namespaceType {
+				ns = f.str
+			}
+			i++
+			continue
+		}
+		i += 2
+	}
+
+	return ns
+}
+
+// badKey replaces nil keys and is the key of a trailing value without one.
+const badKey = "!BADKEY"
+
+// truncatedFieldsKey is the key of the number of fields dropped by MaxFields.
+const truncatedFieldsKey = "_truncated_fields"
+
+// fieldKey returns the key of a key/value pair. Keys should be strings,
+// but other values are converted instead of panicking: errors,
+// fmt.Stringers and encoding.TextMarshalers to their text, numbers with
+// strconv and anything else with fmt. nil keys are written as !BADKEY.
+func fieldKey(k interface{}) string {
+	switch v := k.(type) {
+	case string:
+		return v
+	case nil:
+		return badKey
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case error, fmt.Stringer, encoding.TextMarshaler:
+		if isNilPointer(v) {
+			return badKey
+		}
+		return textValue(v)
+	default:
+		return fmt.Sprint(k)
+	}
+}
+
+// hasDanglingKey returns true if the last key in fields has no value,
+// accounting for Field values mixed with key/value pairs.
+func hasDanglingKey(fields []interface{}) bool {
+	i := 0
+	for i < len(fields) {
+		if _, ok := fields[i].(Field); ok {
+			i++
+		} else {
+			i += 2
+		}
+	}
+
+	return i > len(fields)
+}
+
+// validateFields returns an error describing every problem with fields:
+// values without a key, keys that aren't strings or fmt.Stringers and
+// keys that are written by the logger itself, reserved.
+func validateFields(fields []interface{}, reserved []string) error {
+	var errs []string
+	for i := 0; i < len(fields); {
+		var key string
+		switch k := fields[i].(type) {
+		case Field:
+			if k.typ == namespaceType {
+				i++
+				continue
+			}
+			key = k.Key
+		case string:
+			key = k
+		case fmt.Stringer:
+			key = fieldKey(k)
+		default:
+			errs = append(errs, fmt.Sprintf("key %v at %d is not a string", k, i))
+		}
+
+		if containsString(reserved, key) {
+			errs = append(errs, fmt.Sprintf("key %q is reserved", key))
+		}
+
+		// Typed fields have no separate value.
+		if _, ok := fields[i].(Field); ok {
+			i++
+			continue
+		}
+		if i+1 == len(fields) {
+			errs = append(errs, fmt.Sprintf("%v at %d has no key", fields[i], i))
+		}
+		i += 2
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid fields: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// fixDanglingKey returns fields with !BADKEY inserted before the last
+// element if it has no value, so that it is written as !BADKEY=<value>
+// instead of being dropped. fields is returned as is otherwise.
+func fixDanglingKey(fields []interface{}) []interface{} {
+	if !hasDanglingKey(fields) {
+		return fields
+	}
+
+	out := make([]interface{}, 0, len(fields)+1)
+	out = append(out, fields[:len(fields)-1]...)
+	return append(out, badKey, fields[len(fields)-1])
+}
+
+// builtinPrefix is prepended to the keys of fields that collide with the
+// keys written by the logger itself with DuplicateBuiltinPrefix.
+const builtinPrefix = "field_"
+
+// builtinKey returns the key that a field with the given key is written
+// with as per the policy if it is one of the keys written by the logger
+// itself, reserved, or false if the field is to be dropped.
+func builtinKey(key string, policy DuplicateBuiltinPolicy, reserved []string) (string, bool) {
+	if policy == DuplicateBuiltinAllow || !containsString(reserved, key) {
+		return key, true
+	}
+	if policy == DuplicateBuiltinDrop {
+		return "", false
+	}
+
+	return builtinPrefix + key, true
+}
+
+// fixBuiltinKeys returns fields with the fields whose keys collide with
+// the keys written by the logger itself renamed or dropped as per the
+// policy. Fields in a Namespace don't collide. fields, which must have no
+// dangling key, is returned as is if there are none.
+func fixBuiltinKeys(fields []interface{}, policy DuplicateBuiltinPolicy, reserved []string) []interface{} {
+	var (
+		out []interface{}
+		ns  string
+	)
+	for i := 0; i < len(fields); {
+		f, typed := fields[i].(Field)
+		n, key := 2, f.Key
+		if typed {
+			n = 1
+		} else {
+			key = fieldKey(fields[i])
+		}
+		if typed && f.typ == namespaceType {
+			ns = f.str
+		}
+
+		k, ok := key, true
+		if ns == "" && f.typ != namespaceType {
+			k, ok = builtinKey(key, policy, reserved)
+		}
+		if k == key {
+			if out != nil {
+				out = append(out, fields[i:i+n]...)
+			}
+			i += n
+			continue
+		}
+
+		// Copy the fields up to the first one that changes.
+		if out == nil {
+			out = append(make([]interface{}, 0, len(fields)), fields[:i]...)
+		}
+		switch {
+		case !ok:
+		case typed:
+			f.Key = k
+			out = append(out, f)
+		default:
+			out = append(out, k, fields[i+1])
+		}
+		i += n
+	}
+
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+// pendingFields collects the fields of a line to be sorted or deduplicated
+// before they are written. It is pooled so that lines don't allocate it.
+type pendingFields struct {
+	f []Field
+}
+
+var pendingFieldsPool = sync.Pool{
+	New: func() interface{} {
+		return &pendingFields{}
+	},
+}
+
+func (s *pendingFields) Len() int           { return len(s.f) }
+func (s *pendingFields) Less(i, j int) bool { return s.f[i].Key < s.f[j].Key }
+func (s *pendingFields) Swap(i, j int)      { s.f[i], s.f[j] = s.f[j], s.f[i] }
+
+// hasKeyAfter returns true if a field after the i-th one has its key.
+// Lines have few fields, so a scan is cheaper than a map.
+func (s *pendingFields) hasKeyAfter(i int) bool {
+	for _, f := range s.f[i+1:] {
+		if f.Key == s.f[i].Key {
+			return true
+		}
+	}
+	return false
+}
+
+// release clears the fields, so that their values can be garbage
+// collected, and puts s back in the pool.
+func (s *pendingFields) release() {
+	for i := range s.f {
+		s.f[i] = Field{}
+	}
+	s.f = s.f[:0]
+	pendingFieldsPool.Put(s)
+}
+
+// encodedFields are the default fields of a logger encoded in logfmt once,
+// when they are set, so that lines only have to append them. Pairs with
+// a value that can change between lines, eg: a Lazy value, are written
+// by every line in between the encoded ones.
+type encodedFields struct {
+	// first and n are the first element and the length of the fields that
+	// were encoded, so that the encoding isn't used for fields that have
+	// been replaced since. Fields modified in place aren't detected, which
+	// would take comparing every field on every line.
+	first *interface{}
+	n     int
+
+	// parts are the runs of encoded pairs and the pairs written by every
+	// line, in order.
+	parts []encodedPart
+	color bool
+}
+
+// encodedPart is a run of pairs encoded without colors, or for every level
+// if color is set, or a pair that is written by every line.
+type encodedPart struct {
+	b     [FatalLevel + 1][]byte
+	count int
+
+	perLine bool
+	key     string
+	index   int
+}
+
+// encodeFields encodes default fields of the logger, or returns nil if
+// they can't be encoded ahead of the line. That is the case for formats
+// other than logfmt, with the options that reorder or drop fields across
+// the line, with Opts.Redact, and for fields other than key/value pairs
+// with a string key.
+func (l *Logger) encodeFields(fields []interface{}) *encodedFields {
+	if len(fields) == 0 || len(fields)%2 != 0 || l.Opts.Format != LogfmtFormat ||
+		l.Opts.SortFields || l.Opts.DedupeKeys || l.Opts.MaxFields > 0 || l.Opts.Redact != nil {
+		return nil
+	}
+	for i := 0; i < len(fields); i += 2 {
+		if _, ok := fields[i].(string); !ok {
+			return nil
+		}
+	}
+
+	e := &encodedFields{first: &fields[0], n: len(fields), color: l.Opts.EnableColor}
+
+	for i := 0; i < len(fields); {
+		j := i
+		for j < len(fields) && isStaticValue(fields[j+1]) {
+			j += 2
+		}
+		if j > i {
+			e.parts = append(e.parts, l.encodePairs(fields[i:j]))
+			i = j
+			continue
+		}
+
+		e.parts = append(e.parts, encodedPart{perLine: true, key: fields[i].(string), index: i})
+		i += 2
+	}
+
+	return e
+}
+
+// encodePairs encodes a run of key/value pairs.
+func (l *Logger) encodePairs(pairs []interface{}) encodedPart {
+	var (
+		p   encodedPart
+		buf byteBuffer
+	)
+	if !l.Opts.EnableColor {
+		p.count, _ = l.writeFieldList(&buf, InfoLevel, pairs, "", DuplicateBuiltinAllow, nil)
+		p.b[0] = buf.B
+		return p
+	}
+
+	for lvl := DebugLevel; lvl <= FatalLevel; lvl++ {
+		buf.B = nil
+		p.count, _ = l.writeFieldList(&buf, lvl, pairs, "", DuplicateBuiltinAllow, nil)
+		p.b[lvl] = buf.B
+	}
+
+	return p
+}
+
+// isStaticValue returns true if the value is written the same way by
+// every line. Values such as Lazy values, errors and Stringers aren't,
+// as they can change between lines.
+func isStaticValue(val interface{}) bool {
+	switch val.(type) {
+	case nil, string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64,
+		float32, float64, time.Time, time.Duration:
+		return true
+	}
+	return false
+}
+
+// encoded returns e, the encoding of fields, or nil if they aren't encoded
+// or have been replaced since.
+func (l *Logger) encoded(e *encodedFields, fields []interface{}) *encodedFields {
+	if e == nil || l.Opts.Format != LogfmtFormat || len(fields) != e.n || &fields[0] != e.first ||
+		l.Opts.EnableColor != e.color {
+		return nil
+	}
+
+	return e
+}
+
+// staticFields returns the encoding of fields for the level if none of
+// them are written by every line. e is the encoding of fields, if any.
+func (l *Logger) staticFields(fields []interface{}, e *encodedFields, lvl Level) ([]byte, bool) {
+	if len(fields) == 0 {
+		return nil, true
+	}
+	if e = l.encoded(e, fields); e == nil {
+		return nil, false
+	}
+	return e.static(lvl)
+}
+
+// bytes returns the encoded pairs for the level.
+func (e *encodedFields) bytes(p *encodedPart, lvl Level) []byte {
+	if e.color {
+		return p.b[lvl]
+	}
+	return p.b[0]
+}
+
+// static returns the encoded pairs for the level if none are written
+// by every line.
+func (e *encodedFields) static(lvl Level) ([]byte, bool) {
+	if len(e.parts) != 1 || e.parts[0].perLine {
+		return nil, false
+	}
+	return e.bytes(&e.parts[0], lvl), true
+}
+
+// appendPairs appends the key/value pairs written for the field to out,
+// with their keys prefixed with ns.
+func (f Field) appendPairs(out []interface{}, ns string) []interface{} {
+	key := ns + f.Key
+	if f.typ != errType {
+		return append(out, key, f.value())
+	}
+	if f.val == nil {
+		return out
+	}
+
+	return append(out, key, f.val, key+"_type", errorType(f.val))
+}
+
+// DebugA emits a debug log line with typed fields.
+func (l Logger) DebugA(msg string, fields ...Field) {
+	l.handleLog(msg, DebugLevel, nil, fields)
+}
+
+// InfoA emits a info log line with typed fields.
+func (l Logger) InfoA(msg string, fields ...Field) {
+	l.handleLog(msg, InfoLevel, nil, fields)
+}
+
+// WarnA emits a warning log line with typed fields.
+func (l Logger) WarnA(msg string, fields ...Field) {
+	l.handleLog(msg, WarnLevel, nil, fields)
+}
+
+// ErrorA emits an error log line with typed fields.
+func (l Logger) ErrorA(msg string, fields ...Field) {
+	l.handleLog(msg, ErrorLevel, nil, fields)
+}
+
+// FatalA emits a fatal level log line with typed fields.
+// It aborts the current program with an exit code of 1.
+func (l Logger) FatalA(msg string, fields ...Field) {
+	l.handleLog(msg, FatalLevel, nil, fields)
+	l.Flush()
+	exit()
+}
+
+// writeTypedField writes a Field in the configured format and returns the
+// number of pairs written. The value is only boxed in an interface if it
+// is of any type or may have to be redacted.
+func (l *Logger) writeTypedField(buf *byteBuffer, f Field, lvl Level) int {
+	if f.typ == errType {
+		if f.val == nil {
+			return 0
+		}
+
+		n := l.writeField(buf, f.Key, f.val, lvl)
+		return n + l.writeField(buf, f.Key+"_type", errorType(f.val), lvl)
+	}
+
+	if f.typ == anyType || l.Opts.Redact != nil || len(l.Opts.RedactKeys) > 0 {
+		return l.writeField(buf, f.Key, f.value(), lvl)
+	}
+	if l.Opts.OmitEmpty && f.typ == stringType && f.str == "" {
+		return 0
+	}
+
+	if l.Opts.Format == MsgpackFormat {
+		writeMsgpackString(buf, f.Key)
+		switch f.typ {
+		case stringType:
+			writeMsgpackString(buf, f.str)
+		case intType:
+			writeMsgpackInt(buf, f.num)
+		case floatType:
+			buf.AppendByte(mpFloat64)
+			appendUint64(buf, uint64(f.num))
+		case boolType:
+			if f.num == 1 {
+				buf.AppendByte(mpTrue)
+			} else {
+				buf.AppendByte(mpFalse)
+			}
+		}
+		return 1
+	}
+
+	if l.Opts.Format.isJSON() {
+		gelf := l.Opts.Format == GELFFormat
+		if gelf {
+			writeGELFKey(buf, f.Key)
+		} else {
+			buf.AppendByte(',')
+			writeQuotedString(buf, f.Key)
+			buf.AppendByte(':')
+		}
+		switch f.typ {
+		case stringType:
+			writeQuotedString(buf, f.str)
+		case intType:
+			buf.AppendInt(f.num)
+		case floatType:
+			writeJSONFloat(buf, math.Float64frombits(uint64(f.num)), 64)
+		case boolType:
+			// GELF has no booleans.
+			if gelf {
+				buf.AppendByte('"')
+				buf.AppendBool(f.num == 1)
+				buf.AppendByte('"')
+			} else {
+				buf.AppendBool(f.num == 1)
+			}
+		}
+		return 1
+	}
+
+	l.writeKeyToBuf(buf, f.Key, lvl)
+	switch f.typ {
+	case stringType:
+		escapeAndWriteString(buf, truncateValue(f.str, l.Opts.MaxFieldValueLen))
+	case intType:
+		buf.AppendInt(f.num)
+	case floatType:
+		buf.AppendFloat(math.Float64frombits(uint64(f.num)), 64)
+	case boolType:
+		buf.AppendBool(f.num == 1)
+	}
+
+	return 1
+}
@@ -0,0 +1,300 @@
+package logf
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypedFields(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, DefaultFields: []interface{}{"app", "api"}})
+
+	l.InfoA("hello world",
+		String("str", "a b"),
+		Int("int", -10),
+		Float64("float", 1.5),
+		Bool("bool", true),
+		Bool("false", false),
+		Any("error", errors.New("fail")),
+		Any("any", []int{1, 2}),
+	)
+	typed := buf.String()
+	require.Contains(t, typed, `level=info message="hello world" app=api str="a b" int=-10 float=1.5 bool=true false=false error=fail any=[1,2]`+"\n")
+	buf.Reset()
+
+	// The output matches the ...interface{} fields.
+	l.Info("hello world", "str", "a b", "int", -10, "float", 1.5, "bool", true, "false", false,
+		"error", errors.New("fail"), "any", []int{1, 2})
+	require.Equal(t, typed[strings.Index(typed, " level="):], buf.String()[strings.Index(buf.String(), " level="):])
+	buf.Reset()
+
+	l.DebugA("hidden", String("a", "b"))
+	require.Empty(t, buf.String())
+
+	// Redact gets the values of typed fields.
+	l.Opts.Redact = func(key string, val interface{}) interface{} {
+		if key == "token" {
+			return Redacted
+		}
+		return val
+	}
+	l.WarnA("hello world", String("token", "secret"), Int("n", 1))
+	require.Contains(t, buf.String(), `level=warn message="hello world" app=api token=[REDACTED] n=1`+"\n")
+	buf.Reset()
+}
+
+func TestErrField(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+
+	_, err := strconv.Atoi("x")
+	l.Error("failed", Err(err), "id", 1)
+	require.Contains(t, buf.String(), `message=failed error="strconv.Atoi: parsing \"x\": invalid syntax" error_type=*strconv.NumError id=1`)
+	buf.Reset()
+
+	// A nil error writes nothing.
+	l.Error("failed", "id", 1, Err(nil), "user", "alice")
+	require.Contains(t, buf.String(), `message=failed id=1 user=alice`+"\n")
+	require.NotContains(t, buf.String(), "error=")
+	buf.Reset()
+
+	l.ErrorA("failed", Err(nil))
+	require.NotContains(t, buf.String(), "error=")
+	buf.Reset()
+
+	l.With(Err(errors.New("fail"))).Info("hello")
+	require.Contains(t, buf.String(), `error=fail error_type=*errors.errorString`)
+	buf.Reset()
+
+	// The field count in msgpack matches the expanded fields.
+	l = New(Opts{Writer: buf, Format: MsgpackFormat})
+	l.Error("failed", Err(err), Err(nil), "id", 1)
+	entries := decodeMsgpackFrames(t, buf.Bytes())
+	require.Equal(t, "*strconv.NumError", entries[0]["error_type"])
+	require.Equal(t, int64(1), entries[0]["id"])
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, Format: CSVFormat, CSVColumns: []string{"error_type"}})
+	l.Error("failed", Err(errors.New("fail")), "id", 1, Err(nil))
+	require.Contains(t, buf.String(), `,*errors.errorString,"{""error"":""fail"",""id"":1}"`)
+}
+
+func TestTypedFieldsFormats(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, Format: MsgpackFormat})
+
+	l.ErrorA("hello world", String("str", "a"), Int("int", math.MaxInt32), Float64("float", 1.5), Bool("bool", true), Any("nil", nil))
+	entries := decodeMsgpackFrames(t, buf.Bytes())
+	require.Len(t, entries, 1)
+	require.Equal(t, "a", entries[0]["str"])
+	require.Equal(t, int64(math.MaxInt32), entries[0]["int"])
+	require.Equal(t, 1.5, entries[0]["float"])
+	require.Equal(t, true, entries[0]["bool"])
+	require.Contains(t, entries[0], "nil")
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, Format: CSVFormat, CSVColumns: []string{"int"}})
+	l.InfoA("hello world", String("str", "a"), Int("int", 1))
+	require.Contains(t, buf.String(), `,,1,"{""str"":""a""}"`)
+}
+
+func TestMap(t *testing.T) {
+	require.Nil(t, Map(nil))
+	require.Nil(t, Map(map[string]interface{}{}))
+	require.Equal(t, []interface{}{"a", 1, "b", "two", "c", nil},
+		Map(map[string]interface{}{"c": nil, "a": 1, "b": "two"}))
+
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, DefaultFields: []interface{}{
+		"app", "logf", map[string]interface{}{"region": "in", "env": "prod"}, Int("pid", 1), map[string]interface{}{},
+	}})
+	require.Equal(t, []interface{}{"app", "logf", "env", "prod", "region", "in", Int("pid", 1)}, l.DefaultFields)
+
+	l.Info("hello world", Map(map[string]interface{}{"y": 2, "x": 1})...)
+	require.Contains(t, buf.String(), `app=logf env=prod region=in pid=1 x=1 y=2`)
+	buf.Reset()
+
+	l.Info("hello world", Map(nil)...)
+	require.Contains(t, buf.String(), `pid=1`+"\n")
+}
+
+func TestFields(t *testing.T) {
+	require.Nil(t, Fields(nil))
+	require.Nil(t, Fields(map[string]interface{}{}))
+	require.Equal(t, []interface{}{"a", 1}, Fields(map[string]interface{}{"a": 1}))
+
+	// The pairs don't reference the map, so it can be written to while
+	// they are logged.
+	m := map[string]interface{}{"a": 1, "b": 2}
+	fields := Fields(m)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			m["a"] = i
+			m[strconv.Itoa(i)] = i
+		}
+	}()
+
+	l := New(Opts{Writer: io.Discard})
+	for i := 0; i < 1000; i++ {
+		l.Info("hello world", fields...)
+	}
+	wg.Wait()
+	require.Equal(t, []interface{}{"a", 1, "b", 2}, fields)
+}
+
+func TestFlatten(t *testing.T) {
+	require.Nil(t, Flatten("cfg", nil))
+
+	m := map[string]interface{}{
+		"db": map[string]interface{}{
+			"host": "x",
+			"port": 5432,
+			"tls":  map[string]interface{}{"on": true},
+		},
+		"a.b":   1,
+		"empty": map[string]interface{}{},
+		"name":  "api",
+	}
+	require.Equal(t, []interface{}{
+		"cfg.a.b", 1,
+		"cfg.db.host", "x",
+		"cfg.db.port", 5432,
+		"cfg.db.tls.on", true,
+		"cfg.empty", map[string]interface{}{},
+		"cfg.name", "api",
+	}, Flatten("cfg", m))
+	require.Equal(t, "a.b", Flatten("", m)[0])
+
+	// Maps nested too deep are values.
+	deep := map[string]interface{}{"v": 1}
+	for i := 0; i < 6; i++ {
+		deep = map[string]interface{}{"n": deep}
+	}
+	require.Equal(t, []interface{}{"d.n.n.n.n.n", map[string]interface{}{"n": map[string]interface{}{"v": 1}}}, Flatten("d", deep))
+
+	// Pairs past the limit are counted.
+	big := make(map[string]interface{})
+	for i := 0; i < maxFlattenFields+5; i++ {
+		big[strconv.Itoa(1000+i)] = i
+	}
+	fields := Flatten("big", big)
+	require.Len(t, fields, 2*maxFlattenFields+2)
+	require.Equal(t, []interface{}{truncatedFieldsKey, 5}, fields[len(fields)-2:])
+
+	// The pairs are sorted and deduplicated with the other fields.
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, SortFields: true, DedupeKeys: true})
+	l.Info("hello", append([]interface{}{"cfg.name", "old", "z", 1}, Flatten("cfg", m)...)...)
+	require.Contains(t, buf.String(), `message=hello cfg.a.b=1 cfg.db.host=x cfg.db.port=5432 cfg.db.tls.on=true cfg.empty={} cfg.name=api z=1`+"\n")
+}
+
+func TestNonStringKeys(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, DefaultFields: []interface{}{7, "seven", nil, "default"}})
+
+	l.Info("hello world", 42, "v", errors.New("bad"), 1, nil, true, 1.5, "f", "ok", "yes")
+	require.Contains(t, buf.String(), `message="hello world" 7=seven !BADKEY=default 42=v bad=1 !BADKEY=true 1.5=f ok=yes`+"\n")
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Opts.CSVColumns = []string{"42", "ok"}
+	l.Info("hello world", 42, "v", errors.New("bad"), 1, nil, true, "ok", "yes")
+	require.Contains(t, buf.String(), `,v,yes,"{""7"":""seven"",""!BADKEY"":""default"",""bad"":1,""!BADKEY"":true}"`)
+	buf.Reset()
+
+	l.Opts.Format = MsgpackFormat
+	l.Info("hello world", 42, "v", nil, true)
+	frames := decodeMsgpackFrames(t, buf.Bytes())
+	require.Equal(t, "v", frames[0]["42"])
+	require.Equal(t, true, frames[0]["!BADKEY"])
+}
+
+func TestValidateDefaultFields(t *testing.T) {
+	_, err := NewStrict(Opts{DefaultFields: []interface{}{"app", "logf", Int("pid", 1), map[string]interface{}{"env": "prod"}}})
+	require.NoError(t, err)
+
+	_, err = NewStrict(Opts{DefaultFields: []interface{}{42, "v", "message", "hi", String("level", "x"), "dangling"}})
+	require.EqualError(t, err, `default fields: invalid fields: key 42 at 0 is not a string; key "message" is reserved; `+
+		`key "level" is reserved; dangling at 5 has no key`)
+
+	// New logs the problems and normalizes the fields.
+	out := &bytes.Buffer{}
+	log.SetOutput(out)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+
+	l := New(Opts{Writer: io.Discard, DefaultFields: []interface{}{"app", "logf", "dangling"}})
+	require.Equal(t, "logf: default fields: invalid fields: dangling at 2 has no key\n", out.String())
+	require.Equal(t, []interface{}{"app", "logf", "!BADKEY", "dangling"}, l.DefaultFields)
+}
+
+func TestDuplicateBuiltinPolicy(t *testing.T) {
+	// New warns of the reserved default field keys.
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, c := range []struct {
+		policy DuplicateBuiltinPolicy
+		want   string
+	}{
+		{DuplicateBuiltinPrefix, `level=info message=hello field_level=x app=api field_message=y field_caller=z n=1 field_func=f` + "\n"},
+		{DuplicateBuiltinAllow, `level=info message=hello level=x app=api message=y caller=z n=1 func=f` + "\n"},
+		{DuplicateBuiltinDrop, `level=info message=hello app=api n=1` + "\n"},
+	} {
+		buf := &bytes.Buffer{}
+		l := New(Opts{Writer: buf, DuplicateBuiltinPolicy: c.policy, DefaultFields: []interface{}{"level", "x"}})
+		l = l.With("app", "api", "message", "y")
+
+		l.Info("hello", String("caller", "z"), "n", 1, "func", "f")
+		require.Contains(t, buf.String(), c.want, c.policy)
+	}
+
+	// The same applies to msgpack.
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, Format: MsgpackFormat, DuplicateBuiltinPolicy: DuplicateBuiltinDrop})
+	l.Info("hello", "level", "x", "n", 1)
+	m := decodeMsgpackFrames(t, buf.Bytes())[0]
+	require.Equal(t, int64(InfoLevel), m["level"])
+	require.Equal(t, int64(1), m["n"])
+	require.Len(t, m, 4)
+}
+
+func TestNamespace(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+
+	l.Info("request", "id", 1, Namespace("http"), "method", "GET", Int("status", 200), "level", 1,
+		Namespace(""), "took", 2)
+	require.Contains(t, buf.String(), `message=request id=1 http.method=GET http.status=200 http.level=1 took=2`+"\n")
+	buf.Reset()
+
+	l.InfoA("typed", String("a", "b"), Namespace("db"), Err(errors.New("fail")))
+	require.Contains(t, buf.String(), `message=typed a=b db.error=fail db.error_type=*errors.errorString`+"\n")
+	buf.Reset()
+
+	// A namespace opened in the default fields lasts into the fields of
+	// every line.
+	dl := l.With("app", "api", Namespace("req"), "id", 1)
+	dl.Info("hello", "path", "/", Namespace("resp"), "status", 200)
+	require.Contains(t, buf.String(), `app=api req.id=1 req.path=/ resp.status=200`+"\n")
+	buf.Reset()
+
+	dl.Opts.Format = CSVFormat
+	dl.Opts.CSVColumns = []string{"req.path"}
+	dl.Info("hello", "path", "/", Namespace("resp"), "status", 200)
+	require.Contains(t, buf.String(), `,/,"{""app"":""api"",""req.id"":1,""resp.status"":200}"`)
+}
@@ -0,0 +1,145 @@
+package logf
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// gelfHeader starts every GELF message, followed by the host.
+const gelfHeader = `{"version":"1.1","host":`
+
+// errNotGELF is returned by GELFWriter for lines that aren't GELF messages.
+var errNotGELF = errors.New("GELFWriter: line is not a GELF message")
+
+// gelfLevels are the syslog severities of the levels, as GELF expects.
+var gelfLevels = [...]int{
+	DebugLevel: 7,
+	InfoLevel:  6,
+	WarnLevel:  4,
+	ErrorLevel: 3,
+	FatalLevel: 2,
+}
+
+// gelfLogKeys are the default keys of the fields written by the logger
+// itself with GELFFormat. The timestamp, level and message are always
+// written with the keys GELF defines for them.
+var gelfLogKeys = [numLogKeys]string{"timestamp", "level", "short_message", "caller", "func"}
+
+// GELFWriter is an io.Writer that writes the GELF (Graylog Extended Log
+// Format) messages of a logger to a Graylog input, eg:
+//
+//	conn, _ := net.Dial("tcp", "graylog:12201")
+//	l := logf.New(logf.Opts{Writer: logf.NewGELFWriter(conn, ""), Format: logf.GELFFormat})
+//
+// Loggers created with a GELFWriter as their Writer use GELFFormat,
+// whatever the Format in their Opts. Lines that aren't GELF messages, eg:
+// from a logger that writes to the GELFWriter through another writer,
+// are rejected.
+type GELFWriter struct {
+	conn net.Conn
+	host string
+}
+
+// NewGELFWriter returns a GELFWriter that writes to conn. host is the
+// source of the messages of loggers that write to it, unless their
+// Opts.GELFHost is set, and defaults to the hostname.
+func NewGELFWriter(conn net.Conn, host string) *GELFWriter {
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+
+	return &GELFWriter{conn: conn, host: host}
+}
+
+// Write writes the GELF message p.
+func (g *GELFWriter) Write(p []byte) (int, error) {
+	if !bytes.HasPrefix(p, []byte(gelfHeader)) {
+		return 0, errNotGELF
+	}
+
+	return g.conn.Write(p)
+}
+
+// writeGELFEntry writes a complete log entry as a GELF message into the
+// buffer, with the caller and the fields as additional fields.
+func (l *Logger) writeGELFEntry(buf *byteBuffer, msg string, lvl Level, fn, file string, line int, fields []interface{}, typed []Field) {
+	buf.AppendString(gelfHeader)
+	writeQuotedString(buf, l.Opts.GELFHost)
+	buf.AppendString(`,"short_message":`)
+	writeQuotedString(buf, msg)
+
+	// The timestamp is in seconds, with the milliseconds as decimals.
+	buf.AppendString(`,"timestamp":`)
+	buf.AppendFloat(float64(time.Now().UnixNano()/1e6)/1e3, 64)
+
+	buf.AppendString(`,"level":`)
+	if lvl >= DebugLevel && lvl <= FatalLevel {
+		buf.AppendInt(int64(gelfLevels[lvl]))
+	} else {
+		buf.AppendInt(int64(gelfLevels[InfoLevel]))
+	}
+
+	if l.Opts.EnableCaller {
+		writeGELFKey(buf, l.keys.names[callerKey])
+		writeQuotedString(buf, file)
+		buf.AppendString(`,"_line":`)
+		buf.AppendInt(int64(line))
+	}
+	if l.Opts.EnableCallerFunc {
+		writeGELFKey(buf, l.keys.names[funcKey])
+		writeQuotedString(buf, fn)
+	}
+
+	l.writeFields(buf, lvl, fields, typed)
+
+	buf.AppendByte('}')
+	buf.AppendString(l.Opts.LineEnding)
+}
+
+// writeGELFField writes a field as an additional field. GELF only allows
+// numbers and strings, so other values are written as strings of their
+// JSON, eg: "true" or "[1,2]", except null.
+func (l *Logger) writeGELFField(buf *byteBuffer, key string, val interface{}) {
+	writeGELFKey(buf, key)
+
+	start := len(buf.B)
+	o := l.values()
+	o.writeJSONValue(buf, val, 0)
+
+	switch c := buf.B[start]; {
+	case c == '"', c == '-', c >= '0' && c <= '9', c == 'n':
+	case c == 't', c == 'f':
+		// Shift true or false right to make room for the opening quote.
+		buf.AppendByte('"')
+		copy(buf.B[start+1:], buf.B[start:len(buf.B)-1])
+		buf.B[start] = '"'
+		buf.AppendByte('"')
+	default:
+		s := string(buf.B[start:])
+		buf.B = buf.B[:start]
+		writeQuotedString(buf, s)
+	}
+}
+
+// writeGELFKey writes the key of an additional field, preceded by a comma
+// and followed by a colon. The key is prefixed with _ and characters other
+// than letters, digits, _, - and . are replaced with _, as GELF doesn't
+// allow them. id is written as _field_id, as _id is reserved.
+func writeGELFKey(buf *byteBuffer, key string) {
+	buf.AppendString(`,"_`)
+	if key == "id" {
+		buf.AppendString(builtinPrefix)
+	}
+	for _, r := range key {
+		if r == '_' || r == '-' || r == '.' || (r >= 'a' && r <= 'z') ||
+			(r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			buf.AppendByte(byte(r))
+		} else {
+			buf.AppendByte('_')
+		}
+	}
+	buf.AppendString(`":`)
+}
@@ -0,0 +1,87 @@
+package logf
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// bufConn is a net.Conn that records writes.
+type bufConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *bufConn) Write(p []byte) (int, error) {
+	return c.buf.Write(p)
+}
+
+func TestGELFFormat(t *testing.T) {
+	conn := &bufConn{}
+	l := New(Opts{Writer: NewGELFWriter(conn, "app-1"), Format: GELFFormat, EnableCaller: true,
+		DefaultFields: []interface{}{"component", "api"}})
+
+	l.Error("hello world", "status", 500, "id", 7, "my key", "a b", "nil", nil, "ok", true,
+		"tags", []string{"a"}, "d", time.Second, Bool("typed", false), Int("n", 1))
+	l.Info("second")
+
+	frames := strings.Split(conn.buf.String(), "\x00")
+	require.Len(t, frames, 3)
+	require.Empty(t, frames[2])
+
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(frames[0]), &m))
+	require.Equal(t, "1.1", m["version"])
+	require.Equal(t, "app-1", m["host"])
+	require.Equal(t, "hello world", m["short_message"])
+	require.Equal(t, float64(3), m["level"])
+	require.InDelta(t, float64(time.Now().Unix()), m["timestamp"], 60)
+	require.Contains(t, m["_caller"], "gelf_test.go")
+	require.IsType(t, float64(0), m["_line"])
+	require.Equal(t, "api", m["_component"])
+	require.Equal(t, float64(500), m["_status"])
+	require.Equal(t, float64(7), m["_field_id"])
+	require.Equal(t, "a b", m["_my_key"])
+	require.Contains(t, m, "_nil")
+	require.Nil(t, m["_nil"])
+	require.Equal(t, "true", m["_ok"])
+	require.Equal(t, `["a"]`, m["_tags"])
+	require.Equal(t, "1s", m["_d"])
+	require.Equal(t, "false", m["_typed"])
+	require.Equal(t, float64(1), m["_n"])
+	require.NotContains(t, m, "_id")
+	for k := range m {
+		require.Regexp(t, `^(version|host|short_message|timestamp|level|_[\w.-]+)$`, k)
+	}
+
+	require.NoError(t, json.Unmarshal([]byte(frames[1]), &m))
+	require.Equal(t, "second", m["short_message"])
+	require.Equal(t, float64(6), m["level"])
+
+	// Options of the other formats don't apply.
+	conn.buf.Reset()
+	l = New(Opts{Writer: conn, Format: GELFFormat, GELFHost: "app-2", EnableColor: true, Compact: true,
+		TimestampFormat: "15:04", MessageKey: "msg", LineEnding: LineEndingLF})
+	l.Warn("hello", "k", "v")
+	require.Regexp(t, `^\{"version":"1.1","host":"app-2","short_message":"hello","timestamp":\d+(\.\d+)?,"level":4,"_k":"v"\}\n$`,
+		conn.buf.String())
+}
+
+func TestGELFWriter(t *testing.T) {
+	// Loggers that write to a GELFWriter use GELFFormat.
+	conn := &bufConn{}
+	l := New(Opts{Writer: NewGELFWriter(conn, "app-1")})
+	require.Equal(t, GELFFormat, l.Opts.Format)
+	require.Equal(t, "app-1", l.Opts.GELFHost)
+	l.Info("hello")
+	require.True(t, strings.HasPrefix(conn.buf.String(), `{"version":"1.1","host":"app-1","short_message":"hello"`))
+
+	// Lines that aren't GELF messages aren't sent.
+	_, err := NewGELFWriter(conn, "").Write([]byte("level=info message=hi\n"))
+	require.Error(t, err)
+}
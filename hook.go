@@ -0,0 +1,75 @@
+package logf
+
+// Hook is called with every line that is logged, before it is written.
+// Fire returns the fields to write instead of fields, which it may add
+// to, modify or remove from, and false to drop the line. Typed fields,
+// eg: Int("count", 1), are passed in fields as Field values.
+type Hook interface {
+	Fire(lvl Level, msg string, fields []interface{}) ([]interface{}, bool)
+}
+
+// HookFunc is a func that is a Hook.
+type HookFunc func(lvl Level, msg string, fields []interface{}) ([]interface{}, bool)
+
+// Fire calls f.
+func (f HookFunc) Fire(lvl Level, msg string, fields []interface{}) ([]interface{}, bool) {
+	return f(lvl, msg, fields)
+}
+
+// levelHook fires a hook only for lines within a range of levels.
+type levelHook struct {
+	min, max Level
+	hook     Hook
+}
+
+// NewLevelHook returns a Hook that fires hook only for lines of levels
+// from minLevel to maxLevel, inclusive.
+func NewLevelHook(minLevel, maxLevel Level, hook Hook) Hook {
+	return levelHook{min: minLevel, max: maxLevel, hook: hook}
+}
+
+// Fire fires the hook if lvl is within the range.
+func (h levelHook) Fire(lvl Level, msg string, fields []interface{}) ([]interface{}, bool) {
+	if lvl < h.min || lvl > h.max {
+		return fields, true
+	}
+
+	return h.hook.Fire(lvl, msg, fields)
+}
+
+// EachField calls fn with the key and value of every field in fields, eg:
+// the fields a Hook is fired with, for hooks that export lines elsewhere.
+// Typed fields are expanded into their key/value pairs, keys are prefixed
+// with their Namespace and converted to strings as they are when written,
+// and Lazy values are evaluated.
+func EachField(fields []interface{}, fn func(key string, val interface{})) {
+	pairs, _ := expandFields(fields, nil, "")
+	for i := 0; i+1 < len(pairs); i += 2 {
+		val := pairs[i+1]
+		if lv, ok := val.(lazyVal); ok {
+			val = lv.Eval()
+		}
+		fn(fieldKey(pairs[i]), val)
+	}
+}
+
+// fireHooks fires the hooks in Opts.Hooks in order and returns the fields
+// returned by the last one, or false if one of them drops the line. The
+// hooks are passed a copy of the fields, as they may keep them, so that
+// the fields of lines logged without hooks don't escape to the heap.
+func (l *Logger) fireHooks(lvl Level, msg string, fields []interface{}, typed []Field) ([]interface{}, bool) {
+	all := make([]interface{}, 0, len(fields)+len(typed))
+	all = append(all, fields...)
+	for _, f := range typed {
+		all = append(all, f)
+	}
+
+	for _, h := range l.Opts.Hooks {
+		var ok bool
+		if all, ok = h.Fire(lvl, msg, all); !ok {
+			return nil, false
+		}
+	}
+
+	return all, true
+}
@@ -0,0 +1,73 @@
+package logf
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHooks(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var fired []string
+	l := New(Opts{Writer: buf, Level: DebugLevel, Hooks: []Hook{
+		HookFunc(func(lvl Level, msg string, fields []interface{}) ([]interface{}, bool) {
+			fired = append(fired, msg)
+			return append(fields, "hooked", true), true
+		}),
+		HookFunc(func(lvl Level, msg string, fields []interface{}) ([]interface{}, bool) {
+			return fields, msg != "drop"
+		}),
+	}})
+
+	l.Info("hello", "k", "v")
+	require.Contains(t, buf.String(), `message=hello k=v hooked=true`)
+	buf.Reset()
+
+	// Typed fields are passed to the hooks along with the others.
+	l.InfoA("typed", Int("n", 1))
+	require.Contains(t, buf.String(), `message=typed n=1 hooked=true`)
+	buf.Reset()
+
+	l.Info("drop")
+	require.Empty(t, buf.String())
+	require.Equal(t, []string{"hello", "typed", "drop"}, fired)
+
+	// Hooks aren't fired for lines below the level.
+	l.SetLevel(InfoLevel)
+	l.Debug("debug")
+	require.Len(t, fired, 3)
+
+	// Hooks are passed a copy of the fields, so that the fields of lines
+	// logged without hooks don't escape to the heap.
+	l = New(Opts{Writer: io.Discard})
+	require.Zero(t, testing.AllocsPerRun(100, func() {
+		l.Info("hello", "k", "v", "n", 1)
+	}))
+}
+
+func TestLevelHook(t *testing.T) {
+	buf := &bytes.Buffer{}
+	h := NewLevelHook(WarnLevel, ErrorLevel, HookFunc(func(lvl Level, msg string, fields []interface{}) ([]interface{}, bool) {
+		return append(fields, "alert", true), true
+	}))
+	l := New(Opts{Writer: buf, Level: DebugLevel, Hooks: []Hook{h}})
+
+	l.Info("info")
+	require.NotContains(t, buf.String(), "alert")
+	l.Warn("warn")
+	require.Contains(t, buf.String(), "message=warn alert=true")
+	buf.Reset()
+	l.Error("error")
+	require.Contains(t, buf.String(), "message=error alert=true")
+}
+
+func TestEachField(t *testing.T) {
+	var pairs []interface{}
+	EachField([]interface{}{"a", 1, Int("n", 2), Namespace("req"), "lazy", Lazy(func() interface{} { return "v" }), 5, true, "dangling"},
+		func(key string, val interface{}) {
+			pairs = append(pairs, key, val)
+		})
+	require.Equal(t, []interface{}{"a", 1, "n", 2, "req.lazy", "v", "req.5", true, "!BADKEY", "dangling"}, pairs)
+}
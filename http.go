@@ -0,0 +1,55 @@
+package logf
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// levelPayload is the JSON body of the requests and responses of
+// LevelHandlerFunc, eg: {"level":"info"}.
+type levelPayload struct {
+	Level string `json:"level,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// LevelHandlerFunc returns an HTTP handler that returns the level of the
+// logger on GET as {"level":"info"} and changes it on PUT or POST with a
+// body such as {"level":"debug"}, eg:
+//
+//	mux.Handle("/log/level", logger.LevelHandlerFunc())
+//
+// Levels are parsed with LevelFromString. Loggers that share the level
+// of the logger, such as the ones derived with With, change too.
+func (l *Logger) LevelHandlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var p levelPayload
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				writeLevelResponse(w, http.StatusBadRequest, levelPayload{Error: "invalid JSON body"})
+				return
+			}
+
+			lvl, err := LevelFromString(p.Level)
+			if err != nil {
+				writeLevelResponse(w, http.StatusBadRequest, levelPayload{Error: "invalid level: " + p.Level})
+				return
+			}
+			l.SetLevel(lvl)
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			writeLevelResponse(w, http.StatusMethodNotAllowed, levelPayload{Error: "method not allowed"})
+			return
+		}
+
+		writeLevelResponse(w, http.StatusOK, levelPayload{Level: l.GetLevel().String()})
+	}
+}
+
+// writeLevelResponse writes p as the JSON response of LevelHandlerFunc.
+func writeLevelResponse(w http.ResponseWriter, status int, p levelPayload) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,52 @@
+package logf
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLevelHandlerFunc(t *testing.T) {
+	l := New(Opts{Writer: io.Discard})
+	child := l.With("component", "child")
+
+	mux := http.NewServeMux()
+	mux.Handle("/log/level", l.LevelHandlerFunc())
+
+	do := func(method, body string) (int, string) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, "/log/level", strings.NewReader(body)))
+		require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		return rec.Code, rec.Body.String()
+	}
+
+	code, body := do(http.MethodGet, "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, `{"level":"info"}`+"\n", body)
+
+	code, body = do(http.MethodPut, `{"level":"debug"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, `{"level":"debug"}`+"\n", body)
+	require.Equal(t, DebugLevel, l.GetLevel())
+	require.Equal(t, DebugLevel, child.GetLevel())
+
+	code, body = do(http.MethodPost, `{"level":"WARNING"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, `{"level":"warn"}`+"\n", body)
+
+	// Bad requests leave the level as is.
+	code, body = do(http.MethodPut, `{"level":"verbose"}`)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Equal(t, `{"error":"invalid level: verbose"}`+"\n", body)
+
+	code, _ = do(http.MethodPut, `level=debug`)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = do(http.MethodDelete, "")
+	require.Equal(t, http.StatusMethodNotAllowed, code)
+	require.Equal(t, WarnLevel, l.GetLevel())
+}
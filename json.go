@@ -0,0 +1,497 @@
+package logf
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"time"
+	"unsafe"
+)
+
+// maxNestingDepth is the depth up to which nested values are written.
+const maxNestingDepth = 5
+
+// jsonSchema is the layout of a JSON format: the default keys of the
+// fields written by the logger itself, and how they are written.
+type jsonSchema struct {
+	keys [numLogKeys]string
+
+	// lineKey is the key of the caller's line, which is written as a
+	// number apart from the caller's file.
+	lineKey string
+
+	// header holds the members written after the timestamp in every entry.
+	header string
+
+	// levels are the names the levels are written with, if not their own.
+	levels [FatalLevel + 1]string
+
+	// nestedCaller writes the caller as an object under the caller key,
+	// with the members file, line and function, instead of flat keys.
+	nestedCaller bool
+}
+
+// jsonSchemas are the layouts of the JSON formats.
+var jsonSchemas = map[Format]*jsonSchema{
+	ECSFormat: {
+		keys:    [numLogKeys]string{"@timestamp", "log.level", "message", "log.origin.file.name", "log.origin.function"},
+		lineKey: "log.origin.file.line",
+		header:  `"@version":"1","ecs.version":"1.6.0"`,
+	},
+	GCPFormat: {
+		keys: [numLogKeys]string{"timestamp", "severity", "message", "sourceLocation", "function"},
+		levels: [FatalLevel + 1]string{
+			DebugLevel: "DEBUG",
+			InfoLevel:  "INFO",
+			WarnLevel:  "WARNING",
+			ErrorLevel: "ERROR",
+			FatalLevel: "CRITICAL",
+		},
+		nestedCaller: true,
+	},
+	DatadogFormat: {
+		keys:    [numLogKeys]string{"@timestamp", "status", "message", "logger.file_name", "logger.method_name"},
+		lineKey: "logger.line",
+		levels: [FatalLevel + 1]string{
+			DebugLevel: "debug",
+			InfoLevel:  "info",
+			WarnLevel:  "warning",
+			ErrorLevel: "error",
+			FatalLevel: "critical",
+		},
+	},
+}
+
+// writeJSONEntry writes a complete log entry as a single line JSON object
+// into the buffer, in the layout of the configured JSON format.
+func (l *Logger) writeJSONEntry(buf *byteBuffer, msg string, lvl Level, fn, file string, line int, fields []interface{}, typed []Field) {
+	s := jsonSchemas[l.Opts.Format]
+
+	buf.AppendByte('{')
+	writeQuotedString(buf, l.keys.names[timestampKey])
+	buf.AppendString(`:"`)
+	buf.AppendTime(time.Now(), l.Opts.TimestampFormat)
+	buf.AppendByte('"')
+	if s.header != "" {
+		buf.AppendByte(',')
+		buf.AppendString(s.header)
+	}
+
+	lvlName := lvl.String()
+	if lvl >= DebugLevel && lvl <= FatalLevel && s.levels[lvl] != "" {
+		lvlName = s.levels[lvl]
+	}
+	writeJSONString(buf, l.keys.names[levelKey], lvlName)
+	writeJSONString(buf, l.keys.names[messageKey], msg)
+
+	if s.nestedCaller {
+		l.writeJSONCaller(buf, fn, file, line)
+	} else {
+		if l.Opts.EnableCaller {
+			writeJSONString(buf, l.keys.names[callerKey], file)
+			buf.AppendByte(',')
+			writeQuotedString(buf, s.lineKey)
+			buf.AppendByte(':')
+			buf.AppendInt(int64(line))
+		}
+		if l.Opts.EnableCallerFunc {
+			writeJSONString(buf, l.keys.names[funcKey], fn)
+		}
+	}
+
+	l.writeFields(buf, lvl, fields, typed)
+
+	buf.AppendByte('}')
+	buf.AppendString(l.Opts.LineEnding)
+}
+
+// writeJSONCaller writes the caller as an object under the caller key, as
+// the sourceLocation of GCP: {"file":"...","line":"42","function":"..."}.
+// The line is a string, as int64 values are in the JSON of the Cloud
+// Logging API.
+func (l *Logger) writeJSONCaller(buf *byteBuffer, fn, file string, line int) {
+	if !l.Opts.EnableCaller && !l.Opts.EnableCallerFunc {
+		return
+	}
+
+	buf.AppendByte(',')
+	writeQuotedString(buf, l.keys.names[callerKey])
+	buf.AppendString(":{")
+	if l.Opts.EnableCaller {
+		buf.AppendString(`"file":`)
+		writeQuotedString(buf, file)
+		buf.AppendString(`,"line":"`)
+		buf.AppendInt(int64(line))
+		buf.AppendByte('"')
+	}
+	if l.Opts.EnableCallerFunc {
+		if l.Opts.EnableCaller {
+			buf.AppendByte(',')
+		}
+		buf.AppendString(`"function":`)
+		writeQuotedString(buf, fn)
+	}
+	buf.AppendByte('}')
+}
+
+// writeJSONValue writes a field value into the buffer as a JSON value.
+// depth is the nesting level of the value within maps.
+func (o *valueOpts) writeJSONValue(buf *byteBuffer, val interface{}, depth int) {
+	switch v := val.(type) {
+	case nil:
+		buf.AppendString("null")
+	case []byte:
+		if o.bytesEncoding != BytesRaw {
+			buf.AppendByte('"')
+			o.writeEncodedBytes(buf, v, false)
+			buf.AppendByte('"')
+			return
+		}
+		writeQuotedString(buf, string(v))
+	case string:
+		writeQuotedString(buf, v)
+	case int:
+		buf.AppendInt(int64(v))
+	case int8:
+		buf.AppendInt(int64(v))
+	case int16:
+		buf.AppendInt(int64(v))
+	case int32:
+		buf.AppendInt(int64(v))
+	case int64:
+		buf.AppendInt(v)
+	case uint:
+		buf.AppendUint(uint64(v))
+	case uint8:
+		buf.AppendUint(uint64(v))
+	case uint16:
+		buf.AppendUint(uint64(v))
+	case uint32:
+		buf.AppendUint(uint64(v))
+	case uint64:
+		buf.AppendUint(v)
+	case uintptr:
+		o.writePointerValue(buf, v, true)
+	case unsafe.Pointer:
+		o.writePointerValue(buf, uintptr(v), true)
+	case float32:
+		writeJSONFloat(buf, float64(v), 32)
+	case float64:
+		writeJSONFloat(buf, v, 64)
+	case complex64:
+		buf.AppendByte('"')
+		buf.AppendComplex(complex128(v), 64)
+		buf.AppendByte('"')
+	case complex128:
+		buf.AppendByte('"')
+		buf.AppendComplex(v, 128)
+		buf.AppendByte('"')
+	case bool:
+		buf.AppendBool(v)
+	case time.Time:
+		if v.IsZero() {
+			buf.AppendString("null")
+			return
+		}
+		buf.AppendByte('"')
+		buf.AppendTime(v, o.fieldTimeFormat)
+		buf.AppendByte('"')
+	case time.Duration:
+		if o.durationFormat == DurationString {
+			buf.AppendByte('"')
+			buf.AppendDuration(v)
+			buf.AppendByte('"')
+			return
+		}
+		o.writeDurationValue(buf, v)
+	case *string, *int, *int32, *int64, *uint32, *uint64, *float32, *float64, *bool, *time.Time:
+		o.writeJSONValue(buf, derefBasic(v), depth)
+	case *big.Int, *big.Float, *big.Rat:
+		// Written as strings as JSON parsers may not hold the numbers.
+		if isNilPointer(v) {
+			buf.AppendString("null")
+			return
+		}
+		buf.AppendByte('"')
+		buf.AppendBig(v)
+		buf.AppendByte('"')
+	case ObjectMarshaler:
+		if isNilPointer(v) {
+			buf.AppendString("null")
+			return
+		}
+		o.writeJSONObject(buf, v, depth)
+	case error, fmt.Stringer, encoding.TextMarshaler:
+		if isNilPointer(v) {
+			buf.AppendString("null")
+			return
+		}
+		if tmp, ok := typeEncoded(v); ok {
+			writeQuotedString(buf, string(tmp.Bytes()))
+			bufPool.Put(tmp)
+			return
+		}
+		writeQuotedString(buf, o.textValue(v))
+	case []string:
+		buf.AppendByte('[')
+		for i, s := range v {
+			if i > 0 {
+				buf.AppendByte(',')
+			}
+			writeQuotedString(buf, s)
+		}
+		buf.AppendByte(']')
+	case []int:
+		buf.AppendByte('[')
+		for i, n := range v {
+			if i > 0 {
+				buf.AppendByte(',')
+			}
+			buf.AppendInt(int64(n))
+		}
+		buf.AppendByte(']')
+	case []int64:
+		buf.AppendByte('[')
+		for i, n := range v {
+			if i > 0 {
+				buf.AppendByte(',')
+			}
+			buf.AppendInt(n)
+		}
+		buf.AppendByte(']')
+	case []float64:
+		buf.AppendByte('[')
+		for i, f := range v {
+			if i > 0 {
+				buf.AppendByte(',')
+			}
+			writeJSONFloat(buf, f, 64)
+		}
+		buf.AppendByte(']')
+	case []bool:
+		buf.AppendByte('[')
+		for i, b := range v {
+			if i > 0 {
+				buf.AppendByte(',')
+			}
+			buf.AppendBool(b)
+		}
+		buf.AppendByte(']')
+	default:
+		if tmp, ok := typeEncoded(val); ok {
+			writeQuotedString(buf, string(tmp.Bytes()))
+			bufPool.Put(tmp)
+			return
+		}
+		if b, ok := marshalJSON(val); ok {
+			buf.B = append(buf.B, b...)
+			return
+		}
+
+		switch rv := reflect.ValueOf(val); rv.Kind() {
+		case reflect.Map:
+			o.writeJSONMap(buf, rv, depth)
+		case reflect.Ptr:
+			if !o.dereferencePointers {
+				writeQuotedString(buf, o.sprintValue(val))
+				return
+			}
+			if rv.IsNil() {
+				buf.AppendString("null")
+				return
+			}
+			if o.derefs >= maxNestingDepth {
+				buf.AppendString(`"..."`)
+				return
+			}
+			o.derefs++
+			o.writeJSONValue(buf, rv.Elem().Interface(), depth)
+			o.derefs--
+		case reflect.Slice, reflect.Array:
+			o.writeJSONSlice(buf, rv, depth)
+		default:
+			writeQuotedString(buf, o.sprintValue(val))
+		}
+	}
+}
+
+// marshalJSON returns the JSON of a value that implements json.Marshaler,
+// compacted so that it is on one line. It returns false if the value
+// doesn't implement it, or if MarshalJSON fails, panics or returns
+// invalid JSON, in which case the value is to be formatted with fmt.
+func marshalJSON(val interface{}) (b []byte, ok bool) {
+	m, ok := val.(json.Marshaler)
+	if !ok {
+		return nil, false
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			b, ok = nil, false
+		}
+	}()
+
+	raw, err := m.MarshalJSON()
+	if err != nil {
+		return nil, false
+	}
+
+	var out bytes.Buffer
+	if err := json.Compact(&out, raw); err != nil {
+		return nil, false
+	}
+	return out.Bytes(), true
+}
+
+// writeJSONSlice writes a slice or array as a JSON array, encoding the
+// elements one by one. Slices nested deeper than maxNestingDepth are
+// written as "...".
+func (o *valueOpts) writeJSONSlice(buf *byteBuffer, rv reflect.Value, depth int) {
+	if depth >= maxNestingDepth {
+		buf.AppendString(`"..."`)
+		return
+	}
+
+	buf.AppendByte('[')
+	for i := 0; i < rv.Len(); i++ {
+		if i > 0 {
+			buf.AppendByte(',')
+		}
+		o.writeJSONValue(buf, rv.Index(i).Interface(), depth+1)
+	}
+	buf.AppendByte(']')
+}
+
+// writeJSONMap writes a map as a JSON object with its keys sorted, so that
+// the same map always produces the same output. Keys that aren't strings
+// are formatted with fmt. Maps nested deeper than maxNestingDepth are
+// written as "...".
+func (o *valueOpts) writeJSONMap(buf *byteBuffer, rv reflect.Value, depth int) {
+	if rv.IsNil() {
+		buf.AppendString("null")
+		return
+	}
+	if depth >= maxNestingDepth {
+		buf.AppendString(`"..."`)
+		return
+	}
+
+	type kv struct {
+		k string
+		v reflect.Value
+	}
+	entries := make([]kv, 0, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		k := iter.Key()
+		if k.Kind() == reflect.String {
+			entries = append(entries, kv{k.String(), iter.Value()})
+		} else {
+			entries = append(entries, kv{fmt.Sprint(k.Interface()), iter.Value()})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].k < entries[j].k })
+
+	buf.AppendByte('{')
+	for i, e := range entries {
+		if i > 0 {
+			buf.AppendByte(',')
+		}
+		writeQuotedString(buf, e.k)
+		buf.AppendByte(':')
+		o.writeJSONValue(buf, e.v.Interface(), depth+1)
+	}
+	buf.AppendByte('}')
+}
+
+// writeJSONFloat writes a float as a JSON number. NaN and infinities
+// have no JSON representation and are written as strings.
+func writeJSONFloat(buf *byteBuffer, f float64, bitSize int) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		buf.AppendByte('"')
+		buf.AppendFloat(f, bitSize)
+		buf.AppendByte('"')
+		return
+	}
+
+	buf.AppendFloat(f, bitSize)
+}
+
+// writeJSONFields writes the default fields followed by the given fields
+// as a JSON object into the buffer, skipping keys listed in exclude.
+func (l *Logger) writeJSONFields(buf *byteBuffer, defaults, fields []interface{}, exclude []string) {
+	buf.AppendByte('{')
+
+	lists := [2][]interface{}{defaults, fields}
+	if l.Opts.DefaultFieldsLast {
+		lists = [2][]interface{}{fields, defaults}
+	}
+
+	first := true
+	for _, list := range lists {
+		for i := 0; i+1 < len(list); i += 2 {
+			key := fieldKey(list[i])
+			if containsString(exclude, key) {
+				continue
+			}
+
+			val := l.fieldValue(key, list[i+1])
+			if l.Opts.OmitEmpty && isEmptyValue(val) {
+				continue
+			}
+			l.writeJSONField(buf, key, val, first)
+			first = false
+
+			if l.Opts.ExpandErrors {
+				if chain, ok := errorChain(val); ok {
+					k := key + "_chain"
+					l.writeJSONField(buf, k, l.fieldValue(k, chain), false)
+				}
+			}
+			if l.Opts.ErrorStacktrace {
+				if stack, ok := errorStack(val); ok {
+					k := key + "_stack"
+					l.writeJSONField(buf, k, l.fieldValue(k, stack), false)
+				}
+			}
+		}
+	}
+
+	buf.AppendByte('}')
+}
+
+// writeJSONField writes a "key":value member of an object,
+// preceded by a comma unless it is the first one.
+func (l *Logger) writeJSONField(buf *byteBuffer, key string, val interface{}, first bool) {
+	if !first {
+		buf.AppendByte(',')
+	}
+
+	writeQuotedString(buf, key)
+	buf.AppendByte(':')
+	o := l.values()
+	o.writeJSONValue(buf, val, 0)
+}
+
+// writeJSONString writes a ,"key":"val" member of an object.
+func writeJSONString(buf *byteBuffer, key, val string) {
+	buf.AppendByte(',')
+	writeQuotedString(buf, key)
+	buf.AppendByte(':')
+	writeQuotedString(buf, val)
+}
+
+// containsString returns true if s is in list.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package logf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// ecsKey matches the keys allowed by the Elastic Common Schema: lowercase
+// dotted names, with the @ prefixed metadata fields.
+var ecsKey = regexp.MustCompile(`^(@timestamp|@version|[a-z0-9_]+(\.[a-z0-9_]+)*)$`)
+
+func TestECSFormat(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, Format: ECSFormat, EnableCaller: true, EnableCallerFunc: true,
+		DefaultFields: []interface{}{"service.name", "api"}})
+
+	l.Warn("hello \"world\"", "user.id", 1, "message", "dup", "err", errors.New("bad"), Bool("ok", true),
+		"tags", []string{"a", "b"}, "obj", &objUser{ID: 1, Name: "alice"})
+	require.True(t, strings.HasSuffix(buf.String(), "}\n"))
+	require.Equal(t, 1, strings.Count(buf.String(), "\n"))
+
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &m))
+	for k := range m {
+		require.Regexp(t, ecsKey, k)
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, m["@timestamp"].(string))
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), ts, time.Minute)
+	require.Equal(t, "1", m["@version"])
+	require.Equal(t, "warn", m["log.level"])
+	require.Equal(t, `hello "world"`, m["message"])
+	require.Contains(t, m["log.origin.file.name"], "json_test.go")
+	require.IsType(t, float64(0), m["log.origin.file.line"])
+	require.Equal(t, "logf.TestECSFormat", m["log.origin.function"])
+	require.Equal(t, "api", m["service.name"])
+	require.Equal(t, float64(1), m["user.id"])
+	require.Equal(t, "dup", m["field_message"])
+	require.Equal(t, "bad", m["err"])
+	require.Equal(t, true, m["ok"])
+	require.Equal(t, []interface{}{"a", "b"}, m["tags"])
+	require.Equal(t, map[string]interface{}{"id": float64(1), "name": "alice", "score": float64(0), "admin": false, "tags": []interface{}{}}, m["obj"])
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, Format: ECSFormat})
+	l.Info("hello world")
+	require.Regexp(t, `^\{"@timestamp":"[^"]+","@version":"1","ecs.version":"1.6.0","log.level":"info","message":"hello world"\}\n$`, buf.String())
+}
+
+func TestGCPFormat(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, Format: GCPFormat, EnableCaller: true, EnableCallerFunc: true,
+		DefaultFields: []interface{}{"component", "api"}})
+
+	l.Warn("hello \"world\"", "user", 1, "severity", "dup", "obj", &objUser{ID: 1, Name: "alice"})
+	require.True(t, strings.HasSuffix(buf.String(), "}\n"))
+
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &m))
+
+	ts, err := time.Parse(time.RFC3339Nano, m["timestamp"].(string))
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), ts, time.Minute)
+	require.Equal(t, "WARNING", m["severity"])
+	require.Equal(t, `hello "world"`, m["message"])
+
+	loc := m["sourceLocation"].(map[string]interface{})
+	require.Contains(t, loc["file"], "json_test.go")
+	require.Regexp(t, `^\d+$`, loc["line"])
+	require.Equal(t, "logf.TestGCPFormat", loc["function"])
+
+	require.Equal(t, "api", m["component"])
+	require.Equal(t, float64(1), m["user"])
+	require.Equal(t, "dup", m["field_severity"])
+	require.Equal(t, "alice", m["obj"].(map[string]interface{})["name"])
+	buf.Reset()
+
+	// Every level has a severity.
+	exit = func() {}
+	l = New(Opts{Writer: buf, Format: GCPFormat, Level: DebugLevel})
+	l.Debug("a")
+	l.Info("b")
+	l.Error("c")
+	l.Fatal("d")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 4)
+	for i, sev := range []string{"DEBUG", "INFO", "ERROR", "CRITICAL"} {
+		require.Contains(t, lines[i], `"severity":"`+sev+`"`)
+	}
+	require.Regexp(t, `^\{"timestamp":"[^"]+","severity":"CRITICAL","message":"d"\}$`, lines[3])
+	buf.Reset()
+
+	// The caller function alone.
+	l = New(Opts{Writer: buf, Format: GCPFormat, EnableCallerFunc: true})
+	l.Info("hello")
+	require.Contains(t, buf.String(), `"sourceLocation":{"function":"logf.TestGCPFormat"}}`)
+}
@@ -1,20 +1,55 @@
 package logf
 
 import (
+	"bytes"
+	"encoding"
+	"errors"
 	"fmt"
 	"io"
 	stdlog "log"
+	"math/big"
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
+	"unsafe"
 )
 
 const (
-	tsKey           = "timestamp="
 	defaultTSFormat = "2006-01-02T15:04:05.999Z07:00"
+	compactTSFormat = "15:04:05.000"
+
+	// maxErrorChain is the number of wrapped errors written with ExpandErrors.
+	maxErrorChain = 10
+
+	// maxLogValuerDepth is the number of times a LogValuer returning
+	// another LogValuer is resolved.
+	maxLogValuerDepth = 4
+
+	// defaultBufSize is the default capacity of log line buffers. It fits
+	// most lines, eg: the ones in BenchmarkHugePayload.
+	defaultBufSize = 256
+
+	// defaultMaxBufSize is the default capacity above which log line
+	// buffers aren't reused.
+	defaultMaxBufSize = 64 << 10
+
+	// Line endings that can be set in Opts.LineEnding.
+	LineEndingLF   = "\n"
+	LineEndingCRLF = "\r\n"
+	LineEndingNUL  = "\x00"
+
+	// Redacted is the placeholder that the values of Opts.RedactKeys are
+	// written as, and that an Opts.Redact func can return to mask a value.
+	Redacted = "[REDACTED]"
 
 	// ANSI escape codes for coloring text in console.
 	reset  = "\033[0m"
@@ -30,38 +65,431 @@ const (
 	WarnLevel                   // 3
 	ErrorLevel                  // 4
 	FatalLevel                  // 5
+
+	// OffLevel is above every level and disables logging.
+	OffLevel // 6
+)
+
+const (
+	// LogfmtFormat emits human readable logfmt lines. This is the default.
+	LogfmtFormat Format = iota
+	// MsgpackFormat emits every entry as a msgpack map, prefixed with its
+	// length as a 4 byte big-endian integer.
+	MsgpackFormat
+	// CSVFormat emits every entry as an RFC4180 CSV row with the columns
+	// timestamp, level, message, caller (and func if EnableCallerFunc is set)
+	// followed by the fields.
+	CSVFormat
+	// ECSFormat emits every entry as a single line JSON object with the
+	// keys of the Elastic Common Schema, as expected by Logstash, eg:
+	// {"@timestamp":"...","log.level":"info","message":"hello","user":"x"}.
+	// The caller is written as log.origin.file.name and log.origin.file.line.
+	ECSFormat
+	// GCPFormat emits every entry as a single line JSON object with the
+	// keys of Google Cloud Logging's structured logs, with the level as
+	// the severity DEBUG, INFO, WARNING, ERROR or CRITICAL (for fatal), eg:
+	// {"timestamp":"...","severity":"INFO","message":"hello","user":"x"}.
+	// The caller is written as sourceLocation.file and sourceLocation.line.
+	GCPFormat
+	// DatadogFormat emits every entry as a single line JSON object with the
+	// reserved attributes of Datadog, with the level as the status debug,
+	// info, warning, error or critical (for fatal), eg:
+	// {"@timestamp":"...","status":"info","message":"hello","user":"x"}.
+	// The caller is written as logger.file_name and logger.line. Lines can
+	// be correlated with traces with Logger.WithDatadogTrace.
+	DatadogFormat
+	// GELFFormat emits every entry as a GELF 1.1 message for Graylog: a JSON
+	// object with Opts.GELFHost as the host, the message as short_message,
+	// the timestamp in Unix seconds and the level as its syslog severity, eg:
+	// {"version":"1.1","host":"app-1","short_message":"hello","timestamp":1700000000.123,"level":6,"_user":"x"}.
+	// The caller (with _line) and the fields are written as additional
+	// fields, prefixed with _, and values other than numbers and strings as
+	// strings. Lines end with a null byte, which frames them over TCP, unless
+	// LineEnding is set. TimestampFormat, TimestampKey, LevelKey and
+	// MessageKey don't apply.
+	GELFFormat
+)
+
+const (
+	// DurationString renders durations like time.Duration.String, eg: 1.5s.
+	DurationString DurationFormat = iota
+	// DurationSeconds renders durations as float seconds, eg: 1.5.
+	DurationSeconds
+	// DurationMillis renders durations as integer milliseconds, eg: 1500.
+	DurationMillis
+)
+
+const (
+	// BytesRaw writes []byte values as strings.
+	BytesRaw BytesEncoding = iota
+	// BytesHex writes []byte values hex encoded.
+	BytesHex
+	// BytesBase64 writes []byte values in standard base64.
+	BytesBase64
+)
+
+const (
+	// DuplicateBuiltinPrefix writes such fields with their key prefixed
+	// with field_, eg: field_level=x. This is the default.
+	DuplicateBuiltinPrefix DuplicateBuiltinPolicy = iota
+	// DuplicateBuiltinAllow writes such fields as is, so that the key
+	// occurs twice in the line.
+	DuplicateBuiltinAllow
+	// DuplicateBuiltinDrop drops such fields.
+	DuplicateBuiltinDrop
+)
+
+const (
+	// SliceJSON renders slices as JSON arrays, eg: tags="[\"a\",\"b c\"]".
+	SliceJSON SliceFormat = iota
+	// SliceComma renders slice elements separated by commas, eg: tags="a,b c".
+	SliceComma
 )
 
 // syncWriter is a wrapper around io.Writer that
 // synchronizes writes using a mutex.
+//
+// Every write has to be exclusive, as io.Writers aren't safe for concurrent
+// use and lines mustn't interleave, so neither a RWMutex nor swapping the
+// writer atomically takes the lock off the write path. Lines are encoded
+// before the lock is taken, which is held only for the write itself, as
+// BenchmarkSyncWriterContention measures. Slow writers can be wrapped in
+// an AsyncWriter. The mutex is a pointer so that clones, which have a
+// syncWriter of their own, share it with their parent.
 type syncWriter struct {
-	sync.Mutex
+	*sync.Mutex
 	w io.Writer
 }
 
+// lazyVal is a field value that is evaluated only when it is written.
+type lazyVal interface {
+	Eval() interface{}
+}
+
+// Lazy is a field value that is computed by calling the func only if the
+// log line is emitted, eg: Info("request", "body", Lazy(func() interface{} { ... })).
+// The func is called for every line it is logged with and may be called
+// concurrently if the logger is shared between goroutines.
+type Lazy func() interface{}
+
+// Eval calls the func and returns its value, or !PANIC: <recovered value>
+// if it panics.
+func (f Lazy) Eval() (v interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			v = fmt.Sprintf("!PANIC: %v", r)
+		}
+	}()
+
+	return f()
+}
+
+// LogValuer is implemented by types that control how they are logged,
+// eg: a User that logs only its ID. The value returned by LogValue is
+// written instead, taking precedence over error, fmt.Stringer and any
+// other representation of the type.
+type LogValuer interface {
+	LogValue() interface{}
+}
+
 // Severity level of the log.
 type Level int
 
+// Format is the encoding in which log lines are emitted.
+type Format int
+
+// DurationFormat is the representation of time.Duration field values.
+type DurationFormat int
+
+// BytesEncoding is the representation of []byte field values.
+type BytesEncoding int
+
+// SliceFormat is the representation of slice and array field values.
+type SliceFormat int
+
+// DuplicateBuiltinPolicy is what is done with fields whose keys are the
+// keys written by the logger itself, eg: level.
+type DuplicateBuiltinPolicy int
+
 // Opts represents the config options for the package.
 type Opts struct {
-	Writer               io.Writer
+	Writer io.Writer
+
+	// Level is the initial level of the logger. It can be changed
+	// at runtime with Logger.SetLevel, which doesn't update it: the level
+	// of a running logger is that of AtomicLevel, as GetLevel returns.
 	Level                Level
 	TimestampFormat      string
 	EnableColor          bool
 	EnableCaller         bool
 	CallerSkipFrameCount int
 
-	// These fields will be printed with every log.
+	// AtomicLevel, if set, is the level of the logger instead of Level.
+	// Sharing an AtomicLevel between loggers changes their levels together.
+	AtomicLevel AtomicLevel
+
+	// CallerShortPath writes only the file name of the caller
+	// instead of its full path.
+	CallerShortPath bool
+
+	// EnableCallerFunc adds the name of the calling function,
+	// eg: func=main.handleRequest, after the caller.
+	EnableCallerFunc bool
+
+	// LevelColors are the ANSI escape sequences used to color each level,
+	// indexed by Level. Empty entries use the default colors.
+	LevelColors [6]string
+
+	// FieldTimeFormat is the layout for time.Time field values.
+	// Defaults to TimestampFormat.
+	FieldTimeFormat string
+
+	// DurationFormat is the representation of time.Duration field values.
+	// Defaults to DurationString.
+	DurationFormat DurationFormat
+
+	// SliceFormat is the representation of slice and array field values.
+	// Defaults to SliceJSON.
+	SliceFormat SliceFormat
+
+	// BytesEncoding is the representation of []byte field values.
+	// Defaults to BytesRaw. Encoded values longer than MaxFieldValueLen
+	// bytes are truncated and followed by the number of bytes cut off, eg:
+	// …(truncated 2 bytes), like other values.
+	BytesEncoding BytesEncoding
+
+	// DecimalPointers writes uintptr and unsafe.Pointer field values as
+	// decimal numbers instead of 0x prefixed hex, eg: 0xc000012345.
+	DecimalPointers bool
+
+	// ZeroTimeEmpty renders zero time.Time field values as an empty
+	// value instead of null.
+	ZeroTimeEmpty bool
+
+	// ReflectFields writes slices and arrays of types other than []string,
+	// []int, []int64, []float64 and []bool like them, by walking their
+	// elements with reflection, eg: ids=[1,2] for a []uint. Otherwise,
+	// they are formatted with fmt, eg: ids="[1 2]". Maps are always
+	// walked.
+	ReflectFields bool
+
+	// DereferencePointers writes the value that a pointer field points to
+	// like the value itself, or null if the pointer is nil, instead of
+	// formatting the pointer with fmt, eg: user="{1 alice}" instead of
+	// user="&{1 alice}" for a *User, and the value instead of its address
+	// for a pointer to a pointer. Pointers to basic types and time.Time
+	// are always dereferenced. Values behind more than 5 pointers, eg: in
+	// a pointer cycle, are written as ....
+	DereferencePointers bool
+
+	// SortFields writes the fields of every line, default fields included,
+	// sorted by key, eg: for diffable logs. The timestamp, level, message
+	// and caller keep their positions. It doesn't apply to CSVFormat.
+	SortFields bool
+
+	// DedupeKeys writes only the last of the fields of a line that have
+	// the same key, so that per-call fields replace default fields, eg:
+	// component=api instead of component=worker component=api. It doesn't
+	// apply to CSVFormat, whose columns already take the last value.
+	DedupeKeys bool
+
+	// DefaultFieldsLast writes the default fields, including those added
+	// with With, after the per-call fields instead of before them, eg:
+	// message=done status=200 component=api.
+	DefaultFieldsLast bool
+
+	// OmitEmpty skips fields whose value is nil, a nil pointer, an empty
+	// string or an empty slice or map, eg: trace_id= when there is none.
+	OmitEmpty bool
+
+	// LineEnding terminates every log line. It has to be one of
+	// LineEndingLF (default), LineEndingCRLF or LineEndingNUL.
+	LineEnding string
+
+	// Format of the emitted log lines. Defaults to LogfmtFormat.
+	Format Format
+
+	// CSVColumns are the field keys written as individual columns
+	// with CSVFormat, in order. Fields not listed are written as a
+	// JSON object in a trailing overflow column unless CSVDropOverflow
+	// is set. If empty, all fields are written as one JSON object column.
+	CSVColumns      []string
+	CSVDropOverflow bool
+
+	// GELFHost is the host of the messages written with GELFFormat.
+	// Defaults to the host of the GELFWriter that is the Writer, if any,
+	// or the hostname.
+	GELFHost string
+
+	// Compact renders the level as a bracketed letter (eg: [I]) and omits
+	// the timestamp and level keys. The timestamp defaults to a
+	// shorter 15:04:05.000 layout unless TimestampFormat is set.
+	Compact bool
+
+	// InitialBufSize is the capacity that log line buffers are allocated
+	// with, to avoid reallocations while writing large lines.
+	// Defaults to 256 bytes.
+	InitialBufSize int
+
+	// MaxBufSize is the capacity above which log line buffers are dropped
+	// instead of being reused, so that logging an occasional huge payload
+	// doesn't hold on to its memory. Defaults to 64 KB.
+	MaxBufSize int
+
+	// ExpandErrors adds a <key>_chain field after error values that wrap
+	// other errors, with the messages of every error in the chain
+	// joined by " <- ".
+	ExpandErrors bool
+
+	// ErrorStacktrace adds a <key>_stack field after error values that
+	// carry a stack trace, ie: implement Stack() []byte or StackTrace()
+	// like github.com/pkg/errors.
+	ErrorStacktrace bool
+
+	// SampleRate is the maximum number of lines of a level logged a second,
+	// eg: {DebugLevel: 100}. Lines over the rate are dropped and counted,
+	// and once lines of the level are logged again, the count is logged
+	// first as "<n> <level> messages dropped". Levels with a rate <= 0
+	// aren't sampled.
+	SampleRate map[Level]int
+
+	// DedupeWindow, if set, suppresses lines with the same level and
+	// message as a line logged less than the window ago. Once the window
+	// has expired, the number of lines suppressed is logged in a line with
+	// the message "<message> (repeated <n> times)" when the next line is
+	// logged.
+	DedupeWindow time.Duration
+
+	// DuplicateBuiltinPolicy is what is done with fields, default fields
+	// included, whose keys are the keys written by the logger itself:
+	// timestamp, level, message, caller and func. Defaults to
+	// DuplicateBuiltinPrefix. It doesn't apply to CSVFormat, which writes
+	// them in columns of their own.
+	DuplicateBuiltinPolicy DuplicateBuiltinPolicy
+
+	// Hooks are fired in order with every line that is logged. They can
+	// change the fields of the line or drop it.
+	Hooks []Hook
+
+	// VerboseValues formats values that have no encoder of their own with
+	// %+v instead of %v, eg: struct={A:1} instead of struct={1}, and errors
+	// that implement fmt.Formatter, such as github.com/pkg/errors, with %+v,
+	// which includes their stack trace. It is meant for debugging and
+	// doesn't affect values of built-in types.
+	VerboseValues bool
+
+	// MaxFieldValueLen, if > 0, truncates string and []byte field values,
+	// and values formatted with fmt, in logfmt to that many bytes, followed
+	// by the number of bytes cut off, eg: …(truncated 10432 bytes). Values
+	// are truncated before they are escaped and quoted.
+	MaxFieldValueLen int
+
+	// MaxMessageLen, if > 0, truncates the message to that many bytes,
+	// followed by an ellipsis (…).
+	MaxMessageLen int
+
+	// MaxFields, if > 0, is the number of fields, default fields included,
+	// written per line. The rest are dropped and counted in a last
+	// _truncated_fields=<n> field. It doesn't apply to CSVFormat.
+	MaxFields int
+
+	// Redact, if set, is called with every field, default fields included,
+	// before it is written. The value it returns is written instead of the
+	// original, eg: Redacted to mask sensitive values.
+	Redact func(key string, val interface{}) interface{}
+
+	// RedactKeys are the keys, matched exactly but case-insensitively,
+	// of fields whose values are written as Redacted, eg: password,
+	// authorization. They apply to default fields too, and before Redact.
+	RedactKeys []string
+
+	// TimestampKey, LevelKey, MessageKey and CallerKey are the keys of
+	// the fields written by the logger itself, eg: ts, lvl, msg. Empty
+	// keys default to timestamp, level, message and caller.
+	TimestampKey string
+	LevelKey     string
+	MessageKey   string
+	CallerKey    string
+
+	// ScopeKey is the field key for the names of loggers created
+	// with Named. Defaults to "scope".
+	ScopeKey string
+
+	// ScopeSeparator joins the names of nested Named loggers.
+	// Defaults to ".".
+	ScopeSeparator string
+
+	// These fields will be printed with every log. A map[string]interface{}
+	// in place of a key is expanded into its entries, as with Map.
+	// Values that change between lines, eg: a request count, can be given
+	// as a Lazy or a func() interface{}, which is called for every line
+	// that is written. Other values are encoded once, when they are set,
+	// so they have to be changed with SetDefaultFields or With rather
+	// than in place.
 	DefaultFields []interface{}
+
+	// LevelFields are fields written by the lines of a level only, after
+	// the default fields, eg: {ErrorLevel: {"alert", true}}. Like
+	// DefaultFields, a map[string]interface{} in place of a key is expanded
+	// into its entries and the values can be Lazy. A Namespace opened in
+	// them doesn't apply to the per-call fields.
+	LevelFields map[Level][]interface{}
 }
 
 // Logger is the interface for all log operations related to emitting logs.
 type Logger struct {
 	// Output destination.
-	out io.Writer
+	out *syncWriter
+
+	// Pool of log line buffers of InitialBufSize.
+	pool *byteBufferPool
+
+	// Rate limiter of the levels in Opts.SampleRate, if any.
+	sampler *sampler
+
+	// Suppressor of repeated lines if Opts.DedupeWindow is set.
+	deduper *deduper
+
+	// Keys of the fields written by the logger itself.
+	keys *logKeys
 	Opts
 }
 
+// valueOpts are the options that field values are written with. Values
+// are written with a copy of them rather than with the Logger, as the
+// objectEncoders that ObjectMarshalers are passed hold one, and holding
+// the Logger would move it to the heap for every line with fields.
+type valueOpts struct {
+	fieldTimeFormat     string
+	durationFormat      DurationFormat
+	sliceFormat         SliceFormat
+	bytesEncoding       BytesEncoding
+	maxFieldValueLen    int
+	decimalPointers     bool
+	zeroTimeEmpty       bool
+	reflectFields       bool
+	dereferencePointers bool
+	verboseValues       bool
+
+	// derefs is the number of pointers dereferenced to get to the value
+	// being written, which is bounded as pointers may form a cycle, eg:
+	// var x interface{}; x = &x.
+	derefs int
+}
+
+// Logfer is the set of logging methods of Logger. Libraries that take
+// a logger should accept a Logfer instead of a Logger so that callers
+// can pass in a Logger or a mock of their own in tests.
+type Logfer interface {
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Warn(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+	Fatal(msg string, fields ...interface{})
+}
+
+var _ Logfer = Logger{}
+
 var (
 	hex     = "0123456789abcdef"
 	bufPool byteBufferPool
@@ -75,6 +503,15 @@ var (
 		ErrorLevel: red,
 		FatalLevel: red,
 	}
+
+	// Map bracketed letters with log level for compact output.
+	compactLvlMap = [...]string{
+		DebugLevel: "[D]",
+		InfoLevel:  "[I]",
+		WarnLevel:  "[W]",
+		ErrorLevel: "[E]",
+		FatalLevel: "[F]",
+	}
 )
 
 // New instantiates a logger object.
@@ -84,32 +521,160 @@ func New(opts Opts) Logger {
 		opts.Writer = os.Stderr
 	}
 	if opts.TimestampFormat == "" {
-		opts.TimestampFormat = defaultTSFormat
+		if opts.Compact {
+			opts.TimestampFormat = compactTSFormat
+		} else {
+			opts.TimestampFormat = defaultTSFormat
+		}
+	}
+	// Never emit ANSI codes if NO_COLOR is set (https://no-color.org/).
+	if os.Getenv("NO_COLOR") != "" {
+		opts.EnableColor = false
+	}
+	for lvl, c := range opts.LevelColors {
+		if c == "" {
+			opts.LevelColors[lvl] = colorLvlMap[lvl]
+			continue
+		}
+		if !strings.HasPrefix(c, "\033[") || !strings.HasSuffix(c, "m") {
+			stdlog.Printf("logf: color %q for level %v is not an ANSI escape sequence", c, Level(lvl))
+		}
+	}
+	if opts.FieldTimeFormat == "" {
+		opts.FieldTimeFormat = opts.TimestampFormat
+	}
+	if opts.AtomicLevel.lvl != nil {
+		opts.Level = opts.AtomicLevel.Level()
 	}
 	if opts.Level == 0 {
 		opts.Level = InfoLevel
 	}
+	if opts.AtomicLevel.lvl == nil {
+		opts.AtomicLevel = NewAtomicLevel(opts.Level)
+	}
 	if opts.CallerSkipFrameCount == 0 {
 		opts.CallerSkipFrameCount = 3
 	}
-	if len(opts.DefaultFields)%2 != 0 {
-		opts.DefaultFields = opts.DefaultFields[0 : len(opts.DefaultFields)-1]
+	if opts.InitialBufSize <= 0 {
+		opts.InitialBufSize = defaultBufSize
+	}
+	if opts.MaxBufSize <= 0 {
+		opts.MaxBufSize = defaultMaxBufSize
+	}
+	if opts.ScopeKey == "" {
+		opts.ScopeKey = "scope"
+	}
+	if opts.ScopeSeparator == "" {
+		opts.ScopeSeparator = "."
+	}
+	if g, ok := opts.Writer.(*GELFWriter); ok {
+		if opts.Format != GELFFormat {
+			stdlog.Printf("logf: GELFWriter needs GELFFormat, using it")
+			opts.Format = GELFFormat
+		}
+		if opts.GELFHost == "" {
+			opts.GELFHost = g.host
+		}
+	}
+	if opts.Format == GELFFormat {
+		if opts.TimestampKey != "" || opts.LevelKey != "" || opts.MessageKey != "" {
+			stdlog.Printf("logf: GELFFormat doesn't support TimestampKey, LevelKey and MessageKey, ignoring them")
+			opts.TimestampKey, opts.LevelKey, opts.MessageKey = "", "", ""
+		}
+		if opts.GELFHost == "" {
+			opts.GELFHost, _ = os.Hostname()
+		}
+		if opts.LineEnding == "" {
+			opts.LineEnding = LineEndingNUL
+		}
+	}
+	keys := newLogKeys(opts)
+	opts.DefaultFields = expandMaps(opts.DefaultFields)
+	if err := validateFields(opts.DefaultFields, keys.names); err != nil {
+		stdlog.Printf("logf: default fields: %v", err)
+	}
+	opts.DefaultFields = fixDanglingKey(opts.DefaultFields)
+	opts.DefaultFields = fixBuiltinKeys(opts.DefaultFields, opts.DuplicateBuiltinPolicy, keys.names)
+	if opts.LevelFields != nil {
+		// The map is copied so that the caller's isn't modified.
+		levelFields := make(map[Level][]interface{}, len(opts.LevelFields))
+		for lvl, fields := range opts.LevelFields {
+			fields = expandMaps(fields)
+			if err := validateFields(fields, keys.names); err != nil {
+				stdlog.Printf("logf: %v fields: %v", lvl, err)
+			}
+			fields = fixDanglingKey(fields)
+			levelFields[lvl] = fixBuiltinKeys(fields, opts.DuplicateBuiltinPolicy, keys.names)
+		}
+		opts.LevelFields = levelFields
+	}
+	switch opts.LineEnding {
+	case LineEndingLF, LineEndingCRLF, LineEndingNUL:
+	case "":
+		opts.LineEnding = LineEndingLF
+	default:
+		stdlog.Printf("logf: invalid line ending %q, using %q", opts.LineEnding, LineEndingLF)
+		opts.LineEnding = LineEndingLF
+	}
+
+	l := Logger{
+		out:     newSyncWriter(opts.Writer),
+		pool:    &byteBufferPool{size: opts.InitialBufSize, maxSize: opts.MaxBufSize},
+		sampler: newSampler(opts.SampleRate),
+		deduper: newDeduper(opts.DedupeWindow),
+		keys:    keys,
+		Opts:    opts,
+	}
+	keys.defaults = l.encodeFields(opts.DefaultFields)
+	for lvl := DebugLevel; lvl <= FatalLevel; lvl++ {
+		keys.levelFields[lvl] = l.encodeFields(opts.LevelFields[lvl])
+	}
+
+	return l
+}
+
+// NewStrict is New that returns an error instead of logging a warning if
+// DefaultFields or LevelFields is invalid: if a value has no key, a key
+// isn't a string or a fmt.Stringer or a key is one of the keys written by
+// the logger itself, eg: message.
+func NewStrict(opts Opts) (Logger, error) {
+	names := builtinKeyNames(opts)
+	if err := validateFields(expandMaps(opts.DefaultFields), names); err != nil {
+		return Logger{}, fmt.Errorf("default fields: %v", err)
 	}
+	for lvl, fields := range opts.LevelFields {
+		if err := validateFields(expandMaps(fields), names); err != nil {
+			return Logger{}, fmt.Errorf("%v fields: %v", lvl, err)
+		}
+	}
+
+	return New(opts), nil
+}
 
-	return Logger{
-		out:  newSyncWriter(opts.Writer),
-		Opts: opts,
+// IsTerminal returns true if w is a terminal. It can be used to enable
+// colors only for interactive output, eg: EnableColor: logf.IsTerminal(os.Stderr).
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		return false
 	}
+
+	return st.Mode()&os.ModeCharDevice != 0
 }
 
 // newSyncWriter wraps an io.Writer with syncWriter. It can
 // be used as an io.Writer as syncWriter satisfies the io.Writer interface.
 func newSyncWriter(in io.Writer) *syncWriter {
 	if in == nil {
-		return &syncWriter{w: os.Stderr}
+		in = os.Stderr
 	}
 
-	return &syncWriter{w: in}
+	return &syncWriter{Mutex: &sync.Mutex{}, w: in}
 }
 
 // Write synchronously to the underlying io.Writer.
@@ -120,6 +685,63 @@ func (w *syncWriter) Write(p []byte) (int, error) {
 	return n, err
 }
 
+// Replace swaps the underlying io.Writer once the write in progress,
+// if any, is complete.
+func (w *syncWriter) Replace(in io.Writer) {
+	if in == nil {
+		in = os.Stderr
+	}
+
+	w.Lock()
+	w.w = in
+	w.Unlock()
+}
+
+// Flush flushes the underlying io.Writer if it is a Flusher. The lock
+// isn't held while flushing so that lines can be logged meanwhile.
+func (w *syncWriter) Flush() error {
+	w.Lock()
+	f, ok := w.w.(Flusher)
+	w.Unlock()
+	if !ok {
+		return nil
+	}
+
+	return f.Flush()
+}
+
+// Close closes the underlying io.Writer if it is an io.Closer other
+// than os.Stdout or os.Stderr.
+func (w *syncWriter) Close() error {
+	w.Lock()
+	c, ok := w.w.(io.Closer)
+	w.Unlock()
+	if !ok || c == os.Stdout || c == os.Stderr {
+		return nil
+	}
+
+	return c.Close()
+}
+
+// WriteLevel synchronously writes a line of the given level to the underlying
+// io.Writer, passing the level on if it is a LevelWriter.
+func (w *syncWriter) WriteLevel(lvl Level, p []byte) error {
+	var err error
+	w.Lock()
+	if lw, ok := w.w.(LevelWriter); ok {
+		err = lw.WriteLevel(lvl, p)
+	} else {
+		_, err = w.w.Write(p)
+	}
+	w.Unlock()
+	return err
+}
+
+// isJSON returns true if the format writes entries as JSON objects.
+func (f Format) isJSON() bool {
+	return f == ECSFormat || f == GCPFormat || f == DatadogFormat || f == GELFFormat
+}
+
 // String representation of the log severity.
 func (l Level) String() string {
 	switch l {
@@ -133,201 +755,885 @@ func (l Level) String() string {
 		return "error"
 	case FatalLevel:
 		return "fatal"
+	case OffLevel:
+		return "off"
 	default:
 		return "invalid lvl"
 	}
 }
 
+// LevelFromString returns the level named by lvl, ignoring case,
+// eg: "INFO" or "Info". "warning" is accepted as an alias of "warn", as
+// used by syslog, and "off" returns OffLevel.
 func LevelFromString(lvl string) (Level, error) {
-	switch lvl {
+	switch strings.ToLower(lvl) {
 	case "debug":
 		return DebugLevel, nil
 	case "info":
 		return InfoLevel, nil
-	case "warn":
+	case "warn", "warning":
 		return WarnLevel, nil
 	case "error":
 		return ErrorLevel, nil
 	case "fatal":
 		return FatalLevel, nil
+	case "off":
+		return OffLevel, nil
 	default:
 		return 0, fmt.Errorf("invalid level")
 	}
 }
 
+// AtomicLevel is a level that can be safely changed while it is being read
+// by loggers. Copies of an AtomicLevel share the same level.
+type AtomicLevel struct {
+	lvl *int32
+}
+
+// NewAtomicLevel returns an AtomicLevel set to initial. It can be set as
+// Opts.AtomicLevel of multiple loggers to change their levels together.
+func NewAtomicLevel(initial Level) AtomicLevel {
+	lvl := int32(initial)
+	return AtomicLevel{lvl: &lvl}
+}
+
+// Level returns the current level.
+func (a AtomicLevel) Level() Level {
+	return Level(atomic.LoadInt32(a.lvl))
+}
+
+// SetLevel changes the level.
+func (a AtomicLevel) SetLevel(lvl Level) {
+	atomic.StoreInt32(a.lvl, int32(lvl))
+}
+
+// Discard returns a logger that discards every log line
+// before formatting it, for tests and no-op scenarios.
+func Discard() Logger {
+	return New(Opts{Writer: io.Discard, Level: OffLevel})
+}
+
+// Named returns a copy of the logger with name appended to its scope field,
+// eg: l.Named("db").Named("pool") logs scope=db.pool.
+func (l Logger) Named(name string) Logger {
+	// Copy the fields so that the parent logger is not modified.
+	fields := make([]interface{}, len(l.DefaultFields), len(l.DefaultFields)+2)
+	copy(fields, l.DefaultFields)
+	l.DefaultFields = fields
+
+	for i := 0; i+1 < len(fields); i += 2 {
+		if k, ok := fields[i].(string); !ok || k != l.Opts.ScopeKey {
+			continue
+		}
+		// A scope that isn't a string, eg: set with With, is joined as
+		// its string so that the key isn't repeated.
+		scope, ok := fields[i+1].(string)
+		if !ok {
+			scope = fmt.Sprint(fields[i+1])
+		}
+		fields[i+1] = scope + l.Opts.ScopeSeparator + name
+		l.keys = l.keys.withDefaults(l.encodeFields(l.DefaultFields))
+		return l
+	}
+
+	l.DefaultFields = append(fields, l.Opts.ScopeKey, name)
+	l.keys = l.keys.withDefaults(l.encodeFields(l.DefaultFields))
+	return l
+}
+
+// With returns a copy of the logger with fields added to its default fields.
+func (l Logger) With(fields ...interface{}) Logger {
+	// If the last key has no value, it is written as !BADKEY=<value>.
+	fields = fixDanglingKey(fields)
+	fields = fixBuiltinKeys(fields, l.Opts.DuplicateBuiltinPolicy, l.keys.names)
+
+	// Copy the fields so that the parent logger is not modified.
+	f := make([]interface{}, 0, len(l.DefaultFields)+len(fields))
+	f = append(f, l.DefaultFields...)
+	l.DefaultFields = append(f, fields...)
+	l.keys = l.keys.withDefaults(l.encodeFields(l.DefaultFields))
+	return l
+}
+
+// SetDefaultFields replaces the default fields of the logger and encodes
+// them again. Loggers derived from it with With or Named keep theirs.
+func (l *Logger) SetDefaultFields(fields ...interface{}) {
+	// Copy the fields so that the caller's slice isn't encoded.
+	fields = fixDanglingKey(append([]interface{}(nil), fields...))
+	fields = fixBuiltinKeys(fields, l.Opts.DuplicateBuiltinPolicy, l.keys.names)
+	l.DefaultFields = fields
+	l.keys = l.keys.withDefaults(l.encodeFields(l.DefaultFields))
+}
+
+// Clone returns a copy of the logger that shares no state with it. Unlike
+// the loggers returned by With and Named, which share the writer of their
+// parent, SetWriter and SetLevel on a clone don't affect the parent and
+// vice versa.
+// Use With to add fields and Clone to get a logger whose writer can be
+// changed independently. The clone writes to the same io.Writer until
+// SetWriter is called. Writes of the clone and the parent are serialized
+// with the same lock, so that an io.Writer that isn't safe for concurrent
+// use can be shared between them.
+func (l Logger) Clone() Logger {
+	if l.DefaultFields != nil {
+		fields := make([]interface{}, len(l.DefaultFields))
+		copy(fields, l.DefaultFields)
+		l.DefaultFields = fields
+		l.keys = l.keys.withDefaults(l.encodeFields(l.DefaultFields))
+	}
+	if l.Opts.CSVColumns != nil {
+		l.Opts.CSVColumns = append([]string(nil), l.Opts.CSVColumns...)
+	}
+	if l.Opts.LevelFields != nil {
+		levelFields := make(map[Level][]interface{}, len(l.Opts.LevelFields))
+		for lvl, fields := range l.Opts.LevelFields {
+			levelFields[lvl] = append([]interface{}(nil), fields...)
+		}
+		l.Opts.LevelFields = levelFields
+	}
+	l.Opts.Level = l.GetLevel()
+	l.Opts.AtomicLevel = NewAtomicLevel(l.Opts.Level)
+	if l.sampler != nil {
+		l.sampler = newSampler(l.Opts.SampleRate)
+	}
+	if l.deduper != nil {
+		l.deduper = newDeduper(l.Opts.DedupeWindow)
+	}
+
+	l.out.Lock()
+	w := l.out.w
+	l.out.Unlock()
+	l.out = &syncWriter{Mutex: l.out.Mutex, w: w}
+
+	return l
+}
+
+// SetWriter swaps the writer that log lines are written to, eg: to reopen
+// a log file on SIGHUP. Lines being written finish writing to the old
+// writer. Loggers derived from l with With or Named share its writer and
+// write to the new one too.
+func (l *Logger) SetWriter(w io.Writer) {
+	if w == nil {
+		w = os.Stderr
+	}
+
+	l.out.Replace(w)
+	l.Opts.Writer = w
+}
+
+// Flusher is implemented by writers that buffer log lines, eg: AsyncWriter.
+type Flusher interface {
+	Flush() error
+}
+
+// Flush writes out the lines buffered by the writer if it is a Flusher,
+// eg: an AsyncWriter, and returns nil otherwise. It is called before Fatal
+// exits. Programs that log to a buffered writer should call Flush, or
+// Close, before they exit, eg:
+//
+//	defer l.Close()
+func (l Logger) Flush() error {
+	return l.out.Flush()
+}
+
+// Close closes the writer if it is an io.Closer, eg: a RollingFileWriter,
+// and returns nil otherwise. os.Stdout and os.Stderr aren't closed. Lines
+// logged after Close go to the closed writer.
+func (l Logger) Close() error {
+	return l.out.Close()
+}
+
+// IsEnabled returns true if a log line of the given level would be emitted.
+// It can be used to skip building expensive fields for discarded levels.
+func (l Logger) IsEnabled(lvl Level) bool {
+	return lvl >= l.Opts.AtomicLevel.Level()
+}
+
+// SetLevel changes the level of the logger. It is safe to call while
+// logging from other goroutines and affects the loggers derived from l
+// with With and Named, which share its level. Opts.Level keeps the
+// initial level, so use GetLevel to read the current one.
+func (l Logger) SetLevel(lvl Level) {
+	l.Opts.AtomicLevel.SetLevel(lvl)
+}
+
+// GetLevel returns the current level of the logger.
+func (l Logger) GetLevel() Level {
+	return l.Opts.AtomicLevel.Level()
+}
+
+// LazyField returns a field value that is computed by calling fn only if
+// the log line is emitted. It is useful for values that are expensive
+// to build. It is the same as Lazy(fn).
+func LazyField(fn func() interface{}) interface{} {
+	return Lazy(fn)
+}
+
 // Debug emits a debug log line.
 func (l Logger) Debug(msg string, fields ...interface{}) {
-	l.handleLog(msg, DebugLevel, fields...)
+	l.handleLog(msg, DebugLevel, fields, nil)
 }
 
 // Info emits a info log line.
 func (l Logger) Info(msg string, fields ...interface{}) {
-	l.handleLog(msg, InfoLevel, fields...)
+	l.handleLog(msg, InfoLevel, fields, nil)
 }
 
 // Warn emits a warning log line.
 func (l Logger) Warn(msg string, fields ...interface{}) {
-	l.handleLog(msg, WarnLevel, fields...)
+	l.handleLog(msg, WarnLevel, fields, nil)
 }
 
 // Error emits an error log line.
 func (l Logger) Error(msg string, fields ...interface{}) {
-	l.handleLog(msg, ErrorLevel, fields...)
+	l.handleLog(msg, ErrorLevel, fields, nil)
 }
 
 // Fatal emits a fatal level log line.
 // It aborts the current program with an exit code of 1.
 func (l Logger) Fatal(msg string, fields ...interface{}) {
-	l.handleLog(msg, FatalLevel, fields...)
+	l.handleLog(msg, FatalLevel, fields, nil)
+	l.Flush()
+	exit()
+}
+
+// DebugFunc emits a debug log line with the message returned by fn.
+// fn is only called if the debug level is enabled.
+func (l Logger) DebugFunc(fn func() string, fields ...interface{}) {
+	if l.IsEnabled(DebugLevel) {
+		l.handleLog(fn(), DebugLevel, fields, nil)
+	}
+}
+
+// InfoFunc emits a info log line with the message returned by fn.
+// fn is only called if the info level is enabled.
+func (l Logger) InfoFunc(fn func() string, fields ...interface{}) {
+	if l.IsEnabled(InfoLevel) {
+		l.handleLog(fn(), InfoLevel, fields, nil)
+	}
+}
+
+// WarnFunc emits a warning log line with the message returned by fn.
+// fn is only called if the warn level is enabled.
+func (l Logger) WarnFunc(fn func() string, fields ...interface{}) {
+	if l.IsEnabled(WarnLevel) {
+		l.handleLog(fn(), WarnLevel, fields, nil)
+	}
+}
+
+// ErrorFunc emits an error log line with the message returned by fn.
+// fn is only called if the error level is enabled.
+func (l Logger) ErrorFunc(fn func() string, fields ...interface{}) {
+	if l.IsEnabled(ErrorLevel) {
+		l.handleLog(fn(), ErrorLevel, fields, nil)
+	}
+}
+
+// FatalFunc emits a fatal level log line with the message returned by fn.
+// It aborts the current program with an exit code of 1.
+func (l Logger) FatalFunc(fn func() string, fields ...interface{}) {
+	if l.IsEnabled(FatalLevel) {
+		l.handleLog(fn(), FatalLevel, fields, nil)
+	}
+	l.Flush()
 	exit()
 }
 
 // handleLog emits the log after filtering log level
-// and applying formatting of the fields.
-func (l Logger) handleLog(msg string, lvl Level, fields ...interface{}) {
+// and applying formatting of the fields and the typed fields.
+func (l Logger) handleLog(msg string, lvl Level, fields []interface{}, typed []Field) {
 	// Discard the log if the verbosity is higher.
 	// For eg, if the lvl is `3` (error), but the incoming message is `0` (debug), skip it.
-	if lvl < l.Opts.Level {
+	if !l.IsEnabled(lvl) {
 		return
 	}
 
-	// Get a buffer from the pool.
-	buf := bufPool.Get()
-
-	// Write fixed keys to the buffer before writing user provided ones.
-	writeTimeToBuf(buf, l.Opts.TimestampFormat, lvl, l.Opts.EnableColor)
-	writeToBuf(buf, "level", lvl, lvl, l.Opts.EnableColor, true)
-	writeStringToBuf(buf, "message", msg, lvl, l.Opts.EnableColor, true)
-
-	if l.Opts.EnableCaller {
-		writeCallerToBuf(buf, "caller", l.Opts.CallerSkipFrameCount, lvl, l.EnableColor, true)
+	if len(l.Opts.Hooks) > 0 {
+		var ok bool
+		if fields, ok = l.fireHooks(lvl, msg, fields, typed); !ok {
+			return
+		}
+		typed = nil
 	}
 
-	// Format the line as logfmt.
+	// skip is set if the line isn't to be written, but the repeats of
+	// other lines are.
 	var (
-		count      int // to find out if this is the last key in while itering fields.
-		fieldCount = len(l.DefaultFields) + len(fields)
-		key        string
+		repeats []repeatedLine
+		skip    bool
 	)
-
-	// If there are odd number of fields, ignore the last.
-	if fieldCount%2 != 0 {
-		fields = fields[0 : len(fields)-1]
+	if l.deduper != nil {
+		var ok bool
+		repeats, ok = l.deduper.check(lvl, msg)
+		if !ok && len(repeats) == 0 {
+			return
+		}
+		skip = !ok
 	}
 
-	for i := range l.DefaultFields {
-		space := false
-		if count != fieldCount-1 {
-			space = true
+	var dropped int64
+	if l.sampler != nil && !skip {
+		var ok bool
+		if dropped, ok = l.sampler.allow(lvl); !ok {
+			if len(repeats) == 0 {
+				return
+			}
+			skip = true
 		}
+	}
 
-		if i%2 == 0 {
-			key = l.DefaultFields[i].(string)
-			continue
-		}
+	msg = truncate(msg, l.Opts.MaxMessageLen)
 
-		writeToBuf(buf, key, l.DefaultFields[i], lvl, l.Opts.EnableColor, space)
-		count++
+	var (
+		fn, file string
+		line     int
+	)
+	if l.Opts.EnableCaller || l.Opts.EnableCallerFunc {
+		fn, file, line = caller(l.Opts.CallerSkipFrameCount, l.Opts.EnableCallerFunc)
 	}
 
-	for i := range fields {
-		space := false
-		if count != fieldCount-1 {
-			space = true
+	// Lines without fields, other than encoded default and level fields, are
+	// written by writeMessageEntry, which appends the encoded fields as is.
+	if len(repeats) == 0 && dropped == 0 && len(fields) == 0 && len(typed) == 0 &&
+		l.Opts.Format == LogfmtFormat {
+		defaults, ok := l.staticFields(l.DefaultFields, l.keys.defaults, lvl)
+		if ok {
+			var levelFields []byte
+			if levelFields, ok = l.staticFields(l.levelFields(lvl), l.keys.levelFields[lvl], lvl); ok {
+				l.writeMessageEntry(msg, lvl, fn, file, line, defaults, levelFields)
+				return
+			}
 		}
+	}
+	for _, r := range repeats {
+		l.writeEntry(truncate(r.message(), l.Opts.MaxMessageLen), r.lvl, fn, file, line, nil, nil)
+	}
+	if skip {
+		return
+	}
 
-		if i%2 == 0 {
-			key = fields[i].(string)
-			continue
-		}
+	// Report the lines dropped by sampling before the first one logged.
+	if dropped > 0 {
+		l.writeEntry(strconv.FormatInt(dropped, 10)+" "+lvl.String()+" messages dropped", lvl, fn, file, line, nil, nil)
+	}
+
+	l.writeEntry(msg, lvl, fn, file, line, fields, typed)
+}
 
-		writeToBuf(buf, key, fields[i], lvl, l.Opts.EnableColor, space)
-		count++
+// writeEntry writes a log line in the configured format to the writer.
+func (l *Logger) writeEntry(msg string, lvl Level, fn, file string, line int, fields []interface{}, typed []Field) {
+	// Get a buffer from the pool.
+	buf := l.pool.Get()
+
+	switch l.Opts.Format {
+	case MsgpackFormat:
+		l.writeMsgpackEntry(buf, msg, lvl, fn, file, line, fields, typed)
+	case CSVFormat:
+		l.writeCSVEntry(buf, msg, lvl, fn, file, line, fields, typed)
+	case ECSFormat, GCPFormat, DatadogFormat:
+		l.writeJSONEntry(buf, msg, lvl, fn, file, line, fields, typed)
+	case GELFFormat:
+		l.writeGELFEntry(buf, msg, lvl, fn, file, line, fields, typed)
+	default:
+		l.writeLogfmtEntry(buf, msg, lvl, fn, file, line, fields, typed)
 	}
 
-	buf.AppendString("\n")
+	l.writeBuf(buf, lvl)
+}
+
+// writeMessageEntry writes a logfmt line without fields, other than the
+// encoded default and level fields, to the writer.
+func (l *Logger) writeMessageEntry(msg string, lvl Level, fn, file string, line int, defaults, levelFields []byte) {
+	buf := l.pool.Get()
+	l.writeLogfmtHeader(buf, msg, lvl, fn, file, line)
+	buf.B = append(buf.B, defaults...)
+	buf.B = append(buf.B, levelFields...)
+	buf.AppendString(l.Opts.LineEnding)
+	l.writeBuf(buf, lvl)
+}
 
-	_, err := l.out.Write(buf.Bytes())
+// writeBuf writes a line to the writer and puts the buffer back in the pool.
+func (l *Logger) writeBuf(buf *byteBuffer, lvl Level) {
+	err := l.out.WriteLevel(lvl, buf.Bytes())
 	if err != nil {
 		// Should ideally never happen.
 		stdlog.Printf("error logging: %v", err)
 	}
 
 	// Put the writer back in the pool. It resets the underlying byte buffer.
-	bufPool.Put(buf)
+	l.pool.Put(buf)
 }
 
-// writeTimeToBuf writes timestamp key + timestamp into buffer.
-func writeTimeToBuf(buf *byteBuffer, format string, lvl Level, color bool) {
-	if color {
-		buf.AppendString(getColoredKey(tsKey, lvl))
-	} else {
-		buf.AppendString(tsKey)
+// caller returns the function name, file and line of the caller at the
+// given depth. The depth is counted from the function that invoked caller
+// (handleLog). The function name is only looked up if withFunc is set.
+func caller(depth int, withFunc bool) (string, string, int) {
+	pc, file, line, ok := runtime.Caller(depth)
+	if !ok {
+		return "???", "???", 0
 	}
 
-	buf.AppendTime(time.Now(), format)
-	buf.AppendByte(' ')
+	if !withFunc {
+		return "", file, line
+	}
+
+	fn := "???"
+	if f := runtime.FuncForPC(pc); f != nil {
+		// Trim the package path, eg: github.com/x/pkg.Func => pkg.Func
+		fn = f.Name()
+		if i := strings.LastIndexByte(fn, '/'); i != -1 {
+			fn = fn[i+1:]
+		}
+	}
+
+	return fn, file, line
+}
+
+// writeLogfmtEntry writes a complete log line in logfmt into the buffer.
+func (l *Logger) writeLogfmtEntry(buf *byteBuffer, msg string, lvl Level, fn, file string, line int, fields []interface{}, typed []Field) {
+	l.writeLogfmtHeader(buf, msg, lvl, fn, file, line)
+	l.writeFields(buf, lvl, fields, typed)
+	buf.AppendString(l.Opts.LineEnding)
 }
 
-// writeStringToBuf takes key, value and additional options to write to the buffer in logfmt.
-func writeStringToBuf(buf *byteBuffer, key, val string, lvl Level, color, space bool) {
-	if color {
-		escapeAndWriteString(buf, getColoredKey(key, lvl))
+// writeLogfmtHeader writes the timestamp, level, message and caller of
+// a logfmt line into the buffer.
+func (l *Logger) writeLogfmtHeader(buf *byteBuffer, msg string, lvl Level, fn, file string, line int) {
+	// Write fixed keys to the buffer before writing user provided ones.
+	if l.Opts.Compact {
+		l.writeCompactPrefixToBuf(buf, lvl)
 	} else {
-		escapeAndWriteString(buf, key)
+		l.writeTimeToBuf(buf, lvl)
+		l.writeLogKeyToBuf(buf, levelKey, lvl)
+		buf.AppendString(lvl.String())
 	}
+	l.writeLogKeyToBuf(buf, messageKey, lvl)
+	escapeAndWriteString(buf, msg)
 
-	buf.AppendByte('=')
-	escapeAndWriteString(buf, val)
-
-	if space {
-		buf.AppendByte(' ')
+	if l.Opts.EnableCaller {
+		l.writeCallerToBuf(buf, file, line, lvl)
+	}
+	if l.Opts.EnableCallerFunc {
+		l.writeLogKeyToBuf(buf, funcKey, lvl)
+		escapeAndWriteString(buf, fn)
 	}
 }
 
-func writeCallerToBuf(buf *byteBuffer, key string, depth int, lvl Level, color, space bool) {
-	_, file, line, ok := runtime.Caller(depth)
-	if !ok {
-		file = "???"
-		line = 0
+// writeFields writes the default and level fields followed by the given
+// fields and typed fields into the buffer in the configured format and returns the
+// number of key/value pairs written.
+func (l *Logger) writeFields(buf *byteBuffer, lvl Level, fields []interface{}, typed []Field) int {
+	var (
+		count int
+		// ns is the key prefix of the open Namespace, if any.
+		ns string
+		// pending collects the fields to be sorted, deduplicated or capped
+		// with SortFields, DedupeKeys and MaxFields before they are written.
+		pending *pendingFields
+	)
+	if l.Opts.SortFields || l.Opts.DedupeKeys || l.Opts.MaxFields > 0 {
+		pending = pendingFieldsPool.Get().(*pendingFields)
+		defer pending.release()
 	}
 
-	if color {
-		buf.AppendString(getColoredKey(key, lvl))
+	// The keys of default fields are checked against the builtin keys
+	// when they are added. A namespace opened in the default fields
+	// applies to the per-call fields, wherever they are written.
+	if l.Opts.DefaultFieldsLast {
+		ns = lastNamespace(l.DefaultFields)
 	} else {
-		buf.AppendString(key)
+		count, ns = l.writeDefaultFields(buf, lvl, pending)
 	}
 
-	buf.AppendByte('=')
-	escapeAndWriteString(buf, file)
-	buf.AppendByte(':')
-	buf.AppendInt(int64(line))
+	n, ns := l.writeFieldList(buf, lvl, fields, ns, l.Opts.DuplicateBuiltinPolicy, pending)
+	count += n
+
+	for _, f := range typed {
+		if f.typ == namespaceType {
+			ns = f.str
+			continue
+		}
 
-	if space {
-		buf.AppendByte(' ')
+		var ok bool
+		if f.Key, ok = builtinKey(ns+f.Key, l.Opts.DuplicateBuiltinPolicy, l.keys.names); ok {
+			if pending != nil {
+				pending.f = append(pending.f, f)
+			} else {
+				count += l.writeTypedField(buf, f, lvl)
+			}
+		}
 	}
-}
 
-// writeToBuf takes key, value and additional options to write to the buffer in logfmt.
-func writeToBuf(buf *byteBuffer, key string, val interface{}, lvl Level, color, space bool) {
-	if color {
-		escapeAndWriteString(buf, getColoredKey(key, lvl))
-	} else {
-		escapeAndWriteString(buf, key)
+	if l.Opts.DefaultFieldsLast {
+		n, _ := l.writeDefaultFields(buf, lvl, pending)
+		count += n
 	}
 
-	buf.AppendByte('=')
+	if pending != nil {
+		if l.Opts.SortFields {
+			// Fields with the same key keep their order.
+			sort.Stable(pending)
+		}
 
-	switch v := val.(type) {
-	case nil:
-		buf.AppendString("null")
-	case []byte:
-		escapeAndWriteString(buf, string(v))
-	case string:
-		escapeAndWriteString(buf, v)
-	case int:
-		buf.AppendInt(int64(v))
-	case int8:
+		var written, skipped int
+		for i, f := range pending.f {
+			if l.Opts.DedupeKeys && pending.hasKeyAfter(i) {
+				continue
+			}
+			if max := l.Opts.MaxFields; max > 0 && written == max {
+				skipped++
+				continue
+			}
+
+			// Fields skipped by OmitEmpty don't count towards MaxFields.
+			if n := l.writeTypedField(buf, f, lvl); n > 0 {
+				count += n
+				written++
+			}
+		}
+		if skipped > 0 {
+			count += l.writeTypedField(buf, Int(truncatedFieldsKey, skipped), lvl)
+		}
+	}
+
+	return count
+}
+
+// writeDefaultFields writes the default fields and the fields of the level,
+// or adds them to pending if set, and returns the number of pairs written
+// and the namespace open at the end of the default fields.
+func (l *Logger) writeDefaultFields(buf *byteBuffer, lvl Level, pending *pendingFields) (int, string) {
+	count, ns := l.writeEncodedList(buf, lvl, l.DefaultFields, l.keys.defaults, pending)
+	if list := l.levelFields(lvl); len(list) > 0 {
+		n, _ := l.writeEncodedList(buf, lvl, list, l.keys.levelFields[lvl], pending)
+		count += n
+	}
+
+	return count, ns
+}
+
+// writeEncodedList writes a list of default fields whose encoding is e,
+// if any, like writeFieldList. Encoded pairs are appended as is. As every
+// logfmt key is preceded by its separator, they can be with or without
+// other fields.
+func (l *Logger) writeEncodedList(buf *byteBuffer, lvl Level, list []interface{}, e *encodedFields, pending *pendingFields) (int, string) {
+	if e = l.encoded(e, list); e == nil || pending != nil {
+		return l.writeFieldList(buf, lvl, list, "", DuplicateBuiltinAllow, pending)
+	}
+
+	var count int
+	for i := range e.parts {
+		p := &e.parts[i]
+		if p.perLine {
+			count += l.writeField(buf, p.key, list[p.index+1], lvl)
+			continue
+		}
+
+		buf.B = append(buf.B, e.bytes(p, lvl)...)
+		count += p.count
+	}
+
+	return count, ""
+}
+
+// levelFields returns the Opts.LevelFields of the level.
+func (l *Logger) levelFields(lvl Level) []interface{} {
+	if l.Opts.LevelFields == nil {
+		return nil
+	}
+	return l.Opts.LevelFields[lvl]
+}
+
+// writeFieldList writes a list of key/value pairs and typed fields with
+// their keys prefixed with the namespace ns, or adds them to pending if set,
+// and returns the number of pairs written and the namespace open at the end.
+func (l *Logger) writeFieldList(buf *byteBuffer, lvl Level, list []interface{}, ns string, policy DuplicateBuiltinPolicy, pending *pendingFields) (int, string) {
+	var count int
+	for i := 0; i < len(list); {
+		// Typed fields, eg: Err(err), can be mixed with key/value pairs.
+		if f, ok := list[i].(Field); ok {
+			if f.typ == namespaceType {
+				ns = f.str
+			} else if f.Key, ok = builtinKey(ns+f.Key, policy, l.keys.names); ok {
+				if pending != nil {
+					pending.f = append(pending.f, f)
+				} else {
+					count += l.writeTypedField(buf, f, lvl)
+				}
+			}
+			i++
+			continue
+		}
+
+		// If there are odd number of fields, write the last as
+		// !BADKEY=<value> so that it isn't lost.
+		if i+1 == len(list) {
+			if pending != nil {
+				pending.f = append(pending.f, Any(badKey, list[i]))
+			} else {
+				count += l.writeField(buf, badKey, list[i], lvl)
+			}
+			break
+		}
+
+		if key, ok := builtinKey(ns+fieldKey(list[i]), policy, l.keys.names); ok {
+			if pending != nil {
+				pending.f = append(pending.f, Any(key, list[i+1]))
+			} else {
+				count += l.writeField(buf, key, list[i+1], lvl)
+			}
+		}
+		i += 2
+	}
+
+	return count, ns
+}
+
+// writeField writes a single key/value pair in the configured format,
+// followed by the <key>_chain and <key>_stack pairs for errors if
+// ExpandErrors and ErrorStacktrace are set. It returns the number
+// of pairs written.
+func (l *Logger) writeField(buf *byteBuffer, key string, val interface{}, lvl Level) int {
+	val = l.fieldValue(key, val)
+	if l.Opts.OmitEmpty && isEmptyValue(val) {
+		return 0
+	}
+
+	// Objects are flattened into prefixed fields, except in JSON,
+	// where they are written as nested objects.
+	if om, ok := val.(ObjectMarshaler); ok && !isNilPointer(om) && !l.Opts.Format.isJSON() {
+		return l.writeObject(buf, key, om, lvl)
+	}
+
+	var (
+		chain, stack       string
+		hasChain, hasStack bool
+	)
+	if l.Opts.ExpandErrors {
+		chain, hasChain = errorChain(val)
+	}
+	if l.Opts.ErrorStacktrace {
+		stack, hasStack = errorStack(val)
+	}
+
+	n := 1
+	l.writeFieldValue(buf, key, val, lvl)
+	if hasChain {
+		k := key + "_chain"
+		l.writeFieldValue(buf, k, l.fieldValue(k, chain), lvl)
+		n++
+	}
+	if hasStack {
+		k := key + "_stack"
+		l.writeFieldValue(buf, k, l.fieldValue(k, stack), lvl)
+		n++
+	}
+
+	return n
+}
+
+// errorStack returns the stack trace carried by an error, or by the
+// first error in its chain that has one. Errors can carry a stack by
+// implementing Stack() []byte or StackTrace(), like github.com/pkg/errors.
+func errorStack(val interface{}) (string, bool) {
+	err, ok := val.(error)
+	for i := 0; ok && err != nil && !isNilPointer(err) && i < maxErrorChain; i++ {
+		if s, ok := err.(interface{ Stack() []byte }); ok {
+			return string(s.Stack()), true
+		}
+
+		// pkg/errors' StackTrace type can't be referred to without importing
+		// it, so the method is looked up with reflection and the frames are
+		// formatted with %+v.
+		m := reflect.ValueOf(err).MethodByName("StackTrace")
+		if m.IsValid() && m.Type().NumIn() == 0 && m.Type().NumOut() == 1 {
+			st := m.Call(nil)[0].Interface()
+			return strings.TrimPrefix(fmt.Sprintf("%+v", st), "\n"), true
+		}
+
+		err = errors.Unwrap(err)
+	}
+
+	return "", false
+}
+
+// writeFieldValue writes a key/value pair in the configured format.
+func (l *Logger) writeFieldValue(buf *byteBuffer, key string, val interface{}, lvl Level) {
+	switch l.Opts.Format {
+	case MsgpackFormat:
+		writeMsgpackString(buf, key)
+		o := l.values()
+		o.writeMsgpackValue(buf, val)
+	case ECSFormat, GCPFormat, DatadogFormat:
+		l.writeJSONField(buf, key, val, false)
+	case GELFFormat:
+		l.writeGELFField(buf, key, val)
+	default:
+		l.writeToBuf(buf, key, val, lvl)
+	}
+}
+
+// errorChain joins the messages of an error and the errors it wraps
+// with " <- ", up to maxErrorChain errors. It returns false if val
+// isn't an error that wraps another.
+func errorChain(val interface{}) (string, bool) {
+	err, ok := val.(error)
+	if !ok || isNilPointer(err) || errors.Unwrap(err) == nil {
+		return "", false
+	}
+
+	var sb strings.Builder
+	// The depth is bounded as an error chain may be a cycle.
+	for i := 0; err != nil && i < maxErrorChain; i++ {
+		if i > 0 {
+			sb.WriteString(" <- ")
+		}
+		sb.WriteString(textValue(err))
+
+		err = errors.Unwrap(err)
+		if isNilPointer(err) {
+			break
+		}
+	}
+
+	return sb.String(), true
+}
+
+// writeTimeToBuf writes timestamp key + timestamp into buffer.
+func (l *Logger) writeTimeToBuf(buf *byteBuffer, lvl Level) {
+	if l.Opts.EnableColor {
+		buf.AppendString(l.keys.colored[lvl][timestampKey])
+	} else {
+		buf.AppendString(l.keys.plain[timestampKey])
+	}
+
+	buf.AppendTime(time.Now(), l.Opts.TimestampFormat)
+}
+
+// writeCompactPrefixToBuf writes the bare timestamp and the bracketed
+// level letter into the buffer.
+func (l *Logger) writeCompactPrefixToBuf(buf *byteBuffer, lvl Level) {
+	buf.AppendTime(time.Now(), l.Opts.TimestampFormat)
+	buf.AppendByte(' ')
+
+	if l.Opts.EnableColor {
+		buf.AppendString(l.getColoredKey(compactLvlMap[lvl], lvl))
+	} else {
+		buf.AppendString(compactLvlMap[lvl])
+	}
+}
+
+// fieldValue returns the value to be written for a field, evaluating
+// lazy values and passing it through Opts.RedactKeys and Opts.Redact if set.
+func (l *Logger) fieldValue(key string, val interface{}) interface{} {
+	// Redacted values are never evaluated.
+	for _, k := range l.Opts.RedactKeys {
+		if strings.EqualFold(k, key) {
+			return Redacted
+		}
+	}
+
+	switch v := val.(type) {
+	case lazyVal:
+		val = v.Eval()
+	case func() interface{}:
+		val = Lazy(v).Eval()
+	}
+	if _, ok := val.(LogValuer); ok {
+		val = resolveLogValuer(val)
+	}
+
+	if l.Opts.Redact == nil {
+		return val
+	}
+
+	return l.Opts.Redact(key, val)
+}
+
+// writeCallerToBuf writes the caller's file:line into the buffer.
+func (l *Logger) writeCallerToBuf(buf *byteBuffer, file string, line int, lvl Level) {
+	l.writeLogKeyToBuf(buf, callerKey, lvl)
+
+	if l.Opts.CallerShortPath {
+		file = filepath.Base(file)
+	}
+
+	escapeAndWriteString(buf, file)
+	buf.AppendByte(':')
+	buf.AppendInt(int64(line))
+}
+
+// writeKeyToBuf writes a key followed by = into the buffer in logfmt. Every
+// key is preceded by the space that separates it from the previous field,
+// as the timestamp (or the compact prefix) always comes first.
+func (l *Logger) writeKeyToBuf(buf *byteBuffer, key string, lvl Level) {
+	writeColoredKey(buf, key, l.keyColor(lvl))
+}
+
+// writeColoredKey is writeKeyToBuf with the key wrapped in color, if set.
+func writeColoredKey(buf *byteBuffer, key, color string) {
+	buf.AppendByte(' ')
+	if color != "" {
+		// The colors wrap the key, quoted if need be, so that they don't
+		// end up inside the quotes.
+		buf.AppendString(color)
+		escapeAndWriteString(buf, key)
+		buf.AppendString(reset)
+	} else {
+		escapeAndWriteString(buf, key)
+	}
+
+	buf.AppendByte('=')
+}
+
+// keyColor returns the color of the keys of lines of the level, or an
+// empty string if colors are disabled.
+func (l *Logger) keyColor(lvl Level) string {
+	if !l.Opts.EnableColor {
+		return ""
+	}
+	return l.Opts.LevelColors[lvl]
+}
+
+// writeToBuf takes key, value and additional options to write to the buffer in logfmt.
+func (l *Logger) writeToBuf(buf *byteBuffer, key string, val interface{}, lvl Level) {
+	l.writeKeyToBuf(buf, key, lvl)
+	o := l.values()
+	o.writeValueToBuf(buf, val)
+}
+
+// values returns the options that field values are written with.
+func (l *Logger) values() valueOpts {
+	return valueOpts{
+		fieldTimeFormat:     l.Opts.FieldTimeFormat,
+		durationFormat:      l.Opts.DurationFormat,
+		sliceFormat:         l.Opts.SliceFormat,
+		bytesEncoding:       l.Opts.BytesEncoding,
+		maxFieldValueLen:    l.Opts.MaxFieldValueLen,
+		decimalPointers:     l.Opts.DecimalPointers,
+		zeroTimeEmpty:       l.Opts.ZeroTimeEmpty,
+		reflectFields:       l.Opts.ReflectFields,
+		dereferencePointers: l.Opts.DereferencePointers,
+		verboseValues:       l.Opts.VerboseValues,
+	}
+}
+
+// writeValueToBuf writes a field value to the buffer in logfmt.
+func (o *valueOpts) writeValueToBuf(buf *byteBuffer, val interface{}) {
+	switch v := val.(type) {
+	case nil:
+		buf.AppendString("null")
+	case []byte:
+		if o.bytesEncoding != BytesRaw {
+			o.writeEncodedBytes(buf, v, true)
+			break
+		}
+		escapeAndWriteString(buf, truncateValue(string(v), o.maxFieldValueLen))
+	case string:
+		escapeAndWriteString(buf, truncateValue(v, o.maxFieldValueLen))
+	case int:
+		buf.AppendInt(int64(v))
+	case int8:
 		buf.AppendInt(int64(v))
 	case int16:
 		buf.AppendInt(int64(v))
@@ -335,22 +1641,456 @@ func writeToBuf(buf *byteBuffer, key string, val interface{}, lvl Level, color,
 		buf.AppendInt(int64(v))
 	case int64:
 		buf.AppendInt(v)
+	case uint:
+		buf.AppendUint(uint64(v))
+	case uint8:
+		buf.AppendUint(uint64(v))
+	case uint16:
+		buf.AppendUint(uint64(v))
+	case uint32:
+		buf.AppendUint(uint64(v))
+	case uint64:
+		buf.AppendUint(v)
+	case uintptr:
+		o.writePointerValue(buf, v, false)
+	case unsafe.Pointer:
+		o.writePointerValue(buf, uintptr(v), false)
 	case float32:
 		buf.AppendFloat(float64(v), 32)
 	case float64:
 		buf.AppendFloat(v, 64)
+	case complex64:
+		buf.AppendComplex(complex128(v), 64)
+	case complex128:
+		buf.AppendComplex(v, 128)
 	case bool:
 		buf.AppendBool(v)
+	case time.Time:
+		o.writeTimeValue(buf, v)
+	case time.Duration:
+		o.writeDurationValue(buf, v)
+	case *string, *int, *int32, *int64, *uint32, *uint64, *float32, *float64, *bool, *time.Time:
+		o.writeValueToBuf(buf, derefBasic(v))
+	case *big.Int, *big.Float, *big.Rat:
+		if isNilPointer(v) {
+			buf.AppendString("null")
+			break
+		}
+		// The digits, signs and slashes of the numbers never need quoting.
+		buf.AppendBig(v)
+	case net.IP:
+		// The canonical forms of addresses never need quoting. Empty
+		// addresses are written as empty values, like MarshalText does.
+		if len(v) > 0 {
+			buf.AppendString(v.String())
+		}
+	case net.HardwareAddr:
+		buf.AppendString(v.String())
+	case error, fmt.Stringer, encoding.TextMarshaler:
+		if isNilPointer(v) {
+			buf.AppendString("null")
+			break
+		}
+		if tmp, ok := typeEncoded(v); ok {
+			escapeAndWriteString(buf, string(tmp.Bytes()))
+			bufPool.Put(tmp)
+			break
+		}
+		escapeAndWriteString(buf, o.textValue(v))
+	case ObjectMarshaler:
+		// Objects are written as fields by writeField, so this is a nil pointer
+		// or an object nested in another.
+		if isNilPointer(v) {
+			buf.AppendString("null")
+			break
+		}
+		o.writeNestedValue(buf, v)
+	case []string, []int, []int64, []float64, []bool:
+		o.writeSliceValue(buf, v)
+	default:
+		if tmp, ok := typeEncoded(val); ok {
+			escapeAndWriteString(buf, string(tmp.Bytes()))
+			bufPool.Put(tmp)
+			return
+		}
+		if b, ok := marshalJSON(val); ok {
+			// Written like maps, quoted and escaped as a logfmt value.
+			escapeAndWriteString(buf, string(b))
+			return
+		}
+
+		switch rv := reflect.ValueOf(val); rv.Kind() {
+		case reflect.Map:
+			o.writeNestedValue(buf, val)
+		case reflect.Ptr:
+			if !o.dereferencePointers {
+				escapeAndWriteString(buf, truncateValue(o.sprintValue(val), o.maxFieldValueLen))
+				break
+			}
+			if rv.IsNil() {
+				buf.AppendString("null")
+				break
+			}
+			if o.derefs >= maxNestingDepth {
+				buf.AppendString("...")
+				break
+			}
+			o.derefs++
+			o.writeValueToBuf(buf, rv.Elem().Interface())
+			o.derefs--
+		case reflect.Slice, reflect.Array:
+			if !o.reflectFields {
+				escapeAndWriteString(buf, truncateValue(o.sprintValue(val), o.maxFieldValueLen))
+				break
+			}
+			o.writeSliceValue(buf, val)
+		default:
+			escapeAndWriteString(buf, truncateValue(o.sprintValue(val), o.maxFieldValueLen))
+		}
+	}
+}
+
+// writeNestedValue writes a map or slice as JSON, quoted as a single logfmt value.
+func (o *valueOpts) writeNestedValue(buf *byteBuffer, val interface{}) {
+	tmp := bufPool.Get()
+	o.writeJSONValue(tmp, val, 0)
+	escapeAndWriteString(buf, string(tmp.Bytes()))
+	bufPool.Put(tmp)
+}
+
+// writeSliceValue writes a slice or array in the configured SliceFormat.
+// Empty slices are written as [].
+func (o *valueOpts) writeSliceValue(buf *byteBuffer, val interface{}) {
+	if o.sliceFormat == SliceJSON {
+		o.writeNestedValue(buf, val)
+		return
+	}
+
+	rv := reflect.ValueOf(val)
+	if rv.Len() == 0 {
+		buf.AppendString("[]")
+		return
+	}
+
+	tmp := bufPool.Get()
+	switch v := val.(type) {
+	case []string:
+		for i, s := range v {
+			if i > 0 {
+				tmp.AppendByte(',')
+			}
+			tmp.AppendString(s)
+		}
+	case []int:
+		for i, n := range v {
+			if i > 0 {
+				tmp.AppendByte(',')
+			}
+			tmp.AppendInt(int64(n))
+		}
+	case []int64:
+		for i, n := range v {
+			if i > 0 {
+				tmp.AppendByte(',')
+			}
+			tmp.AppendInt(n)
+		}
+	case []float64:
+		for i, f := range v {
+			if i > 0 {
+				tmp.AppendByte(',')
+			}
+			tmp.AppendFloat(f, 64)
+		}
+	case []bool:
+		for i, b := range v {
+			if i > 0 {
+				tmp.AppendByte(',')
+			}
+			tmp.AppendBool(b)
+		}
+	default:
+		for i := 0; i < rv.Len(); i++ {
+			if i > 0 {
+				tmp.AppendByte(',')
+			}
+			o.writeCSVValue(tmp, rv.Index(i).Interface())
+		}
+	}
+
+	escapeAndWriteString(buf, string(tmp.Bytes()))
+	bufPool.Put(tmp)
+}
+
+// writeEncodedBytes writes p in the configured BytesEncoding, truncated to
+// MaxFieldValueLen bytes followed by …(truncated <n> bytes). If quote is
+// set, the value is quoted if it has characters that have to be escaped in
+// logfmt.
+func (o *valueOpts) writeEncodedBytes(buf *byteBuffer, p []byte, quote bool) {
+	n := len(p)
+	if max := o.maxFieldValueLen; max > 0 && n > max {
+		p = p[:max]
+	}
+
+	// Only the base64 padding and the truncation suffix need quoting.
+	truncated := len(p) < n
+	quote = quote && (truncated || (o.bytesEncoding == BytesBase64 && len(p)%3 != 0))
+	if quote {
+		buf.AppendByte('"')
+	}
+
+	if o.bytesEncoding == BytesHex {
+		buf.AppendHex(p)
+	} else {
+		buf.AppendBase64(p)
+	}
+
+	if truncated {
+		buf.AppendString("…(truncated ")
+		buf.AppendInt(int64(n - len(p)))
+		buf.AppendString(" bytes)")
+	}
+	if quote {
+		buf.AppendByte('"')
+	}
+}
+
+// truncate cuts s down to at most max bytes, without splitting a rune,
+// and appends an ellipsis. max <= 0 leaves s as is.
+func truncate(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+
+	return s[:runeCut(s, max)] + "…"
+}
+
+// truncateValue is truncate for field values, with the number of bytes
+// cut off after the ellipsis, eg: …(truncated 10432 bytes).
+func truncateValue(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+
+	n := runeCut(s, max)
+	return s[:n] + "…(truncated " + strconv.Itoa(len(s)-n) + " bytes)"
+}
+
+// runeCut returns the largest index <= max at which s can be cut
+// without splitting a rune. max must be < len(s).
+func runeCut(s string, max int) int {
+	n := max
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return n
+}
+
+// textValue returns the text form of an error, encoding.TextMarshaler or
+// fmt.Stringer, in that order of precedence, so that types that implement
+// both, eg: net.IP, are written as the text they marshal to. If MarshalText
+// fails, the value is formatted with fmt instead and if a method panics,
+// !PANIC: <recovered value> is returned.
+func textValue(v interface{}) (s string) {
+	defer func() {
+		if r := recover(); r != nil {
+			s = fmt.Sprintf("!PANIC: %v", r)
+		}
+	}()
+
+	switch t := v.(type) {
 	case error:
-		escapeAndWriteString(buf, v.Error())
+		return t.Error()
+	case encoding.TextMarshaler:
+		b, err := t.MarshalText()
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(b)
 	case fmt.Stringer:
-		escapeAndWriteString(buf, v.String())
-	default:
-		escapeAndWriteString(buf, fmt.Sprintf("%v", val))
+		return t.String()
+	}
+
+	return ""
+}
+
+// textValue returns the text form of an error, fmt.Stringer or
+// encoding.TextMarshaler. With VerboseValues, errors that implement
+// fmt.Formatter are formatted with %+v.
+func (o *valueOpts) textValue(v interface{}) string {
+	if o.verboseValues {
+		if _, ok := v.(error); ok {
+			if _, ok := v.(fmt.Formatter); ok {
+				return fmt.Sprintf("%+v", v)
+			}
+		}
 	}
 
-	if space {
-		buf.AppendByte(' ')
+	return textValue(v)
+}
+
+// sprintValue formats a value that has no encoder of its own with fmt.
+func (o *valueOpts) sprintValue(val interface{}) string {
+	if o.verboseValues {
+		return fmt.Sprintf("%+v", val)
+	}
+
+	return fmt.Sprintf("%v", val)
+}
+
+// resolveLogValuer returns the value of a LogValuer, resolving values that
+// are LogValuers themselves up to maxLogValuerDepth times. A nil pointer
+// resolves to nil and a panic in LogValue to !PANIC: <recovered value>.
+func resolveLogValuer(val interface{}) (v interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			v = fmt.Sprintf("!PANIC: %v", r)
+		}
+	}()
+
+	for i := 0; i < maxLogValuerDepth; i++ {
+		lv, ok := val.(LogValuer)
+		if !ok {
+			break
+		}
+		if isNilPointer(lv) {
+			return nil
+		}
+		val = lv.LogValue()
+	}
+
+	return val
+}
+
+// writePointerValue writes a uintptr as 0x prefixed hex, quoted if quote
+// is set, or as a decimal number if DecimalPointers is set.
+func (o *valueOpts) writePointerValue(buf *byteBuffer, p uintptr, quote bool) {
+	if o.decimalPointers {
+		buf.AppendUint(uint64(p))
+		return
+	}
+
+	if quote {
+		buf.AppendByte('"')
+	}
+	buf.AppendString("0x")
+	buf.AppendUintBase(uint64(p), 16)
+	if quote {
+		buf.AppendByte('"')
+	}
+}
+
+// derefBasic returns the value pointed to by a pointer to a basic type or
+// time.Time, which are common in eg: protobuf generated structs, or nil if
+// the pointer is nil.
+func derefBasic(val interface{}) interface{} {
+	switch v := val.(type) {
+	case *string:
+		if v != nil {
+			return *v
+		}
+	case *int:
+		if v != nil {
+			return *v
+		}
+	case *int32:
+		if v != nil {
+			return *v
+		}
+	case *int64:
+		if v != nil {
+			return *v
+		}
+	case *uint32:
+		if v != nil {
+			return *v
+		}
+	case *uint64:
+		if v != nil {
+			return *v
+		}
+	case *float32:
+		if v != nil {
+			return *v
+		}
+	case *float64:
+		if v != nil {
+			return *v
+		}
+	case *bool:
+		if v != nil {
+			return *v
+		}
+	case *time.Time:
+		if v != nil {
+			return *v
+		}
+	}
+
+	return nil
+}
+
+// isNilPointer returns true if v is a nil pointer wrapped in an interface,
+// whose methods may panic if called.
+func isNilPointer(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+// isEmptyValue returns true if v is nil, a nil pointer, an empty string
+// or an empty slice or map.
+func isEmptyValue(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case string:
+		return t == ""
+	case []byte:
+		return len(t) == 0
+	}
+
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Ptr:
+		return rv.IsNil()
+	case reflect.Slice, reflect.Map:
+		return rv.Len() == 0
+	}
+
+	return false
+}
+
+// writeTimeValue writes a time.Time field value, quoting it if the
+// layout produces characters that need escaping.
+func (o *valueOpts) writeTimeValue(buf *byteBuffer, t time.Time) {
+	if t.IsZero() {
+		if !o.zeroTimeEmpty {
+			buf.AppendString("null")
+		}
+		return
+	}
+
+	start := len(buf.B)
+	buf.AppendTime(t, o.fieldTimeFormat)
+	if bytes.IndexByte(buf.B[start:], ' ') == -1 && bytes.IndexByte(buf.B[start:], '=') == -1 {
+		return
+	}
+
+	// Shift the formatted time right to make room for the opening quote.
+	buf.AppendByte('"')
+	copy(buf.B[start+1:], buf.B[start:len(buf.B)-1])
+	buf.B[start] = '"'
+	buf.AppendByte('"')
+}
+
+// writeDurationValue writes a time.Duration in the configured DurationFormat.
+func (o *valueOpts) writeDurationValue(buf *byteBuffer, d time.Duration) {
+	switch o.durationFormat {
+	case DurationSeconds:
+		buf.AppendFloat(d.Seconds(), 64)
+	case DurationMillis:
+		buf.AppendInt(d.Milliseconds())
+	default:
+		buf.AppendDuration(d)
 	}
 }
 
@@ -365,14 +2105,114 @@ func escapeAndWriteString(buf *byteBuffer, s string) {
 	buf.AppendString(s)
 }
 
+// Indexes of the keys written by the logger itself in logKeys.
+const (
+	timestampKey = iota
+	levelKey
+	messageKey
+	callerKey
+	funcKey
+	numLogKeys
+)
+
+// defaultLogKeys are the keys written by the logger itself by default.
+var defaultLogKeys = [numLogKeys]string{"timestamp", "level", "message", "caller", "func"}
+
+// logKeys are the keys of the fields written by the logger itself. They
+// are built at New, along with their colored variants, so that they
+// aren't built for every line.
+type logKeys struct {
+	// names are the keys as configured.
+	names []string
+
+	// plain are the keys escaped for logfmt and followed by =.
+	plain [numLogKeys]string
+
+	// colored are the plain keys colored for every level.
+	colored [OffLevel][numLogKeys]string
+
+	// defaults are the default fields of the logger encoded ahead of the
+	// lines, if they can be, and levelFields the Opts.LevelFields. They are
+	// kept here, rather than in the Logger, so that the Logger, which is
+	// copied by every call, stays small.
+	defaults    *encodedFields
+	levelFields [FatalLevel + 1]*encodedFields
+}
+
+// withDefaults returns k with the encoded default fields d, copying it
+// if they differ, as loggers derived with With and Named share the keys.
+func (k *logKeys) withDefaults(d *encodedFields) *logKeys {
+	if k.defaults == d {
+		return k
+	}
+
+	c := *k
+	c.defaults = d
+	return &c
+}
+
+// builtinKeyNames returns the keys of the fields written by the logger
+// itself as configured in opts.
+func builtinKeyNames(opts Opts) []string {
+	defaults := &defaultLogKeys
+	if s, ok := jsonSchemas[opts.Format]; ok {
+		defaults = &s.keys
+	} else if opts.Format == GELFFormat {
+		defaults = &gelfLogKeys
+	}
+
+	names := []string{opts.TimestampKey, opts.LevelKey, opts.MessageKey, opts.CallerKey, ""}
+	for i, k := range names {
+		if k == "" {
+			names[i] = defaults[i]
+		}
+	}
+
+	return names
+}
+
+// newLogKeys builds the keys written by the logger itself. The level
+// colors in opts must have been set.
+func newLogKeys(opts Opts) *logKeys {
+	k := &logKeys{names: builtinKeyNames(opts)}
+
+	var buf byteBuffer
+	for i, name := range k.names {
+		escapeAndWriteString(&buf, name)
+		key := string(buf.B)
+		buf.B = buf.B[:0]
+
+		k.plain[i] = key + "="
+		for lvl, c := range opts.LevelColors {
+			k.colored[lvl][i] = c + key + reset + "="
+		}
+	}
+
+	return k
+}
+
+// writeLogKeyToBuf writes one of the keys written by the logger itself,
+// followed by =, into the buffer in logfmt.
+func (l *Logger) writeLogKeyToBuf(buf *byteBuffer, key int, lvl Level) {
+	buf.AppendByte(' ')
+	if l.Opts.EnableColor {
+		buf.AppendString(l.keys.colored[lvl][key])
+	} else {
+		buf.AppendString(l.keys.plain[key])
+	}
+}
+
 // getColoredKey returns a color formatter key based on the log level.
-func getColoredKey(k string, lvl Level) string {
-	return colorLvlMap[lvl] + k + reset
+func (l *Logger) getColoredKey(k string, lvl Level) string {
+	return l.Opts.LevelColors[lvl] + k + reset
 }
 
 // checkEscapingRune returns true if the rune is to be escaped.
+// Line terminators are escaped so that values can't break a line, and
+// ESC so that values can't inject ANSI sequences that recolor, move the
+// cursor or clear the terminal that the logs are viewed in.
 func checkEscapingRune(r rune) bool {
-	return r == '=' || r == ' ' || r == '"' || r == utf8.RuneError
+	return r == '=' || r == ' ' || r == '"' || r == '\n' || r == '\r' || r == 0 || r == '\x1b' || r == utf8.RuneError
 }
 
 // writeQuotedString quotes a string before writing to the buffer.
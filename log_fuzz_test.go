@@ -0,0 +1,26 @@
+//go:build go1.18
+
+package logf
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzLevelFromString(f *testing.F) {
+	for _, s := range []string{"debug", "INFO", "Warning", "error", "fatal", "", "invalid lvl", "off", "\xff"} {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		lvl, err := LevelFromString(s)
+		if err != nil {
+			return
+		}
+
+		// Parsed levels round trip through their names.
+		if !strings.EqualFold(lvl.String(), s) && !strings.EqualFold(s, "warning") {
+			t.Fatalf("%q parsed as %v", s, lvl)
+		}
+	})
+}
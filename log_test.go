@@ -4,10 +4,21 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unsafe"
 
 	"github.com/stretchr/testify/require"
 )
@@ -17,13 +28,48 @@ func TestLogFormatWithEnableCaller(t *testing.T) {
 	l := New(Opts{Writer: buf, EnableCaller: true})
 
 	l.Info("hello world")
+	_, file, line, _ := runtime.Caller(0)
+	dir := filepath.Base(filepath.Dir(file))
 	require.Contains(t, buf.String(), `level=info message="hello world" caller=`)
-	require.Contains(t, buf.String(), `logf/log_test.go:19`)
+	require.Contains(t, buf.String(), fmt.Sprintf("%s/log_test.go:%d", dir, line-1))
 	buf.Reset()
 
 	lC := New(Opts{Writer: buf, EnableCaller: true, EnableColor: true})
 	lC.Info("hello world")
-	require.Contains(t, buf.String(), `logf/log_test.go:25`)
+	_, _, line, _ = runtime.Caller(0)
+	require.Contains(t, buf.String(), fmt.Sprintf("%s/log_test.go:%d", dir, line-1))
+	buf.Reset()
+}
+
+func TestLogFormatWithCallerShortPath(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, EnableCaller: true, CallerShortPath: true})
+
+	l.Info("hello world")
+	_, _, line, _ := runtime.Caller(0)
+	require.Contains(t, buf.String(), fmt.Sprintf(" caller=log_test.go:%d\n", line-1))
+	require.NotContains(t, buf.String(), string(filepath.Separator))
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, EnableCaller: true})
+	l.Info("hello world")
+	_, file, _, _ := runtime.Caller(0)
+	require.Contains(t, buf.String(), filepath.Base(filepath.Dir(file))+"/log_test.go:")
+}
+
+func TestLogFormatWithCallerFunc(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, EnableCaller: true, EnableCallerFunc: true})
+
+	l.Info("hello world")
+	require.Contains(t, buf.String(), ` caller=`)
+	require.Contains(t, buf.String(), ` func=logf.TestLogFormatWithCallerFunc`+"\n")
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, EnableCallerFunc: true})
+	l.Info("hello world")
+	require.NotContains(t, buf.String(), ` caller=`)
+	require.Contains(t, buf.String(), ` func=logf.TestLogFormatWithCallerFunc`+"\n")
 	buf.Reset()
 }
 
@@ -57,6 +103,38 @@ func TestLevelParsing(t *testing.T) {
 		})
 	}
 
+	// Levels are parsed regardless of case.
+	for s, lvl := range map[string]Level{
+		"DEBUG":   DebugLevel,
+		"Debug":   DebugLevel,
+		"INFO":    InfoLevel,
+		"iNfO":    InfoLevel,
+		"WARN":    WarnLevel,
+		"Warn":    WarnLevel,
+		"warning": WarnLevel,
+		"WARNING": WarnLevel,
+		"Warning": WarnLevel,
+		"ERROR":   ErrorLevel,
+		"Error":   ErrorLevel,
+		"FATAL":   FatalLevel,
+		"fAtAl":   FatalLevel,
+		"off":     OffLevel,
+		"OFF":     OffLevel,
+	} {
+		got, err := LevelFromString(s)
+		require.NoError(t, err, s)
+		require.Equal(t, lvl, got, s)
+	}
+
+	for _, s := range []string{"", " info", "inf", "verbose"} {
+		_, err := LevelFromString(s)
+		require.Error(t, err, s)
+	}
+
+	// Aliases don't change the names that levels are written with.
+	require.Equal(t, "warn", WarnLevel.String())
+	require.Equal(t, "off", OffLevel.String())
+
 	// Check for an invalid case.
 	t.Run("invalid", func(t *testing.T) {
 		var invalidLvl Level = 10
@@ -64,6 +142,16 @@ func TestLevelParsing(t *testing.T) {
 	})
 }
 
+func TestIsEnabled(t *testing.T) {
+	levels := []Level{DebugLevel, InfoLevel, WarnLevel, ErrorLevel, FatalLevel}
+	for _, min := range levels {
+		l := New(Opts{Level: min})
+		for _, lvl := range levels {
+			require.Equal(t, lvl >= min, l.IsEnabled(lvl), "%s enabled at %s", lvl, min)
+		}
+	}
+}
+
 func TestNewLoggerDefault(t *testing.T) {
 	l := New(Opts{})
 	require.Equal(t, l.Opts.Level, InfoLevel, "level is info")
@@ -96,12 +184,12 @@ func TestLogFormat(t *testing.T) {
 
 	// Info log.
 	l.Info("hello world")
-	require.Contains(t, buf.String(), `level=info message="hello world"`, "info log")
+	require.Contains(t, buf.String(), `level=info message="hello world"`+"\n", "info log")
 	buf.Reset()
 
 	// Log with field.
 	l.Warn("testing fields", "stack", "testing")
-	require.Contains(t, buf.String(), `level=warn message="testing fields" stack=testing`, "warning log")
+	require.Contains(t, buf.String(), `level=warn message="testing fields" stack=testing`+"\n", "warning log")
 	buf.Reset()
 
 	// Log with error.
@@ -122,16 +210,165 @@ func TestLogFormat(t *testing.T) {
 	buf.Reset()
 }
 
+// fakeFlushWriter records the calls to Flush and Close.
+type fakeFlushWriter struct {
+	bytes.Buffer
+	flushed int
+	closed  int
+}
+
+func (w *fakeFlushWriter) Flush() error {
+	w.flushed++
+	return nil
+}
+
+func (w *fakeFlushWriter) Close() error {
+	w.closed++
+	return errors.New("closed")
+}
+
+func TestLogFlushClose(t *testing.T) {
+	w := &fakeFlushWriter{}
+	l := New(Opts{Writer: w})
+	require.NoError(t, l.Flush())
+	require.Equal(t, 1, w.flushed)
+	require.EqualError(t, l.With("a", 1).Close(), "closed")
+	require.Equal(t, 1, w.closed)
+
+	// Fatal flushes before exiting.
+	var flushedOnExit int
+	exit = func() { flushedOnExit = w.flushed }
+	l.Fatal("fatal log")
+	l.FatalA("fatal log")
+	l.FatalFunc(func() string { return "fatal log" })
+	require.Equal(t, 4, flushedOnExit)
+
+	// Writers that don't buffer or close are left as is.
+	l = New(Opts{Writer: &bytes.Buffer{}})
+	require.NoError(t, l.Flush())
+	require.NoError(t, l.Close())
+	l = New(Opts{Writer: os.Stderr})
+	require.NoError(t, l.Close())
+}
+
 func TestLogFormatWithColor(t *testing.T) {
 	buf := &bytes.Buffer{}
 	l := New(Opts{Writer: buf, EnableColor: true})
 
 	// Info log.
 	l.Info("hello world")
-	require.Contains(t, buf.String(), "\x1b[36mlevel\x1b[0m=info \x1b[36mmessage\x1b[0m=\"hello world\" \n")
+	require.Contains(t, buf.String(), "\x1b[36mlevel\x1b[0m=info \x1b[36mmessage\x1b[0m=\"hello world\"\n")
+	buf.Reset()
+
+	// Keys that need quoting are quoted inside the colors.
+	l.Info("hello world", "my key", 1, "a=b", 2, "plain", 3)
+	require.Contains(t, buf.String(), "\x1b[36m\"my key\"\x1b[0m=1 \x1b[36m\"a=b\"\x1b[0m=2 \x1b[36mplain\x1b[0m=3\n")
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, EnableColor: true, MessageKey: "the message"})
+	l.Info("hello world")
+	require.Contains(t, buf.String(), "\x1b[36m\"the message\"\x1b[0m=\"hello world\"\n")
+	buf.Reset()
+}
+
+func TestLogFormatWithLevelColors(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, EnableColor: true, LevelColors: [6]string{InfoLevel: "\033[32m"}})
+	require.Equal(t, "\033[32m", l.Opts.LevelColors[InfoLevel])
+	require.Equal(t, red, l.Opts.LevelColors[ErrorLevel], "unset levels use the default color")
+
+	l.Info("hello world")
+	require.Contains(t, buf.String(), "\x1b[32mlevel\x1b[0m=info \x1b[32mmessage\x1b[0m=\"hello world\"\n")
+	buf.Reset()
+
+	l.Error("hello world")
+	require.Contains(t, buf.String(), "\x1b[31mlevel\x1b[0m=error")
+	buf.Reset()
+}
+
+func TestLogKeys(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, EnableCaller: true, CallerShortPath: true,
+		TimestampKey: "ts", LevelKey: "lvl", MessageKey: "msg", CallerKey: "src"})
+
+	l.Info("hello world", "msg", "x", "message", "y")
+	require.Regexp(t, `^ts=\S+ lvl=info msg="hello world" src=log_test.go:\d+ field_msg=x message=y\n$`, buf.String())
+	buf.Reset()
+
+	l.Opts.EnableColor = true
+	l.Warn("hello world")
+	require.Regexp(t, "^\x1b\\[33mts\x1b\\[0m=\\S+ \x1b\\[33mlvl\x1b\\[0m=warn \x1b\\[33mmsg\x1b\\[0m=", buf.String())
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, Format: MsgpackFormat, MessageKey: "msg"})
+	l.Info("hello world")
+	m := decodeMsgpackFrames(t, buf.Bytes())[0]
+	require.Equal(t, "hello world", m["msg"])
+	require.Contains(t, m, "timestamp")
+	require.NotContains(t, m, "message")
+}
+
+func TestNoColorEnv(t *testing.T) {
+	buf := &bytes.Buffer{}
+	t.Setenv("NO_COLOR", "1")
+
+	l := New(Opts{Writer: buf, EnableColor: true})
+	require.False(t, l.Opts.EnableColor, "color output is disabled")
+
+	l.Info("hello world")
+	require.NotContains(t, buf.String(), "\x1b[")
+}
+
+func TestIsTerminal(t *testing.T) {
+	require.False(t, IsTerminal(&bytes.Buffer{}))
+
+	f, err := os.CreateTemp(t.TempDir(), "log")
+	require.NoError(t, err)
+	defer f.Close()
+	require.False(t, IsTerminal(f))
+}
+
+func TestLogFormatCompact(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, Compact: true})
+	require.Equal(t, compactTSFormat, l.Opts.TimestampFormat, "compact timestamp format is default")
+
+	l.Warn("hello world", "component", "logf")
+	require.Regexp(t, `^\d{2}:\d{2}:\d{2}\.\d{3} \[W\] message="hello world" component=logf\n$`, buf.String())
+	require.NotContains(t, buf.String(), "timestamp=")
+	require.NotContains(t, buf.String(), "level=")
+	buf.Reset()
+
+	lC := New(Opts{Writer: buf, Compact: true, EnableColor: true})
+	lC.Error("hello world")
+	require.Contains(t, buf.String(), " \x1b[31m[E]\x1b[0m \x1b[31mmessage\x1b[0m=\"hello world\"\n")
 	buf.Reset()
 }
 
+func TestLineEnding(t *testing.T) {
+	for _, le := range []string{"", LineEndingLF, LineEndingCRLF, LineEndingNUL} {
+		buf := &bytes.Buffer{}
+		l := New(Opts{Writer: buf, LineEnding: le})
+		if le == "" {
+			le = LineEndingLF
+		}
+
+		l.Info("hello world\n", "key", "value\r\n\x00")
+		require.Equal(t, 1, strings.Count(buf.String(), le), "%q", le)
+		require.True(t, strings.HasSuffix(buf.String(), `key="value\r\n\u0000"`+le), "%q", buf.String())
+		require.Contains(t, buf.String(), `message="hello world\n"`)
+		buf.Reset()
+
+		exit = func() {}
+		l.Fatal("fatal")
+		require.True(t, strings.HasSuffix(buf.String(), le), "%q", le)
+	}
+
+	// Invalid line endings fall back to \n.
+	l := New(Opts{LineEnding: ";"})
+	require.Equal(t, LineEndingLF, l.Opts.LineEnding)
+}
+
 func TestLoggerTypes(t *testing.T) {
 	buf := &bytes.Buffer{}
 	l := New(Opts{Writer: buf, Level: DebugLevel})
@@ -145,125 +382,1479 @@ func TestLoggerTypes(t *testing.T) {
 		"int16", int16(1),
 		"int32", int32(1),
 		"int64", int64(1),
+		"uint", uint(1),
+		"uint8", uint8(1),
+		"uint16", uint16(1),
+		"uint32", uint32(1),
+		"uint64", uint64(math.MaxUint64),
+		"uintptr", uintptr(1),
 		"float32", float32(1.0),
 		"float64", float64(1.0),
 		"struct", foo{A: 1},
 		"bool", true,
 	)
 
-	require.Contains(t, buf.String(), "level=info message=\"hello world\" string=foo int=1 int8=1 int16=1 int32=1 int64=1 float32=1 float64=1 struct={1} bool=true \n")
+	require.Contains(t, buf.String(), "level=info message=\"hello world\" string=foo int=1 int8=1 int16=1 int32=1 int64=1 uint=1 uint8=1 uint16=1 uint32=1 uint64=18446744073709551615 uintptr=0x1 float32=1 float64=1 struct={1} bool=true\n")
+	buf.Reset()
+
+	var (
+		s   = "foo bar"
+		i   = 1
+		i32 = int32(2)
+		i64 = int64(3)
+		u32 = uint32(4)
+		u64 = uint64(5)
+		f32 = float32(1.5)
+		f64 = 2.5
+		b   = true
+		ts  = time.Date(2022, 7, 7, 12, 9, 10, 0, time.UTC)
+	)
+	l.Info("hello world", "string", &s, "int", &i, "int32", &i32, "int64", &i64, "uint32", &u32, "uint64", &u64,
+		"float32", &f32, "float64", &f64, "bool", &b, "time", &ts)
+	require.Contains(t, buf.String(), `string="foo bar" int=1 int32=2 int64=3 uint32=4 uint64=5 float32=1.5 float64=2.5 bool=true time=2022-07-07T12:09:10Z`+"\n")
+	buf.Reset()
+
+	l.Info("hello world", "string", (*string)(nil), "int", (*int)(nil), "int32", (*int32)(nil), "int64", (*int64)(nil),
+		"uint32", (*uint32)(nil), "uint64", (*uint64)(nil), "float32", (*float32)(nil), "float64", (*float64)(nil),
+		"bool", (*bool)(nil), "time", (*time.Time)(nil))
+	require.Contains(t, buf.String(), `string=null int=null int32=null int64=null uint32=null uint64=null float32=null float64=null bool=null time=null`+"\n")
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Info("hello world", "int", &i, "string", &s, "nil", (*bool)(nil))
+	require.Contains(t, buf.String(), `"{""int"":1,""string"":""foo bar"",""nil"":null}"`)
+	buf.Reset()
+
+	l.Opts.Format = MsgpackFormat
+	l.Info("hello world", "int", &i, "string", &s, "nil", (*bool)(nil))
+	frames := decodeMsgpackFrames(t, buf.Bytes())
+	require.Equal(t, int64(1), frames[0]["int"])
+	require.Equal(t, "foo bar", frames[0]["string"])
+	require.Nil(t, frames[0]["nil"])
+	require.Contains(t, frames[0], "nil")
+	buf.Reset()
+
+	bi, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	bf, _ := new(big.Float).SetPrec(200).SetString("1.000000000000000000001")
+	l.Opts.Format = LogfmtFormat
+	l.Info("hello world", "int", bi, "float", bf, "rat", big.NewRat(1, 3), "ratint", big.NewRat(4, 2),
+		"nilint", (*big.Int)(nil), "nilfloat", (*big.Float)(nil), "nilrat", (*big.Rat)(nil))
+	require.Contains(t, buf.String(), `int=-123456789012345678901234567890 float=1.000000000000000000001 rat=1/3 ratint=2 nilint=null nilfloat=null nilrat=null`+"\n")
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Info("hello world", "int", bi, "rat", big.NewRat(1, 3), "nil", (*big.Int)(nil))
+	require.Contains(t, buf.String(), `"{""int"":""-123456789012345678901234567890"",""rat"":""1/3"",""nil"":null}"`)
+	buf.Reset()
+
+	l.Opts.Format = MsgpackFormat
+	l.Info("hello world", "int", bi, "nil", (*big.Float)(nil))
+	frames = decodeMsgpackFrames(t, buf.Bytes())
+	require.Equal(t, "-123456789012345678901234567890", frames[0]["int"])
+	require.Nil(t, frames[0]["nil"])
 }
 
-func TestLogFormatWithDefaultFields(t *testing.T) {
+func TestLogPointerField(t *testing.T) {
 	buf := &bytes.Buffer{}
-	l := New(Opts{Writer: buf, DefaultFields: []interface{}{"defaultkey", "defaultvalue"}})
+	l := New(Opts{Writer: buf})
 
-	l.Info("hello world")
-	require.Contains(t, buf.String(), `level=info message="hello world" defaultkey=defaultvalue`)
+	var x int
+	p := unsafe.Pointer(&x)
+	l.Info("hello world", "ptr", uintptr(0xc000012345), "zero", uintptr(0), "max", uintptr(math.MaxUint32),
+		"unsafe", p, "nil", unsafe.Pointer(nil))
+	require.Contains(t, buf.String(), `ptr=0xc000012345 zero=0x0 max=0xffffffff unsafe=0x`+strconv.FormatUint(uint64(uintptr(p)), 16)+` nil=0x0`+"\n")
 	buf.Reset()
 
-	l.Info("hello world", "component", "logf")
-	require.Contains(t, buf.String(), `level=info message="hello world" defaultkey=defaultvalue component=logf`)
+	l.Opts.Format = CSVFormat
+	l.Info("hello world", "ptr", uintptr(0xc000012345))
+	require.Contains(t, buf.String(), `"{""ptr"":""0xc000012345""}"`)
+	buf.Reset()
+
+	l.Opts.Format = MsgpackFormat
+	l.Info("hello world", "ptr", uintptr(0xc000012345))
+	require.Equal(t, "0xc000012345", decodeMsgpackFrames(t, buf.Bytes())[0]["ptr"])
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, DecimalPointers: true})
+	l.Info("hello world", "ptr", uintptr(0xff), "unsafe", p)
+	require.Contains(t, buf.String(), `ptr=255 unsafe=`+strconv.FormatUint(uint64(uintptr(p)), 10)+``+"\n")
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Info("hello world", "ptr", uintptr(0xff))
+	require.Contains(t, buf.String(), `"{""ptr"":255}"`)
 	buf.Reset()
+
+	l.Opts.Format = MsgpackFormat
+	l.Info("hello world", "ptr", uintptr(0xff))
+	require.Equal(t, uint64(255), decodeMsgpackFrames(t, buf.Bytes())[0]["ptr"])
 }
 
-type errWriter struct{}
+func TestLogTimeField(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, TimestampFormat: time.RFC3339})
 
-func (w *errWriter) Write(p []byte) (int, error) {
-	return 0, errors.New("dummy error")
+	ts := time.Date(2022, 7, 7, 12, 9, 10, 0, time.UTC)
+	l.Info("hello world", "at", ts)
+	require.Contains(t, buf.String(), `message="hello world" at=2022-07-07T12:09:10Z`)
+	buf.Reset()
+
+	l.Info("hello world", "at", time.Time{})
+	require.Contains(t, buf.String(), `message="hello world" at=null`)
+	buf.Reset()
+
+	// Layouts with spaces are quoted.
+	l = New(Opts{Writer: buf, FieldTimeFormat: time.ANSIC, ZeroTimeEmpty: true})
+	l.Info("hello world", "at", ts, "zero", time.Time{}, "next", 1)
+	require.Contains(t, buf.String(), `message="hello world" at="Thu Jul  7 12:09:10 2022" zero= next=1`)
+	require.Equal(t, defaultTSFormat, l.Opts.TimestampFormat)
+	buf.Reset()
 }
 
-func TestIoWriterError(t *testing.T) {
-	w := &errWriter{}
-	l := New(Opts{Writer: w})
+func TestLogDurationField(t *testing.T) {
 	buf := &bytes.Buffer{}
-	log.SetOutput(buf)
-	log.SetFlags(0)
-	l.Info("hello world")
-	require.Contains(t, buf.String(), "error logging: dummy error\n")
+	l := New(Opts{Writer: buf})
+
+	for _, d := range []time.Duration{
+		0,
+		1,
+		-1,
+		1234 * time.Microsecond,
+		-1500 * time.Millisecond,
+		time.Hour + 2*time.Minute + 500*time.Millisecond,
+		1<<63 - 1,
+		-1 << 63,
+	} {
+		l.Info("done", "took", d)
+		require.Contains(t, buf.String(), " took="+d.String()+"\n")
+
+		// The rendered value parses back to the same duration.
+		e, err := Parse(buf.Bytes())
+		require.NoError(t, err)
+		parsed, err := time.ParseDuration(e.Fields[1].(string))
+		require.NoError(t, err)
+		require.Equal(t, d, parsed)
+		buf.Reset()
+	}
+
+	l = New(Opts{Writer: buf, DurationFormat: DurationSeconds})
+	l.Info("done", "took", 1500*time.Millisecond, "neg", -time.Millisecond)
+	require.Contains(t, buf.String(), "took=1.5 neg=-0.001\n")
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, DurationFormat: DurationMillis})
+	l.Info("done", "took", 1500*time.Millisecond, "zero", time.Duration(0))
+	require.Contains(t, buf.String(), "took=1500 zero=0\n")
+	buf.Reset()
 }
 
-func TestWriteQuotedStringCases(t *testing.T) {
+func TestLogMapField(t *testing.T) {
 	buf := &bytes.Buffer{}
 	l := New(Opts{Writer: buf})
 
-	// cases from
-	// https://github.com/go-logfmt/logfmt/blob/99455b83edb21b32a1f1c0a32f5001b77487b721/encode_test.go
-	data := []struct {
-		key, value interface{}
-		want       string
-	}{
-		{key: "k", value: "v", want: "k=v"},
-		{key: "k", value: nil, want: "k=null"},
-		{key: `\`, value: "v", want: `\=v`},
-		{key: "k", value: "", want: "k="},
-		{key: "k", value: "null", want: `k="null"`},
-		{key: "k", value: "<nil>", want: `k=<nil>`},
-		{key: "k", value: true, want: "k=true"},
-		{key: "k", value: 1, want: "k=1"},
-		{key: "k", value: 1.025, want: "k=1.025"},
-		{key: "k", value: 1e-3, want: "k=0.001"},
-		{key: "k", value: 3.5 + 2i, want: "k=(3.5+2i)"},
-		{key: "k", value: "v v", want: `k="v v"`},
-		{key: "k", value: " ", want: `k=" "`},
-		{key: "k", value: `"`, want: `k="\""`},
-		{key: "k", value: `=`, want: `k="="`},
-		{key: "k", value: `\`, want: `k=\`},
-		{key: "k", value: `=\`, want: `k="=\\"`},
-		{key: "k", value: `\"`, want: `k="\\\""`},
-		{key: "k", value: "\xbd", want: `k="\ufffd"`},
-		{key: "k", value: "\ufffd\x00", want: `k="\ufffd\u0000"`},
-		{key: "k", value: "\ufffd", want: `k="\ufffd"`},
-		{key: "k", value: []byte("\ufffd\x00"), want: `k="\ufffd\u0000"`},
-		{key: "k", value: []byte("\ufffd"), want: `k="\ufffd"`},
+	m := map[string]interface{}{
+		"b":     "x y",
+		"a":     1,
+		"c":     map[int]bool{2: true, 1: false},
+		"nil":   map[string]string(nil),
+		"empty": map[string]string{},
 	}
 
-	for _, d := range data {
-		l.Info("hello world", d.key, d.value)
-		require.Contains(t, buf.String(), d.want)
+	want := `m="{\"a\":1,\"b\":\"x y\",\"c\":{\"1\":false,\"2\":true},\"empty\":{},\"nil\":null}"`
+	for i := 0; i < 20; i++ {
+		l.Info("hello world", "m", m)
+		require.Contains(t, buf.String(), want)
 		buf.Reset()
 	}
+
+	l.Info("hello world", "m", map[string]int{})
+	require.Contains(t, buf.String(), ` m={}`+"\n")
+	buf.Reset()
+
+	// Nesting is bounded.
+	deep := map[string]interface{}{}
+	for i, cur := 0, deep; i < 10; i++ {
+		next := map[string]interface{}{}
+		cur["k"] = next
+		cur = next
+	}
+	l.Info("hello world", "m", deep)
+	require.Contains(t, buf.String(), `m="{\"k\":{\"k\":{\"k\":{\"k\":{\"k\":\"...\"}}}}}"`)
+	buf.Reset()
 }
 
-func TestOddNumberedFields(t *testing.T) {
+func TestLogSliceField(t *testing.T) {
 	buf := &bytes.Buffer{}
 	l := New(Opts{Writer: buf})
 
-	// Give a odd number of fields.
-	l.Info("hello world", "key1", "val1", "key2")
-	require.Contains(t, buf.String(), `level=info message="hello world" key1=val1`)
+	// Slices of other types are only walked with ReflectFields.
+	l.Info("hello world", "ints", []int{1, 2}, "int64s", []int64{3, 4}, "array", [2]uint8{1, 2},
+		"structs", []struct{ A int }{{1}, {2}})
+	require.Contains(t, buf.String(), `ints=[1,2] int64s=[3,4] array="[1 2]" structs="[{1} {2}]"`+"\n")
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, ReflectFields: true})
+	l.Info("hello world",
+		"strings", []string{"a", "b c"},
+		"ints", []int{1, 2},
+		"floats", []float64{1.5, 2},
+		"bools", []bool{true, false},
+		"array", [2]uint8{1, 2},
+		"structs", []struct{ A int }{{1}, {2}},
+		"nested", [][]string{{"a"}, {}},
+		"empty", []string{},
+		"nil", []int(nil),
+	)
+	require.Contains(t, buf.String(), `strings="[\"a\",\"b c\"]" ints=[1,2] floats=[1.5,2] bools=[true,false] array=[1,2] `)
+	require.Contains(t, buf.String(), `structs="[\"{1}\",\"{2}\"]" nested="[[\"a\"],[]]" empty=[] nil=[]`+"\n")
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, SliceFormat: SliceComma, ReflectFields: true})
+	l.Info("hello world",
+		"strings", []string{"a", "b c"},
+		"ints", []int{1, 2},
+		"int64s", []int64{3, 4},
+		"floats", []float64{1.5, 2},
+		"bools", []bool{true, false},
+		"array", [2]uint8{1, 2},
+		"empty", []string{},
+	)
+	require.Contains(t, buf.String(), `strings="a,b c" ints=1,2 int64s=3,4 floats=1.5,2 bools=true,false array=1,2 empty=[]`+"\n")
 	buf.Reset()
 }
 
-func TestOddNumberedFieldsWithDefaultFields(t *testing.T) {
+func TestLogRedact(t *testing.T) {
 	buf := &bytes.Buffer{}
-	l := New(Opts{Writer: buf, DefaultFields: []interface{}{
-		"defaultkey", "defaultval",
-	}})
+	l := New(Opts{
+		Writer:        buf,
+		DefaultFields: []interface{}{"token", "abc", "component", "api"},
+		Redact: func(key string, val interface{}) interface{} {
+			if key == "password" || key == "token" {
+				return Redacted
+			}
+			return val
+		},
+	})
 
-	// Give a odd number of fields.
-	l.Info("hello world", "key1", "val1", "key2")
-	require.Contains(t, buf.String(), `level=info message="hello world" defaultkey=defaultval key1=val1`)
+	l.Info("login", "user", "alice", "password", "hunter2", "attempts", 3)
+	require.Contains(t, buf.String(), `token=[REDACTED] component=api user=alice password=[REDACTED] attempts=3`+"\n")
+	require.NotContains(t, buf.String(), "abc")
+	require.NotContains(t, buf.String(), "hunter2")
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Opts.CSVColumns = []string{"password"}
+	l.Info("login", "password", "hunter2", "token", "xyz")
+	require.Contains(t, buf.String(), `,[REDACTED],"{""token"":""[REDACTED]"",""component"":""api"",""token"":""[REDACTED]""}"`)
+	require.NotContains(t, buf.String(), "hunter2")
+	require.NotContains(t, buf.String(), "xyz")
 	buf.Reset()
 }
 
-// These test are typically meant to be run with the data race detector.
-func TestLoggerConcurrency(t *testing.T) {
+func TestLogRedactKeys(t *testing.T) {
 	buf := &bytes.Buffer{}
-	l := New(Opts{Writer: buf})
+	l := New(Opts{
+		Writer:        buf,
+		DefaultFields: []interface{}{"PAN", "ABCDE1234F", "component", "api"},
+		RedactKeys:    []string{"password", "authorization", "pan"},
+	})
 
-	for _, n := range []int{10, 100, 1000} {
-		wg := sync.WaitGroup{}
-		wg.Add(n)
-		for i := 0; i < n; i++ {
-			go func() { genLogs(l); wg.Done() }()
-		}
-		wg.Wait()
+	l.Info("login", "user", "alice", "Password", errors.New("hunter2"), String("authorization", "Bearer xyz"),
+		"password_hint", "pet", "lazy", Lazy(func() interface{} { return "ok" }))
+	require.Contains(t, buf.String(), `PAN=[REDACTED] component=api user=alice Password=[REDACTED] authorization=[REDACTED] password_hint=pet lazy=ok`+"\n")
+	require.NotContains(t, buf.String(), "ABCDE1234F")
+	buf.Reset()
+
+	// Values that would be evaluated are redacted without being evaluated.
+	l.Info("login", "password", Lazy(func() interface{} { panic("evaluated") }), "AUTHORIZATION", textID(2))
+	require.Contains(t, buf.String(), `password=[REDACTED] AUTHORIZATION=[REDACTED]`+"\n")
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, EnableColor: true, RedactKeys: []string{"password"}})
+	l.Info("login", "user", "alice", "password", "hunter2")
+	require.Contains(t, buf.String(), "password"+reset+"=[REDACTED]")
+	require.NotContains(t, buf.String(), "hunter2")
+	buf.Reset()
+}
+
+type textID int
+
+func (id textID) MarshalText() ([]byte, error) {
+	if id < 0 {
+		return nil, errors.New("negative id")
 	}
+	return []byte("id-" + strconv.Itoa(int(id))), nil
 }
 
-func genLogs(l Logger) {
-	for i := 0; i < 100; i++ {
-		l.Info("random log", "index", strconv.FormatInt(int64(i), 10))
+type stringerTextID struct{ textID }
+
+func (stringerTextID) String() string { return "stringer" }
+
+type errStringerTextID struct{ stringerTextID }
+
+func (errStringerTextID) Error() string { return "error" }
+
+func TestLogTextMarshaler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+
+	// MarshalText takes precedence over String, and if it fails, the value
+	// is formatted with fmt.
+	l.Info("hello world",
+		"id", textID(1),
+		"bad", textID(-1),
+		"stringer", stringerTextID{2},
+		"bad_stringer", stringerTextID{-2},
+		"error", errStringerTextID{},
+		"ip", net.ParseIP("10.0.0.1"),
+		"ip6", net.ParseIP("::1"),
+	)
+	require.Contains(t, buf.String(), `id=id-1 bad=-1 stringer=id-2 bad_stringer=stringer error=error ip=10.0.0.1 ip6=::1`+"\n")
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Info("hello world", "id", textID(1), "bad", textID(-1))
+	require.Contains(t, buf.String(), `"{""id"":""id-1"",""bad"":""-1""}"`)
+	buf.Reset()
+}
+
+func TestLogOmitEmpty(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, OmitEmpty: true, DefaultFields: []interface{}{"trace_id", ""}})
+
+	var (
+		ptr *int
+		n   = 0
+	)
+	l.Info("hello world", "nil", nil, "a", 1, "str", "", "ptr", ptr, "bytes", []byte{}, "slice", []string{},
+		"map", map[string]int{}, "zero", n, "b", false, String("typed", ""), "c", "x", "last", "")
+	require.Contains(t, buf.String(), `message="hello world" a=1 zero=0 b=false c=x`+"\n")
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Info("hello world", "str", "", "a", 1)
+	require.Contains(t, buf.String(), `"{""a"":1}"`)
+	buf.Reset()
+}
+
+func TestLogSortFields(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, SortFields: true, DefaultFields: []interface{}{"service", "api", "b", 2}})
+
+	l.Info("hello world", "z", 1, Int("a", 1), "c", "x", "b", 3, "dangling")
+	require.Contains(t, buf.String(), `level=info message="hello world" !BADKEY=dangling a=1 b=2 b=3 c=x service=api z=1`+"\n")
+	buf.Reset()
+
+	l.With("m", 1).Info("hello world", Namespace("req"), "id", 1)
+	require.Contains(t, buf.String(), `message="hello world" b=2 m=1 req.id=1 service=api`+"\n")
+	buf.Reset()
+
+	l.Opts.Format = MsgpackFormat
+	l.Info("hello world", "z", 1, "a", 2)
+	m := decodeMsgpackFrames(t, buf.Bytes())[0]
+	require.Equal(t, int64(2), m["a"])
+	require.Equal(t, int64(1), m["z"])
+}
+
+func TestLogDereferencePointers(t *testing.T) {
+	type user struct {
+		ID   int
+		Name string
+	}
+
+	var (
+		u    = &user{ID: 1, Name: "alice"}
+		uu   = &u
+		nilU *user
+		ids  = &[]int{1, 2}
+	)
+
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+	l.Info("hello world", "user", u, "ptr", uu)
+	require.Regexp(t, `user="&\{1 alice\}" ptr=0x[0-9a-f]+\n`, buf.String())
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, DereferencePointers: true})
+	l.Info("hello world", "user", u, "ptr", uu, "nil", nilU, "ids", ids, "n", new(int))
+	require.Contains(t, buf.String(), `user="{1 alice}" ptr="{1 alice}" nil=null ids=[1,2] n=0`+"\n")
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Info("hello world", "ids", ids, "nil", nilU)
+	require.Contains(t, buf.String(), `"{""ids"":[1,2],""nil"":null}"`)
+	buf.Reset()
+
+	l.Opts.Format = MsgpackFormat
+	l.Info("hello world", "user", u, "nil", nilU)
+	m := decodeMsgpackFrames(t, buf.Bytes())[0]
+	require.Equal(t, "{1 alice}", m["user"])
+	require.Nil(t, m["nil"])
+	buf.Reset()
+
+	// Pointer cycles are cut off after maxNestingDepth dereferences.
+	var cycle interface{}
+	cycle = &cycle
+	l.Info("hello world", "cycle", cycle)
+	require.Equal(t, "...", decodeMsgpackFrames(t, buf.Bytes())[0]["cycle"])
+	buf.Reset()
+
+	l.Opts.Format = LogfmtFormat
+	l.Info("hello world", "cycle", cycle, "n", 1)
+	require.Contains(t, buf.String(), ` cycle=... n=1`+"\n")
+	buf.Reset()
+
+	l.Opts.Format = ECSFormat
+	l.Info("hello world", "cycle", cycle)
+	require.Contains(t, buf.String(), `"cycle":"..."}`)
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Opts.CSVColumns = []string{"cycle"}
+	l.Info("hello world", "cycle", cycle)
+	require.Contains(t, buf.String(), `,hello world,,...,`)
+}
+
+func TestLogDedupeKeys(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, DedupeKeys: true, DefaultFields: []interface{}{"component", "worker", "env", "prod"}})
+
+	l.Info("hello world", "component", "api", "a", 1, Int("a", 2), "b", 3)
+	require.Contains(t, buf.String(), `message="hello world" env=prod component=api a=2 b=3`+"\n")
+	buf.Reset()
+
+	l.With("env", "dev").Info("hello world", "b", 1)
+	require.Contains(t, buf.String(), `message="hello world" component=worker env=dev b=1`+"\n")
+	buf.Reset()
+
+	l.Opts.SortFields = true
+	l.Info("hello world", "component", "api", "a", 1, "a", 2)
+	require.Contains(t, buf.String(), `message="hello world" a=2 component=api env=prod`+"\n")
+	buf.Reset()
+
+	l.Opts.Format = MsgpackFormat
+	l.Info("hello world", "component", "api")
+	frames := decodeMsgpackFrames(t, buf.Bytes())
+	require.Equal(t, "api", frames[0]["component"])
+}
+
+func TestLogDefaultFieldsLast(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, DefaultFieldsLast: true, DefaultFields: []interface{}{"component", "api"}})
+
+	l.Info("hello world", "status", 200, Int("size", 10))
+	require.Contains(t, buf.String(), `message="hello world" status=200 size=10 component=api`+"\n")
+	buf.Reset()
+
+	l.With("user", "alice").Info("hello world")
+	require.Contains(t, buf.String(), `message="hello world" component=api user=alice`+"\n")
+	buf.Reset()
+
+	// A dangling value in the per-call fields is written before the
+	// default fields, in both orders.
+	l.Info("hello world", "status", 200, "dangling")
+	require.Contains(t, buf.String(), `message="hello world" status=200 !BADKEY=dangling component=api`+"\n")
+	buf.Reset()
+
+	l.Opts.DefaultFieldsLast = false
+	l.Info("hello world", "status", 200, "dangling")
+	require.Contains(t, buf.String(), `message="hello world" component=api status=200 !BADKEY=dangling`+"\n")
+	buf.Reset()
+
+	// A dangling default value is written last either way.
+	l.DefaultFields = []interface{}{"component", "api", "dangling"}
+	l.Info("hello world", "status", 200)
+	require.Contains(t, buf.String(), `message="hello world" component=api !BADKEY=dangling status=200`+"\n")
+	buf.Reset()
+
+	l.Opts.DefaultFieldsLast = true
+	l.Info("hello world", "status", 200)
+	require.Contains(t, buf.String(), `message="hello world" status=200 component=api !BADKEY=dangling`+"\n")
+	buf.Reset()
+
+	// A namespace opened in the default fields still applies to the
+	// per-call fields, but not to the default fields after them.
+	l = New(Opts{Writer: buf, DefaultFieldsLast: true, DefaultFields: []interface{}{"component", "api", Namespace("req")}})
+	l.Info("hello world", "id", 1)
+	require.Contains(t, buf.String(), `message="hello world" req.id=1 component=api`+"\n")
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Info("hello world", "id", 1)
+	require.Contains(t, buf.String(), `"{""req.id"":1,""component"":""api""}"`)
+}
+
+func TestLogMaxFields(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, MaxFields: 3, DefaultFields: []interface{}{"component", "api"}})
+
+	// Exactly at the limit.
+	l.Info("hello world", "a", 1, Int("b", 2))
+	require.Contains(t, buf.String(), `message="hello world" component=api a=1 b=2`+"\n")
+	buf.Reset()
+
+	// One over.
+	l.Info("hello world", "a", 1, Int("b", 2), "c", 3)
+	require.Contains(t, buf.String(), `message="hello world" component=api a=1 b=2 _truncated_fields=1`+"\n")
+	buf.Reset()
+
+	l.Info("hello world", "a", 1, "b", 2, "c", 3, "d", 4, "dangling")
+	require.Contains(t, buf.String(), `message="hello world" component=api a=1 b=2 _truncated_fields=3`+"\n")
+	buf.Reset()
+
+	// Default fields alone can exceed the limit.
+	l = New(Opts{Writer: buf, MaxFields: 1, OmitEmpty: true, DefaultFields: []interface{}{"empty", "", "component", "api", "env", "prod"}})
+	l.Info("hello world")
+	require.Contains(t, buf.String(), `message="hello world" component=api _truncated_fields=1`+"\n")
+	buf.Reset()
+
+	l.Opts.Format = MsgpackFormat
+	l.Info("hello world", "a", 1)
+	m := decodeMsgpackFrames(t, buf.Bytes())[0]
+	require.Equal(t, "api", m["component"])
+	require.Equal(t, int64(2), m["_truncated_fields"])
+	require.NotContains(t, m, "a")
+}
+
+func TestLogEscapeANSI(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+
+	l.Info("\x1b[2J\x1b[0;0Hcleared", "color", "\x1b[31mred\x1b[0m", "\x1b[1Akey", "up")
+	require.Contains(t, buf.String(), `message="\u001b[2J\u001b[0;0Hcleared" color="\u001b[31mred\u001b[0m" "\u001b[1Akey"=up`+"\n")
+	require.NotContains(t, buf.String(), "\x1b")
+	buf.Reset()
+
+	// Only the logger's own colors are written as is.
+	l = New(Opts{Writer: buf, EnableColor: true})
+	l.Info("hello world", "color", "\x1b[31mred")
+	require.Contains(t, buf.String(), "\x1b[36mcolor\x1b[0m=\"\\u001b[31mred\"\n")
+}
+
+func TestLogNoFieldsAllocs(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, EnableCaller: true})
+	l.Info("hello world")
+	require.Regexp(t, `level=info message="hello world" caller=.*log_test.go:\d+`+"\n", buf.String())
+
+	l = New(Opts{Writer: io.Discard})
+	require.Zero(t, testing.AllocsPerRun(100, func() {
+		l.Info("hello world")
+	}))
+}
+
+func TestLogEncodedDefaults(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, TimestampFormat: "-", OmitEmpty: true, RedactKeys: []string{"token"},
+		DefaultFields: []interface{}{"app", "logf", "n", 1, "empty", "", "token", "x", "d", time.Second}})
+	require.NotNil(t, l.keys.defaults)
+
+	// Lines with and without fields.
+	l.Info("hello")
+	l.Info("world", "a", 1)
+	require.Equal(t, "timestamp=- level=info message=hello app=logf n=1 token=[REDACTED] d=1s\n"+
+		"timestamp=- level=info message=world app=logf n=1 token=[REDACTED] d=1s a=1\n", buf.String())
+	buf.Reset()
+
+	// Derived loggers are encoded again.
+	l.With("b", 2).Named("db").Info("hello")
+	require.Equal(t, "timestamp=- level=info message=hello app=logf n=1 token=[REDACTED] d=1s b=2 scope=db\n", buf.String())
+	buf.Reset()
+
+	// Default fields that are set again or replaced are encoded again.
+	c := l.Clone()
+	c.SetDefaultFields("app", "clone", "n", 1)
+	c.Info("hello")
+	l.DefaultFields = []interface{}{"app", "logf", "n", []int{1}}
+	l.Info("hello")
+	require.Equal(t, "timestamp=- level=info message=hello app=clone n=1\n"+
+		"timestamp=- level=info message=hello app=logf n=[1]\n", buf.String())
+	buf.Reset()
+
+	// Colors are encoded for every level.
+	l = New(Opts{Writer: buf, TimestampFormat: "-", EnableColor: true, DefaultFields: []interface{}{"app", "logf"}})
+	l.Error("hello")
+	require.Contains(t, buf.String(), " "+red+"app"+reset+"=logf\n")
+	buf.Reset()
+
+	// Typed fields and the options that reorder fields across the line
+	// aren't encoded.
+	for _, o := range []Opts{
+		{DefaultFields: []interface{}{String("a", "b")}},
+		{DefaultFields: []interface{}{"a", 1}, SortFields: true},
+		{DefaultFields: []interface{}{"a", 1}, Format: ECSFormat},
+	} {
+		require.Nil(t, New(o).keys.defaults)
+	}
+
+	l = New(Opts{Writer: io.Discard, DefaultFields: []interface{}{"app", "logf"}})
+	require.Zero(t, testing.AllocsPerRun(100, func() {
+		l.Info("hello world")
+	}))
+}
+
+func TestLogDynamicDefaults(t *testing.T) {
+	var n int32
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, TimestampFormat: "-", DefaultFields: []interface{}{
+		"app", "logf",
+		"n", func() interface{} { return atomic.AddInt32(&n, 1) },
+		"err", errors.New("x"),
+		"env", "prod",
+		"nil", Lazy(func() interface{} { return nil }),
+		"panic", func() interface{} { panic("boom") },
+	}})
+	require.Len(t, l.keys.defaults.parts, 6)
+
+	// Providers are called for every line, after the level check.
+	l.Info("hello")
+	l.Debug("skipped")
+	l.Info("world", "a", 1)
+	require.Equal(t, int32(2), atomic.LoadInt32(&n))
+	require.Equal(t, "timestamp=- level=info message=hello app=logf n=1 err=x env=prod nil=null panic=\"!PANIC: boom\"\n"+
+		"timestamp=- level=info message=world app=logf n=2 err=x env=prod nil=null panic=\"!PANIC: boom\" a=1\n", buf.String())
+	buf.Reset()
+
+	// Values written per line can be modified in place.
+	l.DefaultFields[5] = "y"
+	l.Info("hello")
+	require.Equal(t, "timestamp=- level=info message=hello app=logf n=3 err=y env=prod nil=null panic=\"!PANIC: boom\"\n", buf.String())
+}
+
+func TestLogLevelFields(t *testing.T) {
+	exit = func() {}
+	buf := &bytes.Buffer{}
+	levelFields := map[Level][]interface{}{
+		ErrorLevel: {"alert", true},
+		FatalLevel: {"alert", true, "page", Lazy(func() interface{} { return "oncall" })},
+	}
+	l := New(Opts{Writer: buf, TimestampFormat: "-", Level: DebugLevel, LevelFields: levelFields,
+		DefaultFields: []interface{}{"app", "logf"}})
+	require.NotNil(t, l.keys.levelFields[ErrorLevel])
+
+	l.Debug("a")
+	l.Info("b", "n", 1)
+	l.Error("c")
+	l.Error("d", "n", 1)
+	l.Fatal("e")
+	require.Equal(t, "timestamp=- level=debug message=a app=logf\n"+
+		"timestamp=- level=info message=b app=logf n=1\n"+
+		"timestamp=- level=error message=c app=logf alert=true\n"+
+		"timestamp=- level=error message=d app=logf alert=true n=1\n"+
+		"timestamp=- level=fatal message=e app=logf alert=true page=oncall\n", buf.String())
+	buf.Reset()
+
+	// With the default fields last and in JSON.
+	l = New(Opts{Writer: buf, TimestampFormat: "-", DefaultFieldsLast: true, LevelFields: levelFields,
+		DefaultFields: []interface{}{"app", "logf"}})
+	l.Error("a", "n", 1)
+	require.Equal(t, "timestamp=- level=error message=a n=1 app=logf alert=true\n", buf.String())
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, TimestampFormat: "-", Format: ECSFormat, LevelFields: levelFields})
+	l.Info("a")
+	l.Error("b")
+	require.NotContains(t, strings.Split(buf.String(), "\n")[0], "alert")
+	require.Contains(t, strings.Split(buf.String(), "\n")[1], `"message":"b","alert":true}`)
+	buf.Reset()
+
+	// Invalid fields are fixed at New, without modifying the map.
+	odd := map[Level][]interface{}{ErrorLevel: {"alert", true, "dangling"}}
+	l = New(Opts{Writer: buf, TimestampFormat: "-", LevelFields: odd})
+	l.Error("a")
+	require.Equal(t, "timestamp=- level=error message=a alert=true !BADKEY=dangling\n", buf.String())
+	require.Len(t, odd[ErrorLevel], 3)
+	_, err := NewStrict(Opts{LevelFields: odd})
+	require.EqualError(t, err, "error fields: invalid fields: dangling at 2 has no key")
+	_, err = NewStrict(Opts{LevelFields: map[Level][]interface{}{WarnLevel: {"message", "x"}}})
+	require.Error(t, err)
+
+	// Lines with encoded level fields and no other fields don't allocate.
+	l = New(Opts{Writer: io.Discard, LevelFields: levelFields, DefaultFields: []interface{}{"app", "logf"}})
+	require.Zero(t, testing.AllocsPerRun(100, func() {
+		l.Error("hello world")
+	}))
+}
+
+func TestLogNetAddr(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+
+	mac, _ := net.ParseMAC("00:1a:2b:3c:4d:5e")
+	eui64, _ := net.ParseMAC("02:00:5e:10:00:00:00:01")
+	l.Info("hello world",
+		"ip4", net.IPv4(192, 168, 0, 1),
+		"ip6", net.ParseIP("2001:db8::68"),
+		"mapped", net.ParseIP("::ffff:10.0.0.1"),
+		"nil", net.IP(nil),
+		"mac", mac,
+		"eui64", eui64,
+	)
+	require.Contains(t, buf.String(), `ip4=192.168.0.1 ip6=2001:db8::68 mapped=10.0.0.1 nil= mac=00:1a:2b:3c:4d:5e eui64=02:00:5e:10:00:00:00:01`+"\n")
+}
+
+type jsonBody string
+
+func (b jsonBody) MarshalJSON() ([]byte, error) {
+	if b == "" {
+		return nil, errors.New("empty body")
+	}
+	return []byte(b), nil
+}
+
+func TestLogJSONMarshaler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+
+	// The JSON is compacted, and if MarshalJSON fails or returns invalid
+	// JSON, the value is formatted with fmt.
+	l.Info("hello world", "body", jsonBody(`{"a": 1, "b": [true]}`), "list", jsonBody(`[1, 2]`),
+		"bad", jsonBody(""), "invalid", jsonBody("{"))
+	require.Contains(t, buf.String(), `body="{\"a\":1,\"b\":[true]}" list=[1,2] bad= invalid={`+"\n")
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Info("hello world", "body", jsonBody(`{"a": 1}`), "invalid", jsonBody("{"))
+	require.Contains(t, buf.String(), `"{""body"":{""a"":1},""invalid"":""{""}"`)
+	buf.Reset()
+}
+
+func TestTruncate(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"hello", 0, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 4, "hell…"},
+		{"héllo", 2, "h…"},
+		{"héllo", 3, "hé…"},
+		{"日本", 4, "日…"},
+	} {
+		require.Equal(t, c.want, truncate(c.in, c.max), c.in)
+	}
+}
+
+func TestLogMaxLen(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, MaxFieldValueLen: 5, MaxMessageLen: 8})
+
+	l.Info("hello world", "exact", "12345", "over", "123456", "bytes", []byte("héllo"), "rune", "abcd日")
+	require.Contains(t, buf.String(), `message="hello wo…"`)
+	require.Contains(t, buf.String(), `exact=12345 over="12345…(truncated 1 bytes)" bytes="héll…(truncated 1 bytes)" rune="abcd…(truncated 3 bytes)"`+"\n")
+	buf.Reset()
+
+	// Values are cut before they are escaped, so escapes and quotes are kept whole.
+	l.Info("hello world", "quote", `ab"cdef`, String("typed", "a b c d"), "struct", struct{ A, B int }{100, 200})
+	require.Contains(t, buf.String(), `quote="ab\"cd…(truncated 2 bytes)" typed="a b c…(truncated 2 bytes)" struct="{100 …(truncated 4 bytes)"`+"\n")
+	buf.Reset()
+
+	l.Info("12345678")
+	require.Contains(t, buf.String(), `message=12345678`+"\n")
+	buf.Reset()
+}
+
+type derefStringer struct{ name string }
+
+func (d *derefStringer) String() string { return d.name }
+
+type derefError struct{ msg string }
+
+func (d *derefError) Error() string { return d.msg }
+
+type derefText struct{ text string }
+
+func (d *derefText) MarshalText() ([]byte, error) { return []byte(d.text), nil }
+
+func TestLogTypedNil(t *testing.T) {
+	var (
+		s  *derefStringer
+		e  *derefError
+		tm *derefText
+	)
+
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+	require.NotPanics(t, func() {
+		l.Info("hello world", "stringer", s, "error", e, "text", tm)
+	})
+	require.Contains(t, buf.String(), `stringer=null error=null text=null`+"\n")
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, Format: CSVFormat})
+	require.NotPanics(t, func() {
+		l.Info("hello world", "stringer", s, "error", e, "text", tm)
+	})
+	require.Contains(t, buf.String(), `"{""stringer"":null,""error"":null,""text"":null}"`)
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, Format: MsgpackFormat})
+	require.NotPanics(t, func() {
+		l.Info("hello world", "stringer", s, "error", e, "text", tm)
+	})
+	entries := decodeMsgpackFrames(t, buf.Bytes())
+	require.Nil(t, entries[0]["stringer"])
+	require.Contains(t, entries[0], "error")
+	require.Nil(t, entries[0]["error"])
+	require.Nil(t, entries[0]["text"])
+}
+
+func TestLogFuncs(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, Level: WarnLevel, EnableCaller: true})
+
+	calls := 0
+	fn := func() string {
+		calls++
+		return "computed"
+	}
+
+	l.DebugFunc(fn)
+	l.InfoFunc(fn)
+	require.Equal(t, 0, calls)
+	require.Empty(t, buf.String())
+
+	l.WarnFunc(fn, "a", 1)
+	_, _, line, _ := runtime.Caller(0)
+	require.Equal(t, 1, calls)
+	require.Contains(t, buf.String(), `level=warn message=computed caller=`)
+	require.Contains(t, buf.String(), fmt.Sprintf("log_test.go:%d a=1", line-1))
+	buf.Reset()
+
+	l.ErrorFunc(fn)
+	require.Equal(t, 2, calls)
+	require.Contains(t, buf.String(), `level=error message=computed `)
+	buf.Reset()
+}
+
+func TestLogLazyField(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, DefaultFields: []interface{}{"default", LazyField(func() interface{} { return "lazy" })}})
+
+	calls := 0
+	f := LazyField(func() interface{} {
+		calls++
+		return map[string]int{"a": 1}
+	})
+
+	l.Debug("hello world", "data", f)
+	require.Equal(t, 0, calls)
+	require.Empty(t, buf.String())
+
+	l.Info("hello world", "data", f)
+	require.Equal(t, 1, calls)
+	require.Contains(t, buf.String(), `default=lazy data="{\"a\":1}"`+"\n")
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Info("hello world", "data", f)
+	require.Equal(t, 2, calls)
+	require.Contains(t, buf.String(), `"{""default"":""lazy"",""data"":{""a"":1}}"`)
+}
+
+type panicStringer struct{}
+
+func (panicStringer) String() string { panic("boom") }
+
+func TestLogPanickingStringer(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+
+	require.NotPanics(t, func() {
+		l.Info("hello world", "bad", panicStringer{}, "after", 1)
+	})
+	require.Contains(t, buf.String(), `bad="!PANIC: boom" after=1`+"\n")
+	buf.Reset()
+
+	l.Info("still logging")
+	require.Contains(t, buf.String(), `message="still logging"`)
+}
+
+type cyclicError struct{ next error }
+
+func (e *cyclicError) Error() string { return "cycle" }
+func (e *cyclicError) Unwrap() error { return e.next }
+
+func TestLogExpandErrors(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, ExpandErrors: true})
+
+	base := errors.New("no such file")
+	err := fmt.Errorf("read config: %w", fmt.Errorf("open: %w", base))
+
+	l.Error("failed", "error", err, "plain", base)
+	require.Contains(t, buf.String(), `error="read config: open: no such file" error_chain="read config: open: no such file <- open: no such file <- no such file" plain="no such file"`+"\n")
+	require.NotContains(t, buf.String(), "plain_chain")
+	buf.Reset()
+
+	// Cycles are bounded.
+	c := &cyclicError{}
+	c.next = c
+	l.Error("failed", "error", c)
+	require.Contains(t, buf.String(), `error_chain="cycle`+strings.Repeat(" <- cycle", maxErrorChain-1)+`"`)
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Error("failed", "error", fmt.Errorf("a: %w", base))
+	require.Contains(t, buf.String(), `"{""error"":""a: no such file"",""error_chain"":""a: no such file <- no such file""}"`)
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, Format: MsgpackFormat, ExpandErrors: true})
+	l.Error("failed", "error", fmt.Errorf("a: %w", base))
+	entries := decodeMsgpackFrames(t, buf.Bytes())
+	require.Equal(t, "a: no such file <- no such file", entries[0]["error_chain"])
+}
+
+func TestLogNamed(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, DefaultFields: []interface{}{"app", "api"}})
+
+	db := l.Named("db")
+	pool := db.Named("pool")
+
+	pool.Info("hello world")
+	require.Contains(t, buf.String(), `app=api scope=db.pool`+"\n")
+	buf.Reset()
+
+	db.Info("hello world")
+	require.Contains(t, buf.String(), `app=api scope=db`+"\n")
+	require.NotContains(t, buf.String(), "pool")
+	buf.Reset()
+
+	l.Info("hello world")
+	require.NotContains(t, buf.String(), "scope")
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, ScopeKey: "logger", ScopeSeparator: "/"})
+	l.Named("a").Named("b").Named("c").Info("hello world")
+	require.Contains(t, buf.String(), `logger=a/b/c`+"\n")
+	buf.Reset()
+
+	// A scope that isn't a string is joined with the name.
+	l = New(Opts{Writer: buf, DefaultFields: []interface{}{"scope", 5}})
+	l.Named("db").Info("hello world")
+	require.Contains(t, buf.String(), `scope=5.db`+"\n")
+	require.Equal(t, 1, strings.Count(buf.String(), "scope="))
+}
+
+type stackError struct{}
+
+func (stackError) Error() string { return "stack error" }
+func (stackError) Stack() []byte { return []byte("main.main()\n\tmain.go:10") }
+
+// pkgStackTrace mimics github.com/pkg/errors.StackTrace, which prints
+// its frames with %+v.
+type pkgStackTrace []string
+
+func (st pkgStackTrace) Format(s fmt.State, verb rune) {
+	for _, f := range st {
+		fmt.Fprintf(s, "\n%s", f)
+	}
+}
+
+type pkgError struct{}
+
+func (pkgError) Error() string             { return "pkg error" }
+func (pkgError) StackTrace() pkgStackTrace { return pkgStackTrace{"main.a", "main.b"} }
+
+func TestLogErrorStacktrace(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, ErrorStacktrace: true})
+
+	l.Error("failed", "error", stackError{}, "plain", errors.New("plain"))
+	require.Contains(t, buf.String(), `error="stack error" error_stack="main.main()\n\tmain.go:10" plain=plain`+"\n")
+	require.NotContains(t, buf.String(), "plain_stack")
+	buf.Reset()
+
+	// The stack of a wrapped error is found.
+	l.Error("failed", "error", fmt.Errorf("wrapped: %w", pkgError{}))
+	require.Contains(t, buf.String(), `error="wrapped: pkg error" error_stack="main.a\nmain.b"`+"\n")
+	buf.Reset()
+
+	l.Opts.ExpandErrors = true
+	l.Opts.Format = CSVFormat
+	l.Error("failed", "error", fmt.Errorf("wrapped: %w", stackError{}))
+	require.Contains(t, buf.String(), `"{""error"":""wrapped: stack error"",""error_chain"":""wrapped: stack error <- stack error"",""error_stack"":""main.main()\n\tmain.go:10""}"`)
+	buf.Reset()
+}
+
+func TestLogWith(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, DefaultFields: []interface{}{"app", "api"}})
+
+	req := l.With("request_id", 1, "odd")
+	req.With("user", "alice").Info("hello world")
+	require.Contains(t, buf.String(), `app=api request_id=1 !BADKEY=odd user=alice`+"\n")
+	buf.Reset()
+
+	req.Info("hello world")
+	require.Contains(t, buf.String(), `app=api request_id=1 !BADKEY=odd`+"\n")
+	require.NotContains(t, buf.String(), "user")
+	buf.Reset()
+
+	l.Info("hello world")
+	require.NotContains(t, buf.String(), "request_id")
+}
+
+func TestLogLazy(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+
+	l.Debug("hello world", "data", Lazy(func() interface{} { panic("not called") }))
+	require.Empty(t, buf.String())
+
+	l.Info("hello world", "bad", Lazy(func() interface{} { panic("boom") }), "after", 1)
+	require.Contains(t, buf.String(), `bad="!PANIC: boom" after=1`+"\n")
+	buf.Reset()
+
+	// The func is called for every line, concurrently with a shared logger.
+	var (
+		mu    sync.Mutex
+		calls int
+		wg    sync.WaitGroup
+	)
+	f := Lazy(func() interface{} {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		return "v"
+	})
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Info("hello world", "data", f)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 10, calls)
+	require.Equal(t, 10, strings.Count(buf.String(), " data=v\n"))
+}
+
+func TestDiscard(t *testing.T) {
+	l := Discard()
+	require.Equal(t, OffLevel, l.Opts.Level)
+	require.Equal(t, "off", OffLevel.String())
+	for lvl := DebugLevel; lvl <= FatalLevel; lvl++ {
+		require.False(t, l.IsEnabled(lvl))
+	}
+
+	require.NotPanics(t, func() {
+		l.Error("hello world", "a", Lazy(func() interface{} { panic("not called") }))
+	})
+
+	// Discarded lines don't allocate, fields included.
+	require.Zero(t, testing.AllocsPerRun(100, func() {
+		l.Error("hello world", "component", "api", "count", 1)
+	}))
+}
+
+type logUser struct {
+	ID   int
+	Name string
+}
+
+func (u *logUser) LogValue() interface{} { return u.ID }
+func (u *logUser) String() string        { return u.Name }
+func (u *logUser) Error() string         { return u.Name }
+
+// loopValuer returns itself from LogValue.
+type loopValuer struct{}
+
+func (l loopValuer) LogValue() interface{} { return l }
+func (loopValuer) String() string          { return "loop" }
+
+type chainValuer int
+
+func (c chainValuer) LogValue() interface{} {
+	if c == 0 {
+		return "done"
+	}
+	return c - 1
+}
+
+func TestLogValuer(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+
+	var nilUser *logUser
+	l.Info("hello world", "user", &logUser{ID: 1, Name: "alice"}, "nil", nilUser, "chain", chainValuer(2), "loop", loopValuer{})
+	require.Contains(t, buf.String(), `user=1 nil=null chain=done loop=loop`+"\n")
+	require.NotContains(t, buf.String(), "alice")
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Info("hello world", "user", &logUser{ID: 1, Name: "alice"})
+	require.Contains(t, buf.String(), `"{""user"":1}"`)
+}
+
+func TestLogFormatWithDefaultFields(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, DefaultFields: []interface{}{"defaultkey", "defaultvalue"}})
+
+	l.Info("hello world")
+	require.Contains(t, buf.String(), `level=info message="hello world" defaultkey=defaultvalue`)
+	buf.Reset()
+
+	l.Info("hello world", "component", "logf")
+	require.Contains(t, buf.String(), `level=info message="hello world" defaultkey=defaultvalue component=logf`)
+	buf.Reset()
+}
+
+type errWriter struct{}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("dummy error")
+}
+
+func TestIoWriterError(t *testing.T) {
+	w := &errWriter{}
+	l := New(Opts{Writer: w})
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	log.SetFlags(0)
+	l.Info("hello world")
+	require.Contains(t, buf.String(), "error logging: dummy error\n")
+}
+
+func TestWriteQuotedStringCases(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+
+	// cases from
+	// https://github.com/go-logfmt/logfmt/blob/99455b83edb21b32a1f1c0a32f5001b77487b721/encode_test.go
+	data := []struct {
+		key, value interface{}
+		want       string
+	}{
+		{key: "k", value: "v", want: "k=v"},
+		{key: "k", value: nil, want: "k=null"},
+		{key: `\`, value: "v", want: `\=v`},
+		{key: "k", value: "", want: "k="},
+		{key: "k", value: "null", want: `k="null"`},
+		{key: "k", value: "<nil>", want: `k=<nil>`},
+		{key: "k", value: true, want: "k=true"},
+		{key: "k", value: 1, want: "k=1"},
+		{key: "k", value: 1.025, want: "k=1.025"},
+		{key: "k", value: 1e-3, want: "k=0.001"},
+		{key: "k", value: 3.5 + 2i, want: "k=(3.5+2i)"},
+		{key: "k", value: "v v", want: `k="v v"`},
+		{key: "k", value: " ", want: `k=" "`},
+		{key: "k", value: `"`, want: `k="\""`},
+		{key: "k", value: `=`, want: `k="="`},
+		{key: "k", value: `\`, want: `k=\`},
+		{key: "k", value: `=\`, want: `k="=\\"`},
+		{key: "k", value: `\"`, want: `k="\\\""`},
+		{key: "k", value: "\xbd", want: `k="\ufffd"`},
+		{key: "k", value: "\ufffd\x00", want: `k="\ufffd\u0000"`},
+		{key: "k", value: "\ufffd", want: `k="\ufffd"`},
+		{key: "k", value: []byte("\ufffd\x00"), want: `k="\ufffd\u0000"`},
+		{key: "k", value: []byte("\ufffd"), want: `k="\ufffd"`},
+	}
+
+	for _, d := range data {
+		l.Info("hello world", d.key, d.value)
+		require.Contains(t, buf.String(), d.want)
+		buf.Reset()
+	}
+}
+
+func TestLogComplexField(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+
+	for _, c := range []struct {
+		val  interface{}
+		want string
+	}{
+		{3.5 + 2i, "(3.5+2i)"},
+		{complex64(1.5 - 0.25i), "(1.5-0.25i)"},
+		{complex(0, math.Copysign(0, -1)), "(0-0i)"},
+		{complex(math.Inf(-1), math.Inf(1)), "(-Inf+Infi)"},
+		{complex(1, math.NaN()), "(1+NaNi)"},
+	} {
+		l.Info("hello world", "c", c.val)
+		require.Contains(t, buf.String(), " c="+c.want+"\n")
+		require.Equal(t, fmt.Sprintf("%v", c.val), c.want)
+		buf.Reset()
+	}
+}
+
+func TestLogBytesEncoding(t *testing.T) {
+	buf := &bytes.Buffer{}
+	data := []byte{0xde, 0xad, 0xbe, 0xef}
+
+	l := New(Opts{Writer: buf, BytesEncoding: BytesHex})
+	l.Info("hello world", "data", data, "empty", []byte{})
+	require.Contains(t, buf.String(), ` data=deadbeef empty=`+"\n")
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, BytesEncoding: BytesBase64})
+	l.Info("hello world", "data", data, "unpadded", data[:3])
+	require.Contains(t, buf.String(), ` data="3q2+7w==" unpadded=3q2+`+"\n")
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, BytesEncoding: BytesHex, MaxFieldValueLen: 2})
+	l.Info("hello world", "data", data)
+	require.Contains(t, buf.String(), ` data="dead…(truncated 2 bytes)"`+"\n")
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Opts.CSVColumns = []string{"data"}
+	l.Info("hello world", "data", data, "other", data[:1])
+	require.Contains(t, buf.String(), `,dead…(truncated 2 bytes),"{""other"":""de""}"`)
+	buf.Reset()
+}
+
+func TestOddNumberedFields(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+
+	// Give a odd number of fields.
+	l.Info("hello world", "key1", "val1", "key2")
+	require.Contains(t, buf.String(), `level=info message="hello world" key1=val1 !BADKEY=key2`)
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Info("hello world", "key1", "val1", "key2")
+	require.Contains(t, buf.String(), `"{""key1"":""val1"",""!BADKEY"":""key2""}"`)
+	buf.Reset()
+
+	l.Opts.Format = MsgpackFormat
+	l.Info("hello world", "key1", "val1", "key2")
+	frames := decodeMsgpackFrames(t, buf.Bytes())
+	require.Equal(t, "key2", frames[0]["!BADKEY"])
+	require.Len(t, frames[0], 5)
+}
+
+func TestOddNumberedFieldsWithDefaultFields(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, DefaultFields: []interface{}{
+		"defaultkey", "defaultval",
+	}})
+
+	// Give a odd number of fields.
+	l.Info("hello world", "key1", "val1", "key2")
+	require.Contains(t, buf.String(), `level=info message="hello world" defaultkey=defaultval key1=val1 !BADKEY=key2`)
+	buf.Reset()
+
+	// Dangling default fields are kept too.
+	l = New(Opts{Writer: buf, DefaultFields: []interface{}{"defaultkey", "defaultval", "dangling"}})
+	l.With("key1", "val1", 42).Info("hello world", "key2", "val2")
+	require.Contains(t, buf.String(), `level=info message="hello world" defaultkey=defaultval !BADKEY=dangling key1=val1 !BADKEY=42 key2=val2`)
+	buf.Reset()
+}
+
+// These test are typically meant to be run with the data race detector.
+func TestLoggerConcurrency(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+
+	for _, n := range []int{10, 100, 1000} {
+		wg := sync.WaitGroup{}
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			go func() { genLogs(l); wg.Done() }()
+		}
+		wg.Wait()
+	}
+}
+
+func genLogs(l Logger) {
+	for i := 0; i < 100; i++ {
+		l.Info("random log", "index", strconv.FormatInt(int64(i), 10))
+	}
+}
+
+// blockingWriter blocks writes until release is closed.
+type blockingWriter struct {
+	bytes.Buffer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	close(w.started)
+	<-w.release
+	return w.Buffer.Write(p)
+}
+
+func TestLogSetWriter(t *testing.T) {
+	old := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	l := New(Opts{Writer: old})
+	child := l.With("component", "child")
+
+	go l.Info("in flight")
+	<-old.started
+
+	// The swap waits for the write in progress.
+	buf := &bytes.Buffer{}
+	swapped := make(chan struct{})
+	go func() {
+		l.SetWriter(buf)
+		close(swapped)
+	}()
+
+	select {
+	case <-swapped:
+		t.Fatal("writer swapped during a write")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(old.release)
+	<-swapped
+
+	require.Contains(t, old.String(), `message="in flight"`)
+	require.Equal(t, buf, l.Opts.Writer)
+
+	l.Info("hello world")
+	child.Info("hello child")
+	require.Contains(t, buf.String(), `message="hello world"`)
+	require.Contains(t, buf.String(), `message="hello child" component=child`)
+	require.NotContains(t, old.String(), "hello")
+}
+
+func TestLogClone(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, DefaultFields: []interface{}{"app", "logf"}})
+
+	c := l.Clone()
+	c.DefaultFields[1] = "clone"
+	require.Equal(t, "logf", l.DefaultFields[1])
+	c.SetDefaultFields(c.DefaultFields...)
+
+	// The clone writes to the same writer until it is changed.
+	c.Info("hello clone")
+	require.Contains(t, buf.String(), `message="hello clone" app=clone`)
+	buf.Reset()
+
+	cbuf := &bytes.Buffer{}
+	c.SetWriter(cbuf)
+	l.Info("hello world")
+	c.Info("hello clone")
+	require.Contains(t, buf.String(), `message="hello world" app=logf`)
+	require.NotContains(t, buf.String(), "hello clone")
+	require.Contains(t, cbuf.String(), `message="hello clone" app=clone`)
+}
+
+// exclusiveWriter is an io.Writer that counts the writes that overlap
+// another write.
+type exclusiveWriter struct {
+	active, overlaps int32
+}
+
+func (w *exclusiveWriter) Write(p []byte) (int, error) {
+	if atomic.AddInt32(&w.active, 1) > 1 {
+		atomic.AddInt32(&w.overlaps, 1)
+	}
+	time.Sleep(time.Microsecond)
+	atomic.AddInt32(&w.active, -1)
+	return len(p), nil
+}
+
+func TestLogCloneSharedWriter(t *testing.T) {
+	w := &exclusiveWriter{}
+	l := New(Opts{Writer: w})
+	c := l.Clone()
+
+	var wg sync.WaitGroup
+	for _, lg := range []Logger{l, c, l.Clone()} {
+		wg.Add(1)
+		go func(lg Logger) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				lg.Info("hello world")
+			}
+		}(lg)
+	}
+	wg.Wait()
+	require.Zero(t, atomic.LoadInt32(&w.overlaps))
+}
+
+// fmtErr is an error that implements fmt.Formatter, like github.com/pkg/errors.
+type fmtErr struct{}
+
+func (fmtErr) Error() string {
+	return "failed"
+}
+
+func (e fmtErr) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') {
+		fmt.Fprint(s, "failed\nmain.go:10")
+		return
+	}
+	fmt.Fprint(s, e.Error())
+}
+
+func TestLogVerboseValues(t *testing.T) {
+	type foo struct {
+		A int
+	}
+
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+
+	l.Info("hello world", "struct", foo{A: 1}, "error", fmtErr{}, "int", 1)
+	require.Contains(t, buf.String(), `struct={1} error=failed int=1`+"\n")
+	buf.Reset()
+
+	l.Opts.VerboseValues = true
+	l.Info("hello world", "struct", foo{A: 1}, "error", fmtErr{}, "plain", errors.New("plain"), "int", 1)
+	require.Contains(t, buf.String(), `struct={A:1} error="failed\nmain.go:10" plain=plain int=1`+"\n")
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Info("hello world", "struct", foo{A: 1}, "error", fmtErr{})
+	require.Contains(t, buf.String(), `"{""struct"":""{A:1}"",""error"":""failed\nmain.go:10""}"`)
+}
+
+func TestLogSetLevel(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+	child := l.With("component", "child")
+	require.Equal(t, InfoLevel, l.GetLevel())
+
+	l.Debug("hidden")
+	l.SetLevel(DebugLevel)
+	require.Equal(t, DebugLevel, child.GetLevel())
+	child.Debug("shown")
+	require.NotContains(t, buf.String(), "hidden")
+	require.Contains(t, buf.String(), `level=debug message=shown component=child`)
+	buf.Reset()
+
+	// Clones have a level of their own.
+	c := l.Clone()
+	c.SetLevel(ErrorLevel)
+	require.Equal(t, DebugLevel, l.GetLevel())
+
+	// Opts.Level is the initial level, and that of a clone the level it
+	// was cloned with.
+	require.Equal(t, l.GetLevel(), c.Opts.Level)
+	require.Equal(t, ErrorLevel, c.Clone().Opts.Level)
+
+	// A shared AtomicLevel changes the levels of all its loggers.
+	lvl := NewAtomicLevel(WarnLevel)
+	a := New(Opts{Writer: buf, AtomicLevel: lvl})
+	b := New(Opts{Writer: buf, AtomicLevel: lvl, Level: DebugLevel})
+	require.Equal(t, WarnLevel, a.Opts.Level)
+	require.False(t, b.IsEnabled(InfoLevel))
+	lvl.SetLevel(InfoLevel)
+	require.True(t, a.IsEnabled(InfoLevel))
+	require.True(t, b.IsEnabled(InfoLevel))
+
+	// Levels can be changed while logging.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if i == 0 {
+					l.SetLevel(Level(j%5 + 1))
+				}
+				l.Info("hello world")
+			}
+		}(i)
 	}
+	wg.Wait()
 }
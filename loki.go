@@ -0,0 +1,271 @@
+package logf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLokiFlushInterval = time.Second
+	defaultLokiBatchSize     = 1000
+	defaultLokiTimeout       = 10 * time.Second
+)
+
+// LokiOpts represents the config options for a LokiWriter.
+type LokiOpts struct {
+	// FlushInterval is the interval at which queued lines are pushed.
+	// Defaults to 1s.
+	FlushInterval time.Duration
+
+	// MaxBatchSize is the number of queued lines that triggers a push
+	// before the interval is up. Defaults to 1000.
+	MaxBatchSize int
+
+	// HTTPClient pushes the lines. Defaults to a client with a 10s timeout.
+	HTTPClient *http.Client
+}
+
+// LokiWriter is an io.Writer that queues lines and pushes them in batches
+// to the push API of Grafana Loki, eg:
+//
+//	w, err := logf.NewLokiWriter("http://loki:3100/loki/api/v1/push",
+//		map[string]string{"app": "api"}, logf.LokiOpts{})
+//	l := logf.New(logf.Opts{Writer: w})
+//	defer w.Close()
+//
+// Lines are pushed in the background, so writes don't block on the API.
+// Push errors are returned by the next Flush or Close.
+type LokiWriter struct {
+	url    string
+	labels []byte
+	opts   LokiOpts
+
+	mu      sync.Mutex
+	batch   []lokiLine
+	closed  bool
+	pushing sync.Mutex
+
+	full chan struct{}
+	stop chan struct{}
+	done chan struct{}
+
+	errMu sync.Mutex
+	err   error
+}
+
+// lokiLine is a queued line and the time it was written at.
+type lokiLine struct {
+	ts   int64
+	line []byte
+}
+
+// NewLokiWriter returns a LokiWriter that pushes lines to the push API at
+// pushURL as a single stream with the given labels, which Loki requires
+// at least one of. Close should be called to push queued lines before
+// the program exits.
+func NewLokiWriter(pushURL string, labels map[string]string, opts LokiOpts) (*LokiWriter, error) {
+	u, err := url.Parse(pushURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Loki URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid Loki URL scheme: %q", u.Scheme)
+	}
+	if len(labels) == 0 {
+		return nil, errors.New("Loki streams need at least one label")
+	}
+
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultLokiFlushInterval
+	}
+	if opts.MaxBatchSize <= 0 {
+		opts.MaxBatchSize = defaultLokiBatchSize
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: defaultLokiTimeout}
+	}
+
+	w := &LokiWriter{
+		url:    pushURL,
+		labels: lokiLabels(labels),
+		opts:   opts,
+		full:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+
+	return w, nil
+}
+
+// lokiLabels returns the labels as a JSON object, with the keys sorted.
+func lokiLabels(labels map[string]string) []byte {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf byteBuffer
+	buf.AppendByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			buf.AppendByte(',')
+		}
+		writeQuotedString(&buf, k)
+		buf.AppendByte(':')
+		writeQuotedString(&buf, labels[k])
+	}
+	buf.AppendByte('}')
+
+	return buf.B
+}
+
+// Write queues a copy of p, without its line ending, to be pushed.
+func (w *LokiWriter) Write(p []byte) (int, error) {
+	// The logger reuses its buffers, so the line has to be copied.
+	line := append([]byte(nil), bytes.TrimRight(p, "\r\n\x00")...)
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return 0, ErrWriterClosed
+	}
+	w.batch = append(w.batch, lokiLine{ts: time.Now().UnixNano(), line: line})
+	full := len(w.batch) >= w.opts.MaxBatchSize
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.full <- struct{}{}:
+		default:
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush pushes the queued lines and returns the first push error since
+// the last Flush or Close.
+func (w *LokiWriter) Flush() error {
+	w.mu.Lock()
+	closed := w.closed
+	w.mu.Unlock()
+	if closed {
+		return ErrWriterClosed
+	}
+
+	w.push()
+	return w.takeErr()
+}
+
+// Close pushes the queued lines and stops the background goroutine.
+// It returns the first push error since the last Flush.
+func (w *LokiWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrWriterClosed
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.stop)
+	<-w.done
+
+	w.push()
+	return w.takeErr()
+}
+
+// run pushes the queued lines every FlushInterval, or as soon as there
+// are MaxBatchSize of them, until the writer is closed.
+func (w *LokiWriter) run() {
+	defer close(w.done)
+
+	t := time.NewTicker(w.opts.FlushInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-w.full:
+		case <-w.stop:
+			return
+		}
+		w.push()
+	}
+}
+
+// push sends the queued lines to Loki in a single request and records
+// the error, if any. Lines that fail to push are dropped.
+func (w *LokiWriter) push() {
+	// Pushes are serialized so that batches arrive in order.
+	w.pushing.Lock()
+	defer w.pushing.Unlock()
+
+	w.mu.Lock()
+	batch := w.batch
+	w.batch = nil
+	w.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := w.send(batch); err != nil {
+		w.errMu.Lock()
+		if w.err == nil {
+			w.err = err
+		}
+		w.errMu.Unlock()
+	}
+}
+
+// send posts a batch of lines as a push API request.
+func (w *LokiWriter) send(batch []lokiLine) error {
+	var buf byteBuffer
+	buf.AppendString(`{"streams":[{"stream":`)
+	buf.B = append(buf.B, w.labels...)
+	buf.AppendString(`,"values":[`)
+	for i, l := range batch {
+		if i > 0 {
+			buf.AppendByte(',')
+		}
+		// Timestamps are strings of Unix nanoseconds.
+		buf.AppendString(`["`)
+		buf.B = strconv.AppendInt(buf.B, l.ts, 10)
+		buf.AppendString(`",`)
+		writeQuotedString(&buf, string(l.line))
+		buf.AppendByte(']')
+	}
+	buf.AppendString(`]}]}`)
+
+	resp, err := w.opts.HTTPClient.Post(w.url, "application/json", bytes.NewReader(buf.B))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Loki push failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// takeErr returns and clears the recorded push error.
+func (w *LokiWriter) takeErr() error {
+	w.errMu.Lock()
+	err := w.err
+	w.err = nil
+	w.errMu.Unlock()
+	return err
+}
@@ -0,0 +1,138 @@
+package logf
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type lokiPush struct {
+	Streams []struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	} `json:"streams"`
+}
+
+// lokiServer records the push requests it receives.
+type lokiServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	pushes []lokiPush
+	status int
+}
+
+func newLokiServer(t *testing.T) *lokiServer {
+	s := &lokiServer{status: http.StatusNoContent}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/loki/api/v1/push", r.URL.Path)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		var p lokiPush
+		require.NoError(t, json.Unmarshal(b, &p))
+
+		s.mu.Lock()
+		s.pushes = append(s.pushes, p)
+		status := s.status
+		s.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *lokiServer) get() []lokiPush {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]lokiPush(nil), s.pushes...)
+}
+
+func TestLokiWriter(t *testing.T) {
+	s := newLokiServer(t)
+	url := s.URL + "/loki/api/v1/push"
+	labels := map[string]string{"app": "api", "env": "prod"}
+
+	w, err := NewLokiWriter(url, labels, LokiOpts{FlushInterval: time.Hour})
+	require.NoError(t, err)
+	l := New(Opts{Writer: w, TimestampFormat: "-"})
+
+	before := time.Now().UnixNano()
+	l.Info("hello", "a", 1)
+	l.Error("world \"quoted\"")
+	require.Empty(t, s.get())
+
+	// Close pushes the queued lines in a single stream.
+	require.NoError(t, w.Close())
+	pushes := s.get()
+	require.Len(t, pushes, 1)
+	require.Len(t, pushes[0].Streams, 1)
+	require.Equal(t, labels, pushes[0].Streams[0].Stream)
+
+	vals := pushes[0].Streams[0].Values
+	require.Len(t, vals, 2)
+	require.Equal(t, `timestamp=- level=info message=hello a=1`, vals[0][1])
+	require.Equal(t, `timestamp=- level=error message="world \"quoted\""`, vals[1][1])
+	for _, v := range vals {
+		ts, err := strconv.ParseInt(v[0], 10, 64)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, ts, before)
+	}
+
+	_, err = w.Write([]byte("x\n"))
+	require.Equal(t, ErrWriterClosed, err)
+	require.Equal(t, ErrWriterClosed, w.Flush())
+	require.Equal(t, ErrWriterClosed, w.Close())
+
+	// A full batch is pushed without waiting for the interval.
+	s = newLokiServer(t)
+	w, err = NewLokiWriter(s.URL+"/loki/api/v1/push", labels, LokiOpts{FlushInterval: time.Hour, MaxBatchSize: 3})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = w.Write([]byte("line " + strconv.Itoa(i) + "\n"))
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool { return len(s.get()) == 1 }, time.Second, time.Millisecond)
+	require.Len(t, s.get()[0].Streams[0].Values, 3)
+
+	// Queued lines are pushed every interval.
+	require.NoError(t, w.Close())
+	w, err = NewLokiWriter(s.URL+"/loki/api/v1/push", labels, LokiOpts{FlushInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	_, err = w.Write([]byte("tick\n"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(s.get()) == 2 }, time.Second, time.Millisecond)
+	require.Equal(t, "tick", s.get()[1].Streams[0].Values[0][1])
+	require.NoError(t, w.Close())
+
+	// Failed pushes are returned by Flush.
+	s = newLokiServer(t)
+	s.status = http.StatusBadRequest
+	w, err = NewLokiWriter(s.URL+"/loki/api/v1/push", labels, LokiOpts{FlushInterval: time.Hour})
+	require.NoError(t, err)
+	_, err = w.Write([]byte("bad\n"))
+	require.NoError(t, err)
+	require.EqualError(t, w.Flush(), "Loki push failed: 400 Bad Request")
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Close())
+
+	// Invalid config.
+	_, err = NewLokiWriter("://nope", labels, LokiOpts{})
+	require.Error(t, err)
+	_, err = NewLokiWriter("ftp://loki", labels, LokiOpts{})
+	require.Error(t, err)
+	_, err = NewLokiWriter(url, nil, LokiOpts{})
+	require.Error(t, err)
+}
@@ -0,0 +1,305 @@
+package logf
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+// msgpack type markers.
+// ref: https://github.com/msgpack/msgpack/blob/master/spec.md
+const (
+	mpNil     = 0xc0
+	mpFalse   = 0xc2
+	mpTrue    = 0xc3
+	mpBin8    = 0xc4
+	mpBin16   = 0xc5
+	mpBin32   = 0xc6
+	mpFloat32 = 0xca
+	mpFloat64 = 0xcb
+	mpUint64  = 0xcf
+	mpInt64   = 0xd3
+	mpFixStr  = 0xa0
+	mpStr8    = 0xd9
+	mpStr16   = 0xda
+	mpStr32   = 0xdb
+	mpMap32   = 0xdf
+)
+
+// writeMsgpackEntry writes a complete log entry as a length prefixed
+// msgpack map into the buffer.
+func (l *Logger) writeMsgpackEntry(buf *byteBuffer, msg string, lvl Level, fn, file string, line int, fields []interface{}, typed []Field) {
+	// Reserve space for the frame length and the map header. Both are
+	// filled in once the number of fields is known.
+	start := len(buf.B)
+	buf.B = append(buf.B, 0, 0, 0, 0, mpMap32, 0, 0, 0, 0)
+
+	count := 3
+	writeMsgpackString(buf, l.keys.names[timestampKey])
+	writeMsgpackInt(buf, time.Now().UnixNano())
+	writeMsgpackString(buf, l.keys.names[levelKey])
+	writeMsgpackInt(buf, int64(lvl))
+	writeMsgpackString(buf, l.keys.names[messageKey])
+	writeMsgpackString(buf, msg)
+
+	if l.Opts.EnableCaller {
+		var tmp [20]byte
+		ln := strconv.AppendInt(tmp[:0], int64(line), 10)
+
+		writeMsgpackString(buf, l.keys.names[callerKey])
+		writeMsgpackStrHeader(buf, len(file)+1+len(ln))
+		buf.AppendString(file)
+		buf.AppendByte(':')
+		buf.B = append(buf.B, ln...)
+		count++
+	}
+	if l.Opts.EnableCallerFunc {
+		writeMsgpackString(buf, l.keys.names[funcKey])
+		writeMsgpackString(buf, fn)
+		count++
+	}
+
+	count += l.writeFields(buf, lvl, fields, typed)
+
+	binary.BigEndian.PutUint32(buf.B[start:], uint32(len(buf.B)-start-4))
+	binary.BigEndian.PutUint32(buf.B[start+5:], uint32(count))
+}
+
+// writeMsgpackValue writes a field value into the buffer as msgpack.
+func (o *valueOpts) writeMsgpackValue(buf *byteBuffer, val interface{}) {
+	switch v := val.(type) {
+	case nil:
+		buf.AppendByte(mpNil)
+	case []byte:
+		writeMsgpackBin(buf, v)
+	case string:
+		writeMsgpackString(buf, v)
+	case int:
+		writeMsgpackInt(buf, int64(v))
+	case int8:
+		writeMsgpackInt(buf, int64(v))
+	case int16:
+		writeMsgpackInt(buf, int64(v))
+	case int32:
+		writeMsgpackInt(buf, int64(v))
+	case int64:
+		writeMsgpackInt(buf, v)
+	case uint:
+		writeMsgpackUint(buf, uint64(v))
+	case uint8:
+		writeMsgpackUint(buf, uint64(v))
+	case uint16:
+		writeMsgpackUint(buf, uint64(v))
+	case uint32:
+		writeMsgpackUint(buf, uint64(v))
+	case uint64:
+		writeMsgpackUint(buf, v)
+	case uintptr:
+		o.writeMsgpackPointer(buf, v)
+	case unsafe.Pointer:
+		o.writeMsgpackPointer(buf, uintptr(v))
+	case float32:
+		buf.AppendByte(mpFloat32)
+		appendUint32(buf, math.Float32bits(v))
+	case float64:
+		buf.AppendByte(mpFloat64)
+		appendUint64(buf, math.Float64bits(v))
+	case bool:
+		if v {
+			buf.AppendByte(mpTrue)
+		} else {
+			buf.AppendByte(mpFalse)
+		}
+	case time.Time:
+		if v.IsZero() {
+			buf.AppendByte(mpNil)
+			return
+		}
+
+		// Format the time first, as the length of the header depends on
+		// it and user layouts can be of any length.
+		var tmp [64]byte
+		t := v.AppendFormat(tmp[:0], o.fieldTimeFormat)
+		writeMsgpackStrHeader(buf, len(t))
+		buf.B = append(buf.B, t...)
+	case time.Duration:
+		switch o.durationFormat {
+		case DurationSeconds:
+			buf.AppendByte(mpFloat64)
+			appendUint64(buf, math.Float64bits(v.Seconds()))
+		case DurationMillis:
+			writeMsgpackInt(buf, v.Milliseconds())
+		default:
+			// Formatted durations are at most 25 bytes long and always
+			// fit a fixstr, whose header is filled in once formatted.
+			buf.AppendByte(0)
+			n := len(buf.B)
+			buf.AppendDuration(v)
+			buf.B[n-1] = mpFixStr | byte(len(buf.B)-n)
+		}
+	case *string, *int, *int32, *int64, *uint32, *uint64, *float32, *float64, *bool, *time.Time:
+		o.writeMsgpackValue(buf, derefBasic(v))
+	case *big.Int, *big.Float, *big.Rat:
+		if isNilPointer(v) {
+			buf.AppendByte(mpNil)
+			return
+		}
+		tmp := bufPool.Get()
+		tmp.AppendBig(v)
+		writeMsgpackStrHeader(buf, len(tmp.B))
+		buf.B = append(buf.B, tmp.B...)
+		bufPool.Put(tmp)
+	case ObjectMarshaler:
+		// Objects are written as fields by writeField, so this is a nil pointer
+		// or an object nested in another, which is written as JSON.
+		if isNilPointer(v) {
+			buf.AppendByte(mpNil)
+			return
+		}
+		tmp := bufPool.Get()
+		o.writeJSONValue(tmp, v, 0)
+		writeMsgpackString(buf, string(tmp.Bytes()))
+		bufPool.Put(tmp)
+	case error, fmt.Stringer, encoding.TextMarshaler:
+		if isNilPointer(v) {
+			buf.AppendByte(mpNil)
+			return
+		}
+		if tmp, ok := typeEncoded(v); ok {
+			writeMsgpackStrHeader(buf, len(tmp.B))
+			buf.B = append(buf.B, tmp.B...)
+			bufPool.Put(tmp)
+			return
+		}
+		writeMsgpackString(buf, o.textValue(v))
+	default:
+		if tmp, ok := typeEncoded(val); ok {
+			writeMsgpackStrHeader(buf, len(tmp.B))
+			buf.B = append(buf.B, tmp.B...)
+			bufPool.Put(tmp)
+			return
+		}
+		if b, ok := marshalJSON(val); ok {
+			writeMsgpackStrHeader(buf, len(b))
+			buf.B = append(buf.B, b...)
+			return
+		}
+		if rv := reflect.ValueOf(val); o.dereferencePointers && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				buf.AppendByte(mpNil)
+				return
+			}
+			if o.derefs >= maxNestingDepth {
+				writeMsgpackString(buf, "...")
+				return
+			}
+			o.derefs++
+			o.writeMsgpackValue(buf, rv.Elem().Interface())
+			o.derefs--
+			return
+		}
+		writeMsgpackString(buf, o.sprintValue(val))
+	}
+}
+
+// writeMsgpackPointer writes a uintptr as a 0x prefixed hex string, or as
+// an unsigned integer if DecimalPointers is set.
+func (o *valueOpts) writeMsgpackPointer(buf *byteBuffer, p uintptr) {
+	if o.decimalPointers {
+		writeMsgpackUint(buf, uint64(p))
+		return
+	}
+
+	// A 64 bit pointer is at most 18 bytes long in hex and always fits a
+	// fixstr, whose header is filled in once formatted.
+	buf.AppendByte(0)
+	n := len(buf.B)
+	o.writePointerValue(buf, p, false)
+	buf.B[n-1] = mpFixStr | byte(len(buf.B)-n)
+}
+
+// writeMsgpackInt writes an integer as a positive or negative fixint
+// where it fits, and as an int64 otherwise.
+func writeMsgpackInt(buf *byteBuffer, i int64) {
+	if i >= -32 && i <= 127 {
+		buf.AppendByte(byte(i))
+		return
+	}
+
+	buf.AppendByte(mpInt64)
+	appendUint64(buf, uint64(i))
+}
+
+// writeMsgpackUint writes an unsigned integer as a positive fixint
+// where it fits, and as a uint64 otherwise.
+func writeMsgpackUint(buf *byteBuffer, i uint64) {
+	if i <= 127 {
+		buf.AppendByte(byte(i))
+		return
+	}
+
+	buf.AppendByte(mpUint64)
+	appendUint64(buf, i)
+}
+
+// writeMsgpackString writes a string along with its header.
+func writeMsgpackString(buf *byteBuffer, s string) {
+	writeMsgpackStrHeader(buf, len(s))
+	buf.AppendString(s)
+}
+
+// writeMsgpackStrHeader writes the header for a string of length n.
+func writeMsgpackStrHeader(buf *byteBuffer, n int) {
+	switch {
+	case n < 32:
+		buf.AppendByte(mpFixStr | byte(n))
+	case n <= math.MaxUint8:
+		buf.AppendByte(mpStr8)
+		buf.AppendByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.AppendByte(mpStr16)
+		appendUint16(buf, uint16(n))
+	default:
+		buf.AppendByte(mpStr32)
+		appendUint32(buf, uint32(n))
+	}
+}
+
+// writeMsgpackBin writes a byte slice as msgpack bin.
+func writeMsgpackBin(buf *byteBuffer, b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		buf.AppendByte(mpBin8)
+		buf.AppendByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.AppendByte(mpBin16)
+		appendUint16(buf, uint16(n))
+	default:
+		buf.AppendByte(mpBin32)
+		appendUint32(buf, uint32(n))
+	}
+	buf.B = append(buf.B, b...)
+}
+
+// appendUint16 appends v to the buffer in big-endian order.
+func appendUint16(buf *byteBuffer, v uint16) {
+	buf.B = append(buf.B, byte(v>>8), byte(v))
+}
+
+// appendUint32 appends v to the buffer in big-endian order.
+func appendUint32(buf *byteBuffer, v uint32) {
+	buf.B = append(buf.B, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// appendUint64 appends v to the buffer in big-endian order.
+func appendUint64(buf *byteBuffer, v uint64) {
+	buf.B = append(buf.B, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+		byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
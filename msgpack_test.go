@@ -0,0 +1,184 @@
+package logf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// mpDecoder is a minimal msgpack decoder for the subset of types
+// emitted by the msgpack encoder.
+type mpDecoder struct {
+	b []byte
+}
+
+func (d *mpDecoder) next(n int) []byte {
+	out := d.b[:n]
+	d.b = d.b[n:]
+	return out
+}
+
+func (d *mpDecoder) decode() (interface{}, error) {
+	if len(d.b) == 0 {
+		return nil, errors.New("unexpected end of input")
+	}
+
+	c := d.next(1)[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == mpFixStr:
+		return string(d.next(int(c & 0x1f))), nil
+	}
+
+	switch c {
+	case mpNil:
+		return nil, nil
+	case mpFalse:
+		return false, nil
+	case mpTrue:
+		return true, nil
+	case mpUint64:
+		return binary.BigEndian.Uint64(d.next(8)), nil
+	case mpInt64:
+		return int64(binary.BigEndian.Uint64(d.next(8))), nil
+	case mpFloat32:
+		return math.Float32frombits(binary.BigEndian.Uint32(d.next(4))), nil
+	case mpFloat64:
+		return math.Float64frombits(binary.BigEndian.Uint64(d.next(8))), nil
+	case mpStr8:
+		return string(d.next(int(d.next(1)[0]))), nil
+	case mpStr16:
+		return string(d.next(int(binary.BigEndian.Uint16(d.next(2))))), nil
+	case mpStr32:
+		return string(d.next(int(binary.BigEndian.Uint32(d.next(4))))), nil
+	case mpBin8:
+		return append([]byte{}, d.next(int(d.next(1)[0]))...), nil
+	case mpBin16:
+		return append([]byte{}, d.next(int(binary.BigEndian.Uint16(d.next(2))))...), nil
+	case mpBin32:
+		return append([]byte{}, d.next(int(binary.BigEndian.Uint32(d.next(4))))...), nil
+	case mpMap32:
+		n := int(binary.BigEndian.Uint32(d.next(4)))
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			k, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			m[k.(string)] = v
+		}
+		return m, nil
+	}
+
+	return nil, fmt.Errorf("unsupported msgpack type 0x%x", c)
+}
+
+// decodeMsgpackFrames splits a stream of length prefixed frames and decodes each.
+func decodeMsgpackFrames(t *testing.T, b []byte) []map[string]interface{} {
+	var out []map[string]interface{}
+	for len(b) > 0 {
+		require.GreaterOrEqual(t, len(b), 4, "frame length prefix")
+		n := int(binary.BigEndian.Uint32(b))
+		require.GreaterOrEqual(t, len(b)-4, n, "frame body")
+
+		d := &mpDecoder{b: b[4 : 4+n]}
+		v, err := d.decode()
+		require.NoError(t, err)
+		require.Empty(t, d.b, "frame should be fully consumed")
+		out = append(out, v.(map[string]interface{}))
+		b = b[4+n:]
+	}
+	return out
+}
+
+func TestMsgpackFormat(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, Format: MsgpackFormat, DefaultFields: []interface{}{"component", "logf"}})
+
+	long := string(bytes.Repeat([]byte("a"), 300))
+	l.Info("hello world",
+		"string", "foo",
+		"long", long,
+		"int", 1,
+		"negative", -1000,
+		"int64", int64(math.MaxInt64),
+		"uint8", uint8(200),
+		"uint64", uint64(math.MaxUint64),
+		"float32", float32(1.5),
+		"float64", 1.025,
+		"bool", true,
+		"nil", nil,
+		"bytes", []byte{0, 1, 2},
+		"error", errors.New("fake error"),
+		"struct", struct{ A int }{A: 1},
+	)
+	l.Error("second line")
+
+	entries := decodeMsgpackFrames(t, buf.Bytes())
+	require.Len(t, entries, 2)
+
+	e := entries[0]
+	require.IsType(t, int64(0), e["timestamp"])
+	require.Equal(t, int64(InfoLevel), e["level"])
+	require.Equal(t, "hello world", e["message"])
+	require.Equal(t, "logf", e["component"])
+	require.Equal(t, "foo", e["string"])
+	require.Equal(t, long, e["long"])
+	require.Equal(t, int64(1), e["int"])
+	require.Equal(t, int64(-1000), e["negative"])
+	require.Equal(t, int64(math.MaxInt64), e["int64"])
+	require.Equal(t, uint64(200), e["uint8"])
+	require.Equal(t, uint64(math.MaxUint64), e["uint64"])
+	require.Equal(t, float32(1.5), e["float32"])
+	require.Equal(t, 1.025, e["float64"])
+	require.Equal(t, true, e["bool"])
+	require.Nil(t, e["nil"])
+	require.Contains(t, e, "nil")
+	require.Equal(t, []byte{0, 1, 2}, e["bytes"])
+	require.Equal(t, "fake error", e["error"])
+	require.Equal(t, "{1}", e["struct"])
+
+	require.Equal(t, int64(ErrorLevel), entries[1]["level"])
+	require.Equal(t, "second line", entries[1]["message"])
+	buf.Reset()
+
+	// Times formatted with layouts of any length keep the frame intact.
+	at := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	layout := strings.Repeat("2006-01-02 ", 30)
+	l = New(Opts{Writer: buf, Format: MsgpackFormat, FieldTimeFormat: layout})
+	l.Info("hello world", "at", at, "after", 1)
+	e = decodeMsgpackFrames(t, buf.Bytes())[0]
+	require.Equal(t, at.Format(layout), e["at"])
+	require.Equal(t, int64(1), e["after"])
+}
+
+func TestMsgpackFormatWithCaller(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, Format: MsgpackFormat, EnableCaller: true})
+
+	l.Info("hello world")
+	entries := decodeMsgpackFrames(t, buf.Bytes())
+	require.Len(t, entries, 1)
+	require.Contains(t, entries[0]["caller"], "msgpack_test.go:")
+	buf.Reset()
+
+	l = New(Opts{Writer: buf, Format: MsgpackFormat, EnableCaller: true, EnableCallerFunc: true})
+	l.Info("hello world")
+	entries = decodeMsgpackFrames(t, buf.Bytes())
+	require.Len(t, entries, 1)
+	require.Equal(t, "logf.TestMsgpackFormatWithCaller", entries[0]["func"])
+}
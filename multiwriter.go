@@ -0,0 +1,68 @@
+package logf
+
+import "io"
+
+// LevelWriter is an io.Writer that is also told the level of every log line.
+// If the logger's Writer implements it, WriteLevel is used instead of Write.
+type LevelWriter interface {
+	io.Writer
+	WriteLevel(lvl Level, p []byte) error
+}
+
+// WriterEntry is a writer that receives log lines of Level and above.
+type WriterEntry struct {
+	Writer io.Writer
+	Level  Level
+}
+
+// MultiWriter fans out log lines to multiple writers, each filtered by its
+// own minimum level. For eg, debug logs to a file and errors to stderr:
+//
+//	logf.New(logf.Opts{
+//		Level: logf.DebugLevel,
+//		Writer: logf.NewMultiWriter(
+//			logf.WriterEntry{Writer: file, Level: logf.DebugLevel},
+//			logf.WriterEntry{Writer: os.Stderr, Level: logf.ErrorLevel},
+//		),
+//	})
+type MultiWriter struct {
+	entries []WriterEntry
+}
+
+// NewMultiWriter returns a MultiWriter for the given entries.
+func NewMultiWriter(entries ...WriterEntry) *MultiWriter {
+	return &MultiWriter{entries: entries}
+}
+
+// WriteLevel writes p to every writer whose level threshold is met.
+// All writers are written to and the first error is returned.
+func (m *MultiWriter) WriteLevel(lvl Level, p []byte) error {
+	var err error
+	for _, e := range m.entries {
+		if lvl < e.Level {
+			continue
+		}
+
+		if _, wErr := e.Writer.Write(p); wErr != nil && err == nil {
+			err = wErr
+		}
+	}
+
+	return err
+}
+
+// Write writes p to every writer, irrespective of its level, as
+// the level of p isn't known.
+func (m *MultiWriter) Write(p []byte) (int, error) {
+	var err error
+	for _, e := range m.entries {
+		if _, wErr := e.Writer.Write(p); wErr != nil && err == nil {
+			err = wErr
+		}
+	}
+
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,45 @@
+package logf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiWriter(t *testing.T) {
+	var (
+		debug = &bytes.Buffer{}
+		errs  = &bytes.Buffer{}
+	)
+
+	l := New(Opts{
+		Level: DebugLevel,
+		Writer: NewMultiWriter(
+			WriterEntry{Writer: debug, Level: DebugLevel},
+			WriterEntry{Writer: errs, Level: ErrorLevel},
+		),
+	})
+
+	l.Debug("debug log")
+	l.Info("info log")
+	l.Error("error log")
+
+	require.Equal(t, 3, strings.Count(debug.String(), "\n"))
+	require.Equal(t, 1, strings.Count(errs.String(), "\n"))
+	require.Contains(t, errs.String(), `level=error message="error log"`)
+}
+
+func TestMultiWriterError(t *testing.T) {
+	buf := &bytes.Buffer{}
+	m := NewMultiWriter(
+		WriterEntry{Writer: &errWriter{}, Level: DebugLevel},
+		WriterEntry{Writer: buf, Level: DebugLevel},
+	)
+
+	require.EqualError(t, m.WriteLevel(InfoLevel, []byte("a\n")), "dummy error")
+	_, err := m.Write([]byte("b\n"))
+	require.EqualError(t, err, "dummy error")
+	require.Equal(t, "a\nb\n", buf.String(), "every writer is written to")
+}
@@ -0,0 +1,222 @@
+package logf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ObjectEncoder adds the fields of an ObjectMarshaler to a log line.
+// It must not be used after MarshalLogObject returns, after which the
+// fields added to it are dropped.
+type ObjectEncoder interface {
+	AddString(key, val string)
+	AddInt(key string, val int64)
+	AddFloat(key string, val float64)
+	AddBool(key string, val bool)
+	AddAny(key string, val interface{})
+}
+
+// ObjectMarshaler is implemented by types that add their fields to a log
+// line themselves, without reflection or fmt. In logfmt and msgpack, the
+// fields are written with the field's key as a prefix, eg: user.id=1
+// user.name=alice, and in JSON (CSV) as an object. If MarshalLogObject
+// returns an error, it is written as <key>_error.
+type ObjectMarshaler interface {
+	MarshalLogObject(enc ObjectEncoder) error
+}
+
+// objectEncoder is the ObjectEncoder that writes to the buffer of a log line.
+// It only exposes methods that escape what they write, so an object can't
+// break the line.
+type objectEncoder struct {
+	opts   valueOpts
+	buf    *byteBuffer
+	prefix string
+
+	// color is the color of the keys in logfmt, if colors are enabled.
+	color string
+
+	// json writes the fields as members of a JSON object, and msgpack
+	// as msgpack pairs.
+	json    bool
+	msgpack bool
+	depth   int
+
+	// n is the number of fields written.
+	n int
+}
+
+// writeObject writes the fields of an object prefixed with key into the
+// buffer in the configured format and returns the number of pairs written.
+func (l *Logger) writeObject(buf *byteBuffer, key string, om ObjectMarshaler, lvl Level) int {
+	// Encoders aren't pooled, as MarshalLogObject may keep the encoder
+	// and write through it after the line is written. Its writes are
+	// dropped once it is released.
+	e := &objectEncoder{opts: l.values(), buf: buf, prefix: key, color: l.keyColor(lvl),
+		msgpack: l.Opts.Format == MsgpackFormat}
+	errMsg := marshalObject(om, e)
+	n := e.n
+	e.buf = nil
+
+	if errMsg != "" {
+		l.writeFieldValue(buf, key+"_error", errMsg, lvl)
+		n++
+	}
+
+	return n
+}
+
+// writeJSONObject writes the fields of an object as a JSON object.
+func (o *valueOpts) writeJSONObject(buf *byteBuffer, om ObjectMarshaler, depth int) {
+	if depth >= maxNestingDepth {
+		buf.AppendString(`"..."`)
+		return
+	}
+
+	buf.AppendByte('{')
+	e := &objectEncoder{opts: *o, buf: buf, json: true, depth: depth}
+	if errMsg := marshalObject(om, e); errMsg != "" {
+		e.AddString("_error", errMsg)
+	}
+	e.buf = nil
+	buf.AppendByte('}')
+}
+
+// marshalObject calls MarshalLogObject and returns !ERROR:<err> if it fails,
+// !PANIC: <recovered value> if it panics, or an empty string.
+func marshalObject(om ObjectMarshaler, e *objectEncoder) (errMsg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			errMsg = fmt.Sprintf("!PANIC: %v", r)
+		}
+	}()
+
+	if err := om.MarshalLogObject(e); err != nil {
+		return "!ERROR:" + err.Error()
+	}
+
+	return ""
+}
+
+// AddString adds a string field.
+func (e *objectEncoder) AddString(key, val string) {
+	if !e.writeKey(key) {
+		return
+	}
+
+	switch {
+	case e.json:
+		writeQuotedString(e.buf, val)
+	case e.msgpack:
+		writeMsgpackString(e.buf, val)
+	default:
+		escapeAndWriteString(e.buf, truncateValue(val, e.opts.maxFieldValueLen))
+	}
+	e.end()
+}
+
+// AddInt adds an integer field.
+func (e *objectEncoder) AddInt(key string, val int64) {
+	if !e.writeKey(key) {
+		return
+	}
+
+	if !e.json && e.msgpack {
+		writeMsgpackInt(e.buf, val)
+	} else {
+		e.buf.AppendInt(val)
+	}
+	e.end()
+}
+
+// AddFloat adds a float field.
+func (e *objectEncoder) AddFloat(key string, val float64) {
+	if !e.writeKey(key) {
+		return
+	}
+
+	switch {
+	case e.json:
+		writeJSONFloat(e.buf, val, 64)
+	case e.msgpack:
+		e.opts.writeMsgpackValue(e.buf, val)
+	default:
+		e.buf.AppendFloat(val, 64)
+	}
+	e.end()
+}
+
+// AddBool adds a bool field.
+func (e *objectEncoder) AddBool(key string, val bool) {
+	if !e.writeKey(key) {
+		return
+	}
+
+	if !e.json && e.msgpack {
+		e.opts.writeMsgpackValue(e.buf, val)
+	} else {
+		e.buf.AppendBool(val)
+	}
+	e.end()
+}
+
+// AddAny adds a field of any type, written like the ...interface{} fields.
+func (e *objectEncoder) AddAny(key string, val interface{}) {
+	if !e.writeKey(key) {
+		return
+	}
+
+	switch {
+	case e.json:
+		e.opts.writeJSONValue(e.buf, val, e.depth+1)
+	case e.msgpack:
+		e.opts.writeMsgpackValue(e.buf, val)
+	default:
+		e.opts.writeValueToBuf(e.buf, val)
+	}
+	e.end()
+}
+
+// writeKey writes the prefixed key of a field. It returns false if the
+// encoder is no longer in use.
+func (e *objectEncoder) writeKey(key string) bool {
+	if e.buf == nil {
+		return false
+	}
+
+	if e.json {
+		if e.n > 0 {
+			e.buf.AppendByte(',')
+		}
+		writeQuotedString(e.buf, key)
+		e.buf.AppendByte(':')
+		return true
+	}
+
+	if e.msgpack {
+		writeMsgpackStrHeader(e.buf, len(e.prefix)+1+len(key))
+		e.buf.AppendString(e.prefix)
+		e.buf.AppendByte('.')
+		e.buf.AppendString(key)
+		return true
+	}
+
+	// The key is only joined if it has to be quoted or colored as a whole.
+	if e.color != "" || strings.IndexFunc(e.prefix, checkEscapingRune) != -1 ||
+		strings.IndexFunc(key, checkEscapingRune) != -1 {
+		writeColoredKey(e.buf, e.prefix+"."+key, e.color)
+		return true
+	}
+
+	e.buf.AppendByte(' ')
+	e.buf.AppendString(e.prefix)
+	e.buf.AppendByte('.')
+	e.buf.AppendString(key)
+	e.buf.AppendByte('=')
+	return true
+}
+
+// end finishes writing a field.
+func (e *objectEncoder) end() {
+	e.n++
+}
@@ -0,0 +1,113 @@
+package logf
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type objUser struct {
+	ID    int
+	Name  string
+	Score float64
+	Admin bool
+	Tags  []string
+}
+
+func (u *objUser) MarshalLogObject(enc ObjectEncoder) error {
+	enc.AddInt("id", int64(u.ID))
+	enc.AddString("name", u.Name)
+	enc.AddFloat("score", u.Score)
+	enc.AddBool("admin", u.Admin)
+	enc.AddAny("tags", u.Tags)
+	return nil
+}
+
+type errObject struct{}
+
+func (errObject) MarshalLogObject(enc ObjectEncoder) error {
+	enc.AddString("partial", "yes")
+	return errors.New("bad object")
+}
+
+type panicObject struct{}
+
+func (panicObject) MarshalLogObject(enc ObjectEncoder) error {
+	panic("boom")
+}
+
+// keepObject keeps the encoder it is marshaled with and adds a field with
+// the encoder it kept before.
+type keepObject struct {
+	kept *ObjectEncoder
+}
+
+func (o keepObject) MarshalLogObject(enc ObjectEncoder) error {
+	if *o.kept != nil {
+		(*o.kept).AddString("leak", "yes")
+	}
+	*o.kept = enc
+	enc.AddString("k", "v")
+	return nil
+}
+
+func TestLogObjectKeptEncoder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var kept ObjectEncoder
+	for _, f := range []Format{LogfmtFormat, ECSFormat} {
+		l := New(Opts{Writer: buf, Format: f})
+		l.Info("hello world", "obj", keepObject{kept: &kept})
+		l.Info("hello world", "obj", keepObject{kept: &kept})
+		require.NotContains(t, buf.String(), "leak")
+		buf.Reset()
+	}
+}
+
+func TestLogObject(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+	u := &objUser{ID: 1, Name: "alice smith", Score: 1.5, Admin: true, Tags: []string{"a", "b"}}
+
+	l.Info("hello world", "user", u, "component", "logf")
+	require.Contains(t, buf.String(), `user.id=1 user.name="alice smith" user.score=1.5 user.admin=true user.tags="[\"a\",\"b\"]" component=logf`+"\n")
+	buf.Reset()
+
+	var nilUser *objUser
+	l.Info("hello world", "user", nilUser)
+	require.Contains(t, buf.String(), `user=null`+"\n")
+	buf.Reset()
+
+	l.Info("hello world", "obj", errObject{}, "obj2", panicObject{})
+	require.Contains(t, buf.String(), `obj.partial=yes obj_error="!ERROR:bad object" obj2_error="!PANIC: boom"`+"\n")
+	buf.Reset()
+
+	// Nested objects are written as JSON.
+	l.Info("hello world", "m", map[string]interface{}{"user": &objUser{ID: 2}})
+	require.Contains(t, buf.String(), `m="{\"user\":{\"id\":2,\"name\":\"\",\"score\":0,\"admin\":false,\"tags\":[]}}"`)
+	buf.Reset()
+
+	l.Opts.Format = CSVFormat
+	l.Info("hello world", "user", &objUser{ID: 1, Name: "alice"}, "obj", errObject{})
+	require.Contains(t, buf.String(), `"{""user"":{""id"":1,""name"":""alice"",""score"":0,""admin"":false,""tags"":[]},""obj"":{""partial"":""yes"",""_error"":""!ERROR:bad object""}}"`)
+}
+
+func TestLogObjectMsgpack(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, Format: MsgpackFormat})
+
+	l.Info("hello world", "user", &objUser{ID: 1, Name: "alice"}, "obj", errObject{})
+	frames := decodeMsgpackFrames(t, buf.Bytes())
+	require.Len(t, frames, 1)
+
+	f := frames[0]
+	require.Equal(t, int64(1), f["user.id"])
+	require.Equal(t, "alice", f["user.name"])
+	require.Equal(t, 0.0, f["user.score"])
+	require.Equal(t, false, f["user.admin"])
+	require.Equal(t, "[]", f["user.tags"])
+	require.Equal(t, "yes", f["obj.partial"])
+	require.Equal(t, "!ERROR:bad object", f["obj_error"])
+	require.Len(t, f, 10)
+}
@@ -0,0 +1,137 @@
+// Package otellogf exports the lines logged by a logf.Logger as
+// OpenTelemetry log records. It is a module of its own so that logf
+// doesn't depend on the OpenTelemetry SDK.
+package otellogf
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/zerodha/logf"
+	"go.opentelemetry.io/otel/attribute"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+)
+
+// otelScope is the instrumentation scope of the records exported by
+// Handler.
+const otelScope = "github.com/zerodha/logf"
+
+// otelSeverities are the OpenTelemetry severities of the levels.
+var otelSeverities = [...]otellog.Severity{
+	logf.DebugLevel: otellog.SeverityDebug,
+	logf.InfoLevel:  otellog.SeverityInfo,
+	logf.WarnLevel:  otellog.SeverityWarn,
+	logf.ErrorLevel: otellog.SeverityError,
+	logf.FatalLevel: otellog.SeverityFatal,
+}
+
+// Handler is a logf.Hook that exports every line as an OpenTelemetry
+// log record.
+type Handler struct {
+	provider *sdklog.LoggerProvider
+	logger   otellog.Logger
+}
+
+// NewHandler returns a Handler that exports every line that is logged
+// as an OpenTelemetry log record with exp, eg: an OTLP exporter, so that
+// logging calls don't have to change, eg:
+//
+//	exp, _ := otlploghttp.New(ctx)
+//	h := otellogf.NewHandler(exp)
+//	defer h.Shutdown(ctx)
+//	l := logf.New(logf.Opts{Hooks: []logf.Hook{h}})
+//
+// Records are exported synchronously as they are logged, with the level as
+// the severity, the message as the body and the fields as attributes.
+// Lines are still written.
+//
+// As hooks fire before lines are deduplicated and sampled, lines dropped
+// by Opts.DedupeWindow and Opts.SampleRate are exported all the same.
+// Lazy values are evaluated once for the record and once more when the
+// line is written.
+func NewHandler(exp sdklog.Exporter) *Handler {
+	p := sdklog.NewLoggerProvider(sdklog.WithProcessor(sdklog.NewSimpleProcessor(exp)))
+	return &Handler{provider: p, logger: p.Logger(otelScope)}
+}
+
+// Shutdown flushes the records that are yet to be exported and shuts the
+// exporter down. Lines logged after it are no longer exported.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	return h.provider.Shutdown(ctx)
+}
+
+// Fire exports the line as a log record and returns the fields as is.
+func (h *Handler) Fire(lvl logf.Level, msg string, fields []interface{}) ([]interface{}, bool) {
+	var r otellog.Record
+	r.SetTimestamp(time.Now())
+	r.SetBody(attribute.StringValue(msg))
+	r.SetSeverityText(lvl.String())
+	if lvl >= logf.DebugLevel && lvl <= logf.FatalLevel {
+		r.SetSeverity(otelSeverities[lvl])
+	}
+
+	logf.EachField(fields, func(key string, val interface{}) {
+		r.AddAttributes(otelKeyValue(key, val))
+	})
+
+	h.logger.Emit(context.Background(), r)
+	return fields, true
+}
+
+// otelKeyValue returns a field as an attribute. Values that have no
+// attribute type of their own are written as their text.
+func otelKeyValue(key string, val interface{}) attribute.KeyValue {
+	switch v := val.(type) {
+	case nil:
+		return attribute.KeyValue{Key: attribute.Key(key)}
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int8:
+		return attribute.Int64(key, int64(v))
+	case int16:
+		return attribute.Int64(key, int64(v))
+	case int32:
+		return attribute.Int64(key, int64(v))
+	case int64:
+		return attribute.Int64(key, v)
+	case uint8:
+		return attribute.Int64(key, int64(v))
+	case uint16:
+		return attribute.Int64(key, int64(v))
+	case uint32:
+		return attribute.Int64(key, int64(v))
+	case uint:
+		if uint64(v) <= math.MaxInt64 {
+			return attribute.Int64(key, int64(v))
+		}
+	case uint64:
+		if v <= math.MaxInt64 {
+			return attribute.Int64(key, int64(v))
+		}
+	case float32:
+		return attribute.Float64(key, float64(v))
+	case float64:
+		return attribute.Float64(key, v)
+	case []byte:
+		return attribute.ByteSlice(key, v)
+	case time.Time:
+		return attribute.String(key, v.Format(time.RFC3339Nano))
+	case time.Duration:
+		return attribute.String(key, v.String())
+	case error, fmt.Stringer:
+		// The methods of nil pointers may panic.
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return attribute.KeyValue{Key: attribute.Key(key)}
+		}
+	}
+
+	return attribute.String(key, fmt.Sprint(val))
+}
@@ -0,0 +1,79 @@
+package otellogf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zerodha/logf"
+	"go.opentelemetry.io/otel/attribute"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+)
+
+// memExporter is an sdklog.Exporter that keeps the records in memory.
+type memExporter struct {
+	mu       sync.Mutex
+	records  []sdklog.Record
+	shutdown bool
+}
+
+func (e *memExporter) Export(_ context.Context, records []sdklog.Record) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range records {
+		e.records = append(e.records, r.Clone())
+	}
+	return nil
+}
+
+func (e *memExporter) Shutdown(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.shutdown = true
+	return nil
+}
+
+func (e *memExporter) ForceFlush(context.Context) error { return nil }
+
+func TestOTelHandler(t *testing.T) {
+	exp := &memExporter{}
+	buf := &bytes.Buffer{}
+	h := NewHandler(exp)
+	l := logf.New(logf.Opts{Writer: buf, Hooks: []logf.Hook{h}})
+
+	before := time.Now()
+	l.Error("hello world", "count", 3, "ratio", 0.5, "ok", true, "err", errors.New("bad"), logf.String("user", "alice"))
+	l.Debug("skipped")
+
+	// Lines are still written.
+	require.Contains(t, buf.String(), `message="hello world" count=3`)
+
+	require.Len(t, exp.records, 1)
+	r := exp.records[0]
+	require.Equal(t, "hello world", r.Body().AsString())
+	require.Equal(t, otellog.SeverityError, r.Severity())
+	require.Equal(t, "error", r.SeverityText())
+	require.False(t, r.Timestamp().Before(before))
+
+	attrs := map[string]attribute.Value{}
+	r.WalkAttributes(func(kv attribute.KeyValue) bool {
+		attrs[string(kv.Key)] = kv.Value
+		return true
+	})
+	require.Equal(t, int64(3), attrs["count"].AsInt64())
+	require.Equal(t, 0.5, attrs["ratio"].AsFloat64())
+	require.True(t, attrs["ok"].AsBool())
+	require.Equal(t, "bad", attrs["err"].AsString())
+	require.Equal(t, "alice", attrs["user"].AsString())
+
+	// Lines logged after Shutdown aren't exported.
+	require.NoError(t, h.Shutdown(context.Background()))
+	require.True(t, exp.shutdown)
+	l.Error("after shutdown")
+	require.Len(t, exp.records, 1)
+}
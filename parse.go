@@ -0,0 +1,184 @@
+package logf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Entry is a single log line parsed back from logfmt.
+type Entry struct {
+	Timestamp time.Time
+	Level     Level
+	Message   string
+	Caller    string
+
+	// Fields holds the remaining key/value pairs in the order they
+	// appeared in the line, in the same alternating form accepted by
+	// the log methods. Keys are strings and values are either strings,
+	// or nil for an unquoted null.
+	Fields []interface{}
+}
+
+// errUnterminatedQuote is returned for lines that end within a quoted string.
+var errUnterminatedQuote = errors.New("unterminated quoted string")
+
+// Parse parses a single logfmt line as emitted by the logger. The
+// timestamp, if present, must be in RFC3339 (the default format).
+func Parse(line []byte) (Entry, error) {
+	var (
+		e Entry
+		i int
+	)
+
+	for {
+		// Skip separators.
+		for i < len(line) && (line[i] == ' ' || line[i] == '\n' || line[i] == '\r') {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+
+		key, n, err := parseToken(line[i:], true)
+		if err != nil {
+			return e, err
+		}
+		i += n
+
+		var val interface{} = ""
+		if i < len(line) && line[i] == '=' {
+			i++
+			quoted := i < len(line) && line[i] == '"'
+
+			v, n, err := parseToken(line[i:], false)
+			if err != nil {
+				return e, err
+			}
+			i += n
+
+			// An unquoted null is a nil value, whereas "null" is a string.
+			if v == "null" && !quoted {
+				val = nil
+			} else {
+				val = v
+			}
+		}
+
+		if err := e.set(key, val); err != nil {
+			return e, err
+		}
+	}
+
+	return e, nil
+}
+
+// set assigns a parsed key/value pair to the entry.
+func (e *Entry) set(key string, val interface{}) error {
+	s, _ := val.(string)
+
+	switch key {
+	case "timestamp":
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp: %v", err)
+		}
+		e.Timestamp = t
+	case "level":
+		lvl, err := LevelFromString(s)
+		if err != nil {
+			return err
+		}
+		e.Level = lvl
+	case "message":
+		e.Message = s
+	case "caller":
+		e.Caller = s
+	default:
+		e.Fields = append(e.Fields, key, val)
+	}
+
+	return nil
+}
+
+// parseToken reads a key or a value from the beginning of b and returns it
+// along with the number of bytes consumed. Quoted tokens are unquoted.
+// Unquoted keys end at '=' and unquoted values end at a space.
+func parseToken(b []byte, isKey bool) (string, int, error) {
+	if len(b) > 0 && b[0] == '"' {
+		for i := 1; i < len(b); i++ {
+			switch b[i] {
+			case '\\':
+				i++
+			case '"':
+				s, err := strconv.Unquote(string(b[:i+1]))
+				if err != nil {
+					return "", 0, fmt.Errorf("invalid quoted string: %v", err)
+				}
+				return s, i + 1, nil
+			}
+		}
+		return "", 0, errUnterminatedQuote
+	}
+
+	i := 0
+	for i < len(b) && b[i] != ' ' && b[i] != '\n' && b[i] != '\r' && !(isKey && b[i] == '=') {
+		i++
+	}
+
+	return string(b[:i]), i, nil
+}
+
+// Scanner reads logfmt entries line by line from an io.Reader.
+type Scanner struct {
+	sc    *bufio.Scanner
+	entry Entry
+	line  int
+	err   error
+}
+
+// NewScanner returns a Scanner that reads entries from r.
+func NewScanner(r io.Reader) *Scanner {
+	return &Scanner{sc: bufio.NewScanner(r)}
+}
+
+// Scan advances to the next non-empty line and parses it. It returns
+// false when the input is exhausted or on the first error.
+func (s *Scanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+
+	for s.sc.Scan() {
+		s.line++
+		b := s.sc.Bytes()
+		if len(b) == 0 {
+			continue
+		}
+
+		e, err := Parse(b)
+		if err != nil {
+			s.err = fmt.Errorf("line %d: %v", s.line, err)
+			return false
+		}
+
+		s.entry = e
+		return true
+	}
+
+	s.err = s.sc.Err()
+	return false
+}
+
+// Entry returns the most recent entry read by Scan.
+func (s *Scanner) Entry() Entry {
+	return s.entry
+}
+
+// Err returns the first error encountered by the Scanner.
+func (s *Scanner) Err() error {
+	return s.err
+}
@@ -0,0 +1,84 @@
+package logf
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	e, err := Parse([]byte(`timestamp=2022-07-07T12:09:10.221+05:30 level=warn message="hello world" caller=/app/main.go:12 component=api quote="say \"hi\"" n=null s="null" empty= bare` + "\n"))
+	require.NoError(t, err)
+
+	ts, _ := time.Parse(time.RFC3339Nano, "2022-07-07T12:09:10.221+05:30")
+	require.True(t, ts.Equal(e.Timestamp))
+	require.Equal(t, WarnLevel, e.Level)
+	require.Equal(t, "hello world", e.Message)
+	require.Equal(t, "/app/main.go:12", e.Caller)
+	require.Equal(t, []interface{}{
+		"component", "api",
+		"quote", `say "hi"`,
+		"n", nil,
+		"s", "null",
+		"empty", "",
+		"bare", "",
+	}, e.Fields)
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, line := range []string{
+		`level=info message="truncated li`,
+		`level=info message="ends in escape\`,
+		`level=nope message=hi`,
+		`timestamp=yesterday level=info`,
+		`"unterminated key=v`,
+	} {
+		_, err := Parse([]byte(line))
+		require.Error(t, err, line)
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, Level: DebugLevel, DefaultFields: []interface{}{"my key", "v"}})
+
+	l.Debug("first line", "error", errors.New(`bad "input"`), "ctrl", "a \x00\t\r", "eq", "a=b", `\`, "\xbd", "num", 1)
+	l.Error("second", "nil", nil)
+
+	sc := NewScanner(buf)
+	require.True(t, sc.Scan())
+	e := sc.Entry()
+	require.Equal(t, DebugLevel, e.Level)
+	require.Equal(t, "first line", e.Message)
+	require.False(t, e.Timestamp.IsZero())
+	require.Equal(t, []interface{}{
+		"my key", "v",
+		"error", `bad "input"`,
+		"ctrl", "a \x00\t\r",
+		"eq", "a=b",
+		`\`, "�",
+		"num", "1",
+	}, e.Fields)
+
+	require.True(t, sc.Scan())
+	e = sc.Entry()
+	require.Equal(t, ErrorLevel, e.Level)
+	require.Equal(t, "second", e.Message)
+	require.Equal(t, []interface{}{"my key", "v", "nil", nil}, e.Fields)
+
+	require.False(t, sc.Scan())
+	require.NoError(t, sc.Err())
+}
+
+func TestScannerError(t *testing.T) {
+	sc := NewScanner(strings.NewReader("level=info message=ok\n\nlevel=info message=\"cut"))
+	require.True(t, sc.Scan())
+	require.Equal(t, "ok", sc.Entry().Message)
+	require.False(t, sc.Scan())
+	require.EqualError(t, sc.Err(), "line 3: unterminated quoted string")
+	require.False(t, sc.Scan())
+}
@@ -0,0 +1,211 @@
+package logf
+
+import (
+	"compress/gzip"
+	"io"
+	stdlog "log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RollingOpts represents the config options for a RollingFileWriter.
+type RollingOpts struct {
+	// MaxSizeBytes is the size after which the file is rotated.
+	// 0 disables rotation.
+	MaxSizeBytes int64
+
+	// MaxFiles is the number of rotated files to keep. Older ones are
+	// deleted. 0 keeps all of them.
+	MaxFiles int
+
+	// Compress gzips rotated files in the background.
+	Compress bool
+}
+
+// RollingFileWriter is an io.WriteCloser that writes to a file and rotates
+// it once it grows beyond a size. The current file is always at path and
+// rotated files are at <path>.1 (most recent), <path>.2 and so on, with
+// a .gz suffix if compressed.
+type RollingFileWriter struct {
+	mu   sync.Mutex
+	path string
+	opts RollingOpts
+	f    *os.File
+	size int64
+
+	// compressing tracks background compressions of rotated files.
+	compressing sync.WaitGroup
+}
+
+// NewRollingFileWriter opens (or creates) the file at path for appending
+// and returns a RollingFileWriter for it.
+func NewRollingFileWriter(path string, opts RollingOpts) (*RollingFileWriter, error) {
+	w := &RollingFileWriter{path: path, opts: opts}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Write writes p to the file, rotating it first if p would take the
+// file beyond MaxSizeBytes. A line is never split across files.
+func (w *RollingFileWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return 0, ErrWriterClosed
+	}
+
+	if w.opts.MaxSizeBytes > 0 && w.size > 0 && w.size+int64(len(p)) > w.opts.MaxSizeBytes {
+		if err := w.rotate(); err != nil {
+			if w.f == nil {
+				return 0, err
+			}
+			stdlog.Printf("error rotating log file: %v", err)
+		}
+	}
+
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the file and waits for pending compressions to finish.
+func (w *RollingFileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return ErrWriterClosed
+	}
+
+	err := w.f.Close()
+	w.f = nil
+	w.compressing.Wait()
+	return err
+}
+
+// open opens the file at path for appending.
+func (w *RollingFileWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w.f = f
+	w.size = st.Size()
+	return nil
+}
+
+// rotate closes the current file, shifts the rotated files and opens
+// a new file. A new file is opened even if shifting fails.
+func (w *RollingFileWriter) rotate() error {
+	err := w.f.Close()
+	w.f = nil
+	if err == nil {
+		err = w.shift()
+	}
+
+	if oErr := w.open(); oErr != nil {
+		return oErr
+	}
+	return err
+}
+
+// shift shifts the rotated files up by one, deleting the ones beyond
+// MaxFiles, and moves the current file to <path>.1.
+func (w *RollingFileWriter) shift() error {
+	// Rotated files can't be shifted while they are being compressed.
+	w.compressing.Wait()
+
+	n := 0
+	for w.backup(n+1) != "" {
+		n++
+	}
+
+	for i := n; i >= 1; i-- {
+		name := w.backup(i)
+		if w.opts.MaxFiles > 0 && i >= w.opts.MaxFiles {
+			if err := os.Remove(name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		ext := strings.TrimPrefix(name, w.backupPrefix(i))
+		if err := os.Rename(name, w.backupPrefix(i+1)+ext); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(w.path, w.backupPrefix(1)); err != nil {
+		return err
+	}
+
+	if w.opts.Compress {
+		w.compressing.Add(1)
+		go func(name string) {
+			defer w.compressing.Done()
+			if err := compressFile(name); err != nil {
+				stdlog.Printf("error compressing log file: %v", err)
+			}
+		}(w.backupPrefix(1))
+	}
+
+	return nil
+}
+
+// backupPrefix returns the name of the i'th rotated file without the .gz suffix.
+func (w *RollingFileWriter) backupPrefix(i int) string {
+	return w.path + "." + strconv.Itoa(i)
+}
+
+// backup returns the name of the i'th rotated file if it exists.
+func (w *RollingFileWriter) backup(i int) string {
+	for _, name := range []string{w.backupPrefix(i), w.backupPrefix(i) + ".gz"} {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// compressFile gzips the file at name into name.gz and removes the original.
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(name)
+}
@@ -0,0 +1,96 @@
+package logf
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, name string) string {
+	b, err := os.ReadFile(name)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestRollingFileWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := NewRollingFileWriter(path, RollingOpts{MaxSizeBytes: 10, MaxFiles: 2})
+	require.NoError(t, err)
+
+	// Exactly at the limit doesn't rotate.
+	_, err = w.Write([]byte("1234\n"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("6789\n"))
+	require.NoError(t, err)
+	require.NoFileExists(t, path+".1")
+
+	// One byte over does.
+	_, err = w.Write([]byte("a\n"))
+	require.NoError(t, err)
+	require.Equal(t, "1234\n6789\n", readFile(t, path+".1"))
+	require.Equal(t, "a\n", readFile(t, path))
+
+	// Lines larger than the limit are written whole.
+	_, err = w.Write([]byte("bbbbbbbbbbbbbbbb\n"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("c\n"))
+	require.NoError(t, err)
+	require.Equal(t, "c\n", readFile(t, path))
+	require.Equal(t, "bbbbbbbbbbbbbbbb\n", readFile(t, path+".1"))
+	require.Equal(t, "a\n", readFile(t, path+".2"))
+
+	// Only MaxFiles rotated files are kept.
+	_, err = w.Write([]byte("dddddddddd\n"))
+	require.NoError(t, err)
+	require.Equal(t, "c\n", readFile(t, path+".1"))
+	require.Equal(t, "bbbbbbbbbbbbbbbb\n", readFile(t, path+".2"))
+	require.NoFileExists(t, path+".3")
+
+	require.NoError(t, w.Close())
+	_, err = w.Write([]byte("x"))
+	require.Equal(t, ErrWriterClosed, err)
+}
+
+func TestRollingFileWriterAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	require.NoError(t, os.WriteFile(path, []byte("0123456789"), 0644))
+
+	w, err := NewRollingFileWriter(path, RollingOpts{MaxSizeBytes: 10})
+	require.NoError(t, err)
+	_, err = w.Write([]byte("a\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	require.Equal(t, "0123456789", readFile(t, path+".1"))
+	require.Equal(t, "a\n", readFile(t, path))
+}
+
+func TestRollingFileWriterCompress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := NewRollingFileWriter(path, RollingOpts{MaxSizeBytes: 5, MaxFiles: 2, Compress: true})
+	require.NoError(t, err)
+
+	l := New(Opts{Writer: w})
+	for _, msg := range []string{"one", "two", "three"} {
+		l.Info(msg)
+	}
+	require.NoError(t, w.Close())
+
+	require.NoFileExists(t, path+".1")
+	require.NoFileExists(t, path+".3.gz")
+	for i, msg := range map[string]string{"1": "two", "2": "one"} {
+		f, err := os.Open(path + "." + i + ".gz")
+		require.NoError(t, err)
+		gz, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		b, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		f.Close()
+		require.Contains(t, string(b), "message="+msg)
+	}
+	require.Contains(t, readFile(t, path), "message=three")
+}
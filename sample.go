@@ -0,0 +1,78 @@
+package logf
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sampler limits the rate of log lines of each level with a token bucket.
+type sampler struct {
+	buckets [OffLevel + 1]*bucket
+
+	// now returns the current time. It is replaced in tests.
+	now func() time.Time
+}
+
+// bucket is the token bucket of a level. It holds up to rate tokens and
+// is refilled at rate tokens a second. A line takes a token to be logged.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+
+	// dropped is the number of lines dropped since the last one logged.
+	dropped int64
+}
+
+// newSampler returns a sampler for the rates in Opts.SampleRate, or nil
+// if no level is sampled.
+func newSampler(rates map[Level]int) *sampler {
+	var (
+		s       = &sampler{now: time.Now}
+		sampled bool
+	)
+	for lvl, rate := range rates {
+		if rate <= 0 || lvl < DebugLevel || lvl > FatalLevel {
+			continue
+		}
+
+		s.buckets[lvl] = &bucket{rate: float64(rate), tokens: float64(rate)}
+		sampled = true
+	}
+	if !sampled {
+		return nil
+	}
+
+	return s
+}
+
+// allow returns true if a line of the given level can be logged, along
+// with the number of lines of the level dropped since the last one logged.
+func (s *sampler) allow(lvl Level) (int64, bool) {
+	b := s.buckets[lvl]
+	if b == nil {
+		return 0, true
+	}
+
+	now := s.now()
+	b.mu.Lock()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		b.mu.Unlock()
+		atomic.AddInt64(&b.dropped, 1)
+		return 0, false
+	}
+	b.tokens--
+	b.mu.Unlock()
+
+	return atomic.SwapInt64(&b.dropped, 0), true
+}
@@ -0,0 +1,55 @@
+package logf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampling(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, Level: DebugLevel, SampleRate: map[Level]int{DebugLevel: 3, InfoLevel: 0}})
+
+	now := time.Date(2022, 7, 7, 12, 0, 0, 0, time.UTC)
+	l.sampler.now = func() time.Time { return now }
+
+	for i := 0; i < 10; i++ {
+		l.Debug("debug")
+		l.Info("info")
+	}
+	require.Equal(t, 3, strings.Count(buf.String(), "message=debug"))
+	require.Equal(t, 10, strings.Count(buf.String(), "message=info"))
+	require.NotContains(t, buf.String(), "dropped")
+	buf.Reset()
+
+	// Loggers derived with With share the rate.
+	l.With("k", "v").Debug("debug")
+	require.Empty(t, buf.String())
+
+	// Half a second refills half the bucket. The drops are reported
+	// before the first line logged.
+	now = now.Add(500 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		l.Debug("debug")
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], `level=debug message="8 debug messages dropped"`)
+	require.Contains(t, lines[1], `level=debug message=debug`)
+	buf.Reset()
+
+	// The bucket holds at most a second's worth of lines.
+	now = now.Add(time.Minute)
+	for i := 0; i < 5; i++ {
+		l.Debug("debug")
+	}
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 4)
+	require.Contains(t, lines[0], `message="2 debug messages dropped"`)
+	require.Equal(t, 3, strings.Count(buf.String(), "message=debug"))
+
+	require.Nil(t, New(Opts{SampleRate: map[Level]int{DebugLevel: 0}}).sampler)
+}
@@ -0,0 +1,54 @@
+package logf
+
+import (
+	"context"
+	"os"
+	"os/signal"
+)
+
+// ListenForSignals changes the level of the logger when the process
+// receives a signal, eg: kill -USR1 <pid>, until ctx is cancelled.
+// increaseSignal makes the logger one level more verbose, down to
+// DebugLevel, and decreaseSignal one level less verbose, up to FatalLevel.
+// The signals are being listened for when it returns, eg:
+//
+//	l.ListenForSignals(ctx, syscall.SIGUSR1, syscall.SIGUSR2)
+func (l *Logger) ListenForSignals(ctx context.Context, increaseSignal, decreaseSignal os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, increaseSignal, decreaseSignal)
+
+	lvl := l.Opts.AtomicLevel
+	go func() {
+		defer signal.Stop(ch)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-ch:
+				if sig == increaseSignal {
+					shiftLevel(lvl, -1)
+				} else {
+					shiftLevel(lvl, 1)
+				}
+			}
+		}
+	}()
+}
+
+// shiftLevel moves lvl by delta levels, within DebugLevel and FatalLevel.
+// OffLevel is only ever made more verbose.
+func shiftLevel(lvl AtomicLevel, delta int) {
+	cur := lvl.Level()
+	n := cur + Level(delta)
+	if n < DebugLevel {
+		n = DebugLevel
+	}
+	if n > FatalLevel {
+		n = FatalLevel
+		if cur > n {
+			n = cur
+		}
+	}
+	lvl.SetLevel(n)
+}
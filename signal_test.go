@@ -0,0 +1,62 @@
+//go:build !windows
+
+package logf
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenForSignals(t *testing.T) {
+	l := New(Opts{Writer: io.Discard, Level: WarnLevel})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l.ListenForSignals(ctx, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	send := func(sig syscall.Signal, want Level) {
+		require.NoError(t, syscall.Kill(os.Getpid(), sig))
+		require.Eventually(t, func() bool { return l.GetLevel() == want }, time.Second, time.Millisecond)
+	}
+	send(syscall.SIGUSR1, InfoLevel)
+	send(syscall.SIGUSR1, DebugLevel)
+	send(syscall.SIGUSR2, InfoLevel)
+
+	// Signals are ignored once the context is cancelled.
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	defer signal.Stop(ch)
+
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	<-ch
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, InfoLevel, l.GetLevel())
+}
+
+func TestShiftLevel(t *testing.T) {
+	lvl := NewAtomicLevel(DebugLevel)
+	shiftLevel(lvl, -1)
+	require.Equal(t, DebugLevel, lvl.Level())
+
+	shiftLevel(lvl, 1)
+	require.Equal(t, InfoLevel, lvl.Level())
+
+	lvl.SetLevel(FatalLevel)
+	shiftLevel(lvl, 1)
+	require.Equal(t, FatalLevel, lvl.Level())
+
+	// OffLevel can only be made more verbose.
+	lvl.SetLevel(OffLevel)
+	shiftLevel(lvl, 1)
+	require.Equal(t, OffLevel, lvl.Level())
+	shiftLevel(lvl, -1)
+	require.Equal(t, FatalLevel, lvl.Level())
+}
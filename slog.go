@@ -0,0 +1,137 @@
+//go:build go1.21
+
+package logf
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogSkipFrames is the number of frames between a slog call and
+// the Logger method called by SlogHandler.Handle.
+const slogSkipFrames = 3
+
+// SlogHandler is a slog.Handler that writes records with a Logger.
+// slog levels are mapped to the closest Logger level at or below them
+// and records above the error level are written as errors.
+type SlogHandler struct {
+	l Logger
+
+	// prefix is prepended to attribute keys, eg: "req." in a group "req".
+	prefix string
+}
+
+// NewSlogHandler returns a slog.Handler that writes with l, eg:
+//
+//	slog.SetDefault(slog.New(logf.NewSlogHandler(logger)))
+func NewSlogHandler(l Logger) *SlogHandler {
+	// Skip the slog frames so that the caller is the one calling slog.
+	l.Opts.CallerSkipFrameCount += slogSkipFrames
+	return &SlogHandler{l: l}
+}
+
+// Enabled reports whether the handler writes records of the given level.
+func (h *SlogHandler) Enabled(_ context.Context, lvl slog.Level) bool {
+	return h.l.IsEnabled(slogLevel(lvl))
+}
+
+// Handle writes the record.
+func (h *SlogHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := make([]interface{}, 0, r.NumAttrs()*2)
+	r.Attrs(func(a slog.Attr) bool {
+		fields = appendSlogAttr(fields, h.prefix, a)
+		return true
+	})
+
+	switch slogLevel(r.Level) {
+	case DebugLevel:
+		h.l.Debug(r.Message, fields...)
+	case InfoLevel:
+		h.l.Info(r.Message, fields...)
+	case WarnLevel:
+		h.l.Warn(r.Message, fields...)
+	default:
+		h.l.Error(r.Message, fields...)
+	}
+
+	return nil
+}
+
+// WithAttrs returns a handler that writes attrs with every record.
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var fields []interface{}
+	for _, a := range attrs {
+		fields = appendSlogAttr(fields, h.prefix, a)
+	}
+
+	return &SlogHandler{l: h.l.With(fields...), prefix: h.prefix}
+}
+
+// WithGroup returns a handler that prefixes the keys of
+// subsequent attributes with the group name, eg: group.key.
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return &SlogHandler{l: h.l, prefix: h.prefix + name + "."}
+}
+
+// slogLevel returns the Level for a slog.Level.
+func slogLevel(lvl slog.Level) Level {
+	switch {
+	case lvl < slog.LevelInfo:
+		return DebugLevel
+	case lvl < slog.LevelWarn:
+		return InfoLevel
+	case lvl < slog.LevelError:
+		return WarnLevel
+	default:
+		return ErrorLevel
+	}
+}
+
+// appendSlogAttr appends the key and value of an attribute to fields,
+// flattening groups into prefixed keys.
+func appendSlogAttr(fields []interface{}, prefix string, a slog.Attr) []interface{} {
+	a.Value = a.Value.Resolve()
+
+	// Empty attributes are ignored as per the slog.Handler rules.
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			fields = appendSlogAttr(fields, prefix, ga)
+		}
+		return fields
+	}
+
+	return append(fields, prefix+a.Key, slogValue(a.Value))
+}
+
+// slogValue returns the Go value of a resolved slog.Value.
+func slogValue(v slog.Value) interface{} {
+	switch v.Kind() {
+	case slog.KindString:
+		return v.String()
+	case slog.KindInt64:
+		return v.Int64()
+	case slog.KindUint64:
+		return v.Uint64()
+	case slog.KindFloat64:
+		return v.Float64()
+	case slog.KindBool:
+		return v.Bool()
+	case slog.KindDuration:
+		return v.Duration()
+	case slog.KindTime:
+		return v.Time()
+	default:
+		return v.Any()
+	}
+}
@@ -0,0 +1,55 @@
+//go:build go1.21
+
+package logf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlogHandler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, EnableCaller: true, CallerShortPath: true})
+	s := slog.New(NewSlogHandler(l))
+
+	s.Debug("hidden")
+	require.Empty(t, buf.String())
+
+	s.Info("hello world", "str", "a b", "int", 1, "dur", time.Second, "err", errors.New("fail"))
+	_, _, line, _ := runtime.Caller(0)
+	require.Contains(t, buf.String(), `level=info message="hello world" caller=`+fmt.Sprintf("slog_test.go:%d", line-1))
+	require.Contains(t, buf.String(), ` str="a b" int=1 dur=1s err=fail`+"\n")
+	buf.Reset()
+
+	s.Log(context.Background(), slog.LevelError+4, "above error")
+	require.Contains(t, buf.String(), `level=error message="above error"`)
+	buf.Reset()
+
+	// Attributes and groups.
+	req := s.With("request_id", 1).WithGroup("req")
+	req.Warn("slow", "took", 10, slog.Group("user", "id", 2, "name", "alice"), slog.Group("", "inline", true), slog.Attr{})
+	require.Contains(t, buf.String(), `level=warn message=slow`)
+	require.Contains(t, buf.String(), ` request_id=1 req.took=10 req.user.id=2 req.user.name=alice req.inline=true`+"\n")
+	buf.Reset()
+
+	// The parent is unchanged.
+	s.Info("parent")
+	require.NotContains(t, buf.String(), "request_id")
+	require.NotContains(t, buf.String(), "req.")
+}
+
+func ExampleNewSlogHandler() {
+	logger := New(Opts{Writer: os.Stdout})
+	slog.SetDefault(slog.New(NewSlogHandler(logger)))
+
+	slog.Info("hello world", "component", "api")
+}
@@ -0,0 +1,38 @@
+package logf
+
+import (
+	"log"
+	"strings"
+)
+
+// stdSkipFrames is the number of frames between a standard library
+// log call and stdWriter.Write.
+const stdSkipFrames = 2
+
+// stdWriter is an io.Writer that writes every line written by a
+// standard library log.Logger as a log line.
+type stdWriter struct {
+	l   Logger
+	lvl Level
+}
+
+// NewStdLogger returns a standard library *log.Logger that writes to l
+// at the given level, eg: for http.Server.ErrorLog. The prefix, stripped
+// of spaces and colons, is written as the scope of the lines (see Named).
+func NewStdLogger(l Logger, lvl Level, prefix string) *log.Logger {
+	// Skip the log package's frames so that the caller is the one calling it.
+	l.Opts.CallerSkipFrameCount += stdSkipFrames
+
+	if scope := strings.Trim(prefix, " :"); scope != "" {
+		l = l.Named(scope)
+	}
+
+	// The timestamp is written by l, so the log.Logger has no flags.
+	return log.New(stdWriter{l: l, lvl: lvl}, "", 0)
+}
+
+// Write writes p as the message of a log line.
+func (w stdWriter) Write(p []byte) (int, error) {
+	w.l.handleLog(strings.TrimSuffix(string(p), "\n"), w.lvl, nil, nil)
+	return len(p), nil
+}
@@ -0,0 +1,30 @@
+package logf
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStdLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf, EnableCaller: true, CallerShortPath: true})
+
+	std := NewStdLogger(l, WarnLevel, "http: ")
+	std.Printf("tls handshake error from %s", "1.2.3.4")
+	_, _, line, _ := runtime.Caller(0)
+	require.Contains(t, buf.String(), `level=warn message="tls handshake error from 1.2.3.4" caller=`+fmt.Sprintf("stdlog_test.go:%d", line-1)+` scope=http`+"\n")
+	require.Equal(t, 1, bytes.Count(buf.Bytes(), []byte("\n")))
+	buf.Reset()
+
+	// Lines below the logger's level are discarded.
+	NewStdLogger(l, DebugLevel, "").Print("hidden")
+	require.Empty(t, buf.String())
+
+	NewStdLogger(l, ErrorLevel, "").Print("no scope")
+	require.Contains(t, buf.String(), `level=error message="no scope" `)
+	require.NotContains(t, buf.String(), "scope=")
+}
@@ -0,0 +1,182 @@
+package logf
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// defaultStructDepth is the depth up to which Struct flattens nested structs.
+const defaultStructDepth = 3
+
+// structField is a field of a struct that is logged by Struct.
+type structField struct {
+	index []int
+	key   string
+
+	// flatten is true if the field is a struct (or a pointer to one)
+	// whose fields are logged instead of the field itself.
+	flatten bool
+}
+
+// structPlans caches the fields of every struct type logged by Struct.
+var structPlans sync.Map // map[reflect.Type][]structField
+
+// Struct expands the exported fields of a struct (or a pointer to one) into
+// key/value pairs prefixed with prefix, eg:
+//
+//	l.Info("request", logf.Struct("req", r)...)
+//
+// logs req.method=GET req.path=/ etc. The key of a field can be set with a
+// `logf:"name"` tag and a field is skipped with `logf:"-"`. Nested structs
+// are flattened with dotted keys, eg: req.user.id=1, up to a depth of 3,
+// after which they are written as values. Embedded structs are flattened
+// into the parent. Types that are logged as text, such as time.Time and
+// errors, are written as values. If v isn't a struct, it is returned as
+// a single pair with the key prefix.
+func Struct(prefix string, v interface{}) []interface{} {
+	return StructDepth(prefix, v, defaultStructDepth)
+}
+
+// StructDepth is Struct with the depth up to which nested structs are
+// flattened.
+func StructDepth(prefix string, v interface{}, depth int) []interface{} {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct || isTextType(reflect.TypeOf(v)) || isTextType(rv.Type()) {
+		return []interface{}{prefix, v}
+	}
+
+	return appendStruct(nil, prefix, rv, depth)
+}
+
+// appendStruct appends the fields of the struct rv to fields.
+func appendStruct(fields []interface{}, prefix string, rv reflect.Value, depth int) []interface{} {
+	for _, f := range structPlan(rv.Type()) {
+		key := f.key
+		if prefix != "" {
+			key = prefix + "." + f.key
+		}
+
+		fv, ok := fieldByIndex(rv, f.index)
+		if !ok {
+			// A field of a nil embedded pointer.
+			continue
+		}
+
+		if f.flatten && depth > 1 {
+			for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				fields = appendStruct(fields, key, fv, depth-1)
+				continue
+			}
+		}
+
+		fields = append(fields, key, fv.Interface())
+	}
+
+	return fields
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex that returns false instead of
+// panicking if the field is in a nil embedded pointer.
+func fieldByIndex(rv reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				return reflect.Value{}, false
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+
+	return rv, true
+}
+
+// structPlan returns the logged fields of the struct type t.
+func structPlan(t reflect.Type) []structField {
+	if p, ok := structPlans.Load(t); ok {
+		return p.([]structField)
+	}
+
+	p := buildStructPlan(t, nil, map[reflect.Type]bool{t: true})
+	structPlans.Store(t, p)
+	return p
+}
+
+// buildStructPlan returns the logged fields of the struct type t, with the
+// fields of embedded structs promoted into it. Like encoding/json, a struct
+// type that is embedded more than once, eg: type Node struct{ *Node }, is
+// only promoted the first time it's visited.
+func buildStructPlan(t reflect.Type, index []int, visited map[reflect.Type]bool) []structField {
+	var out []structField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		tag := sf.Tag.Get("logf")
+		if tag == "-" {
+			continue
+		}
+		if n := strings.IndexByte(tag, ','); n >= 0 {
+			tag = tag[:n]
+		}
+
+		idx := make([]int, len(index)+1)
+		copy(idx, index)
+		idx[len(index)] = i
+
+		ft := sf.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		isStruct := ft.Kind() == reflect.Struct && !isTextType(sf.Type) && !isTextType(ft)
+
+		// Embedded structs without a name of their own are promoted.
+		if sf.Anonymous && tag == "" && isStruct {
+			if visited[ft] {
+				continue
+			}
+			visited[ft] = true
+			out = append(out, buildStructPlan(ft, idx, visited)...)
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		key := tag
+		if key == "" {
+			key = sf.Name
+		}
+		out = append(out, structField{index: idx, key: key, flatten: isStruct})
+	}
+
+	return out
+}
+
+var (
+	errorIface           = reflect.TypeOf((*error)(nil)).Elem()
+	stringerIface        = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	textMarshalerIface   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	logValuerIface       = reflect.TypeOf((*LogValuer)(nil)).Elem()
+	objectMarshalerIface = reflect.TypeOf((*ObjectMarshaler)(nil)).Elem()
+)
+
+// isTextType returns true if values of the struct type t are logged
+// as values by the writers, eg: time.Time, and shouldn't be flattened.
+func isTextType(t reflect.Type) bool {
+	for _, it := range [...]reflect.Type{errorIface, stringerIface, textMarshalerIface, logValuerIface, objectMarshalerIface} {
+		if t.Implements(it) {
+			return true
+		}
+	}
+
+	return false
+}
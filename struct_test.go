@@ -0,0 +1,87 @@
+package logf
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type structAddr struct {
+	City string `logf:"city"`
+	Geo  *structGeo
+}
+
+type structGeo struct {
+	Lat  float64
+	Deep struct {
+		Level int
+	}
+}
+
+type StructBase struct {
+	ID int `logf:"id"`
+}
+
+type structReq struct {
+	StructBase
+	Method  string `logf:"method"`
+	Secret  string `logf:"-"`
+	private string
+	Addr    structAddr    `logf:"addr"`
+	NilAddr *structAddr   `logf:"nil_addr"`
+	At      time.Time     `logf:"at"`
+	Took    time.Duration `logf:"took,omitempty"`
+}
+
+func TestStruct(t *testing.T) {
+	at := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := &structReq{
+		StructBase: StructBase{ID: 7},
+		Method:     "GET",
+		Secret:     "hunter2",
+		private:    "x",
+		Addr:       structAddr{City: "blr", Geo: &structGeo{Lat: 1.5}},
+		At:         at,
+		Took:       time.Second,
+	}
+
+	var nilAddr *structAddr
+	require.Equal(t, []interface{}{
+		"req.id", 7,
+		"req.method", "GET",
+		"req.addr.city", "blr",
+		"req.addr.Geo.Lat", 1.5,
+		"req.addr.Geo.Deep", struct{ Level int }{},
+		"req.nil_addr", nilAddr,
+		"req.at", at,
+		"req.took", time.Second,
+	}, Struct("req", r))
+
+	// Without a prefix and a depth of 1, nested structs are values.
+	fields := StructDepth("", *r, 1)
+	require.Equal(t, "addr", fields[4])
+	require.Equal(t, r.Addr, fields[5])
+
+	// Values that aren't structs are a single pair.
+	require.Equal(t, []interface{}{"t", at}, Struct("t", at))
+	require.Equal(t, []interface{}{"n", 1}, Struct("n", 1))
+	require.Equal(t, []interface{}{"nil", nil}, Struct("nil", nil))
+
+	buf := &bytes.Buffer{}
+	l := New(Opts{Writer: buf})
+	l.Info("hello world", Struct("req", r)...)
+	require.Contains(t, buf.String(), `req.id=7 req.method=GET req.addr.city=blr req.addr.Geo.Lat=1.5`)
+	require.NotContains(t, buf.String(), "hunter2")
+}
+
+type structNode struct {
+	*structNode
+	Val int
+}
+
+func TestStructEmbeddedCycle(t *testing.T) {
+	n := &structNode{structNode: &structNode{Val: 2}, Val: 1}
+	require.Equal(t, []interface{}{"n.Val", 1}, Struct("n", n))
+}